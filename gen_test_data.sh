@@ -47,3 +47,8 @@ while read -r command; do
     echo "$I: $command" >&2
     I=$((I + 1))
 done < "$DIR/test_commands"
+
+# Two full streams concatenated in one file, as written by some backup tools
+sudo btrfs send "$FS_SNAP_DIR/000" > "$TMP_DIR/full-000.snap"
+sudo btrfs send "$FS_SNAP_DIR/001" > "$TMP_DIR/full-001.snap"
+cat "$TMP_DIR/full-000.snap" "$TMP_DIR/full-001.snap" > "$TEST_DATA_DIR/concat-full.snap"
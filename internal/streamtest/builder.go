@@ -0,0 +1,256 @@
+// Package streamtest synthesizes btrfs send streams in memory, so that tests can cover cases which are
+// hard to reproduce on a real filesystem
+package streamtest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"github.com/cmaster11/btrfs-diff/pkg"
+	"github.com/pkg/errors"
+	"hash/crc32"
+	"time"
+)
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Attr is a raw attribute of a stream command
+type Attr struct {
+	Type uint16
+	Data []byte
+}
+
+func AttrString(attrType uint16, s string) Attr {
+	return Attr{attrType, []byte(s)}
+}
+
+func AttrUint64(attrType uint16, v uint64) Attr {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, v)
+	return Attr{attrType, b}
+}
+
+func AttrUint32(attrType uint16, v uint32) Attr {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, v)
+	return Attr{attrType, b}
+}
+
+func AttrTime(attrType uint16, t time.Time) Attr {
+	b := make([]byte, 12)
+	binary.LittleEndian.PutUint64(b[:8], uint64(t.Unix()))
+	binary.LittleEndian.PutUint32(b[8:12], uint32(t.Nanosecond()))
+	return Attr{attrType, b}
+}
+
+// Builder synthesizes a btrfs send stream (v1 by default). Commands carry their attributes in the
+// same order the kernel emits them.
+type Builder struct {
+	buf     bytes.Buffer
+	err     error
+	version uint32
+}
+
+func NewBuilder() *Builder {
+	return NewBuilderVersion(pkg.BTRFS_SEND_STREAM_VERSION)
+}
+
+// NewBuilderVersion is like NewBuilder, for another protocol version, e.g. 2 for streams
+// with encoded writes
+func NewBuilderVersion(version uint32) *Builder {
+	b := &Builder{version: version}
+	b.buf.WriteString(pkg.BTRFS_SEND_STREAM_MAGIC)
+	b.buf.WriteByte(0)
+	_ = binary.Write(&b.buf, binary.LittleEndian, version)
+	return b
+}
+
+// Bytes returns the stream built so far, or the first error encountered while building it
+func (b *Builder) Bytes() ([]byte, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	return b.buf.Bytes(), nil
+}
+
+// Command appends a raw command to the stream
+func (b *Builder) Command(cmdType uint16, attrs ...Attr) *Builder {
+	var data bytes.Buffer
+	for _, attr := range attrs {
+		// Since version 2, the data has no length and runs to the end of the command
+		if attr.Type == pkg.BTRFS_SEND_A_DATA && b.version >= 2 {
+			_ = binary.Write(&data, binary.LittleEndian, attr.Type)
+			data.Write(attr.Data)
+			continue
+		}
+		if len(attr.Data) > 0xffff {
+			b.err = errors.Errorf("attribute %d too long: %d bytes", attr.Type, len(attr.Data))
+			return b
+		}
+		_ = binary.Write(&data, binary.LittleEndian, attr.Type)
+		_ = binary.Write(&data, binary.LittleEndian, uint16(len(attr.Data)))
+		data.Write(attr.Data)
+	}
+
+	cmd := make([]byte, 10+data.Len())
+	binary.LittleEndian.PutUint32(cmd[0:4], uint32(data.Len()))
+	binary.LittleEndian.PutUint16(cmd[4:6], cmdType)
+	copy(cmd[10:], data.Bytes())
+	// The checksum is calculated with the checksum field itself zeroed
+	binary.LittleEndian.PutUint32(cmd[6:10], ^crc32.Update(0xffffffff, crc32cTable, cmd))
+
+	b.buf.Write(cmd)
+	return b
+}
+
+func (b *Builder) uuidAttr(attrType uint16, uuid string) Attr {
+	data, err := hex.DecodeString(uuid)
+	if err != nil && b.err == nil {
+		b.err = errors.Wrapf(err, "invalid uuid %s", uuid)
+	}
+	return Attr{attrType, data}
+}
+
+func (b *Builder) Subvol(path string, uuid string, ctransid uint64) *Builder {
+	return b.Command(pkg.BTRFS_SEND_C_SUBVOL,
+		AttrString(pkg.BTRFS_SEND_A_PATH, path),
+		b.uuidAttr(pkg.BTRFS_SEND_A_UUID, uuid),
+		AttrUint64(pkg.BTRFS_SEND_A_CTRANSID, ctransid),
+	)
+}
+
+func (b *Builder) Snapshot(path string, uuid string, ctransid uint64, cloneUUID string, cloneCTransid uint64) *Builder {
+	return b.Command(pkg.BTRFS_SEND_C_SNAPSHOT,
+		AttrString(pkg.BTRFS_SEND_A_PATH, path),
+		b.uuidAttr(pkg.BTRFS_SEND_A_UUID, uuid),
+		AttrUint64(pkg.BTRFS_SEND_A_CTRANSID, ctransid),
+		b.uuidAttr(pkg.BTRFS_SEND_A_CLONE_UUID, cloneUUID),
+		AttrUint64(pkg.BTRFS_SEND_A_CLONE_CTRANSID, cloneCTransid),
+	)
+}
+
+func (b *Builder) MkFile(path string, ino uint64) *Builder {
+	return b.Command(pkg.BTRFS_SEND_C_MKFILE, AttrString(pkg.BTRFS_SEND_A_PATH, path), AttrUint64(pkg.BTRFS_SEND_A_INO, ino))
+}
+
+func (b *Builder) MkDir(path string, ino uint64) *Builder {
+	return b.Command(pkg.BTRFS_SEND_C_MKDIR, AttrString(pkg.BTRFS_SEND_A_PATH, path), AttrUint64(pkg.BTRFS_SEND_A_INO, ino))
+}
+
+func (b *Builder) MkNod(path string, ino uint64, mode uint64, rdev uint64) *Builder {
+	return b.Command(pkg.BTRFS_SEND_C_MKNOD,
+		AttrString(pkg.BTRFS_SEND_A_PATH, path),
+		AttrUint64(pkg.BTRFS_SEND_A_INO, ino),
+		// Same order as the kernel
+		AttrUint64(pkg.BTRFS_SEND_A_RDEV, rdev),
+		AttrUint64(pkg.BTRFS_SEND_A_MODE, mode),
+	)
+}
+
+func (b *Builder) Symlink(path string, ino uint64, pathLink string) *Builder {
+	return b.Command(pkg.BTRFS_SEND_C_SYMLINK,
+		AttrString(pkg.BTRFS_SEND_A_PATH, path),
+		AttrUint64(pkg.BTRFS_SEND_A_INO, ino),
+		AttrString(pkg.BTRFS_SEND_A_PATH_LINK, pathLink),
+	)
+}
+
+func (b *Builder) Rename(from string, to string) *Builder {
+	return b.Command(pkg.BTRFS_SEND_C_RENAME, AttrString(pkg.BTRFS_SEND_A_PATH, from), AttrString(pkg.BTRFS_SEND_A_PATH_TO, to))
+}
+
+func (b *Builder) Link(path string, pathLink string) *Builder {
+	return b.Command(pkg.BTRFS_SEND_C_LINK, AttrString(pkg.BTRFS_SEND_A_PATH, path), AttrString(pkg.BTRFS_SEND_A_PATH_LINK, pathLink))
+}
+
+func (b *Builder) Unlink(path string) *Builder {
+	return b.Command(pkg.BTRFS_SEND_C_UNLINK, AttrString(pkg.BTRFS_SEND_A_PATH, path))
+}
+
+func (b *Builder) Rmdir(path string) *Builder {
+	return b.Command(pkg.BTRFS_SEND_C_RMDIR, AttrString(pkg.BTRFS_SEND_A_PATH, path))
+}
+
+func (b *Builder) Write(path string, offset uint64, data []byte) *Builder {
+	return b.Command(pkg.BTRFS_SEND_C_WRITE,
+		AttrString(pkg.BTRFS_SEND_A_PATH, path),
+		AttrUint64(pkg.BTRFS_SEND_A_FILE_OFFSET, offset),
+		Attr{pkg.BTRFS_SEND_A_DATA, data},
+	)
+}
+
+// EncodedWrite appends an encoded write (version 2), whose compressed data fills fileLen bytes of the file
+func (b *Builder) EncodedWrite(path string, offset uint64, fileLen uint64, compression uint32, data []byte) *Builder {
+	return b.Command(pkg.BTRFS_SEND_C_ENCODED_WRITE,
+		AttrString(pkg.BTRFS_SEND_A_PATH, path),
+		AttrUint64(pkg.BTRFS_SEND_A_FILE_OFFSET, offset),
+		AttrUint64(pkg.BTRFS_SEND_A_UNENCODED_FILE_LEN, fileLen),
+		AttrUint64(pkg.BTRFS_SEND_A_UNENCODED_LEN, fileLen),
+		AttrUint64(pkg.BTRFS_SEND_A_UNENCODED_OFFSET, 0),
+		AttrUint32(pkg.BTRFS_SEND_A_COMPRESSION, compression),
+		AttrUint32(pkg.BTRFS_SEND_A_ENCRYPTION, 0),
+		Attr{pkg.BTRFS_SEND_A_DATA, data},
+	)
+}
+
+// Clone appends a clone command, with attributes in the kernel order (path after offset and length)
+func (b *Builder) Clone(path string, offset uint64, length uint64, cloneUUID string, cloneCTransid uint64, clonePath string, cloneOffset uint64) *Builder {
+	return b.Command(pkg.BTRFS_SEND_C_CLONE,
+		AttrUint64(pkg.BTRFS_SEND_A_FILE_OFFSET, offset),
+		AttrUint64(pkg.BTRFS_SEND_A_CLONE_LEN, length),
+		AttrString(pkg.BTRFS_SEND_A_PATH, path),
+		b.uuidAttr(pkg.BTRFS_SEND_A_CLONE_UUID, cloneUUID),
+		AttrUint64(pkg.BTRFS_SEND_A_CLONE_CTRANSID, cloneCTransid),
+		AttrString(pkg.BTRFS_SEND_A_CLONE_PATH, clonePath),
+		AttrUint64(pkg.BTRFS_SEND_A_CLONE_OFFSET, cloneOffset),
+	)
+}
+
+func (b *Builder) UpdateExtent(path string, offset uint64, size uint64) *Builder {
+	return b.Command(pkg.BTRFS_SEND_C_UPDATE_EXTENT,
+		AttrString(pkg.BTRFS_SEND_A_PATH, path),
+		AttrUint64(pkg.BTRFS_SEND_A_FILE_OFFSET, offset),
+		AttrUint64(pkg.BTRFS_SEND_A_SIZE, size),
+	)
+}
+
+func (b *Builder) Truncate(path string, size uint64) *Builder {
+	return b.Command(pkg.BTRFS_SEND_C_TRUNCATE, AttrString(pkg.BTRFS_SEND_A_PATH, path), AttrUint64(pkg.BTRFS_SEND_A_SIZE, size))
+}
+
+func (b *Builder) Chmod(path string, mode uint64) *Builder {
+	return b.Command(pkg.BTRFS_SEND_C_CHMOD, AttrString(pkg.BTRFS_SEND_A_PATH, path), AttrUint64(pkg.BTRFS_SEND_A_MODE, mode))
+}
+
+func (b *Builder) Chown(path string, uid uint64, gid uint64) *Builder {
+	return b.Command(pkg.BTRFS_SEND_C_CHOWN,
+		AttrString(pkg.BTRFS_SEND_A_PATH, path),
+		AttrUint64(pkg.BTRFS_SEND_A_UID, uid),
+		AttrUint64(pkg.BTRFS_SEND_A_GID, gid),
+	)
+}
+
+func (b *Builder) Utimes(path string, atime time.Time, mtime time.Time, ctime time.Time) *Builder {
+	return b.Command(pkg.BTRFS_SEND_C_UTIMES,
+		AttrString(pkg.BTRFS_SEND_A_PATH, path),
+		AttrTime(pkg.BTRFS_SEND_A_ATIME, atime),
+		AttrTime(pkg.BTRFS_SEND_A_MTIME, mtime),
+		AttrTime(pkg.BTRFS_SEND_A_CTIME, ctime),
+	)
+}
+
+func (b *Builder) SetXattr(path string, name string, data []byte) *Builder {
+	return b.Command(pkg.BTRFS_SEND_C_SET_XATTR,
+		AttrString(pkg.BTRFS_SEND_A_PATH, path),
+		AttrString(pkg.BTRFS_SEND_A_XATTR_NAME, name),
+		Attr{pkg.BTRFS_SEND_A_XATTR_DATA, data},
+	)
+}
+
+func (b *Builder) RemoveXattr(path string, name string) *Builder {
+	return b.Command(pkg.BTRFS_SEND_C_REMOVE_XATTR, AttrString(pkg.BTRFS_SEND_A_PATH, path), AttrString(pkg.BTRFS_SEND_A_XATTR_NAME, name))
+}
+
+func (b *Builder) End() *Builder {
+	return b.Command(pkg.BTRFS_SEND_C_END)
+}
@@ -1,55 +1,505 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"github.com/cmaster11/btrfs-diff/pkg"
+	"github.com/cmaster11/btrfs-diff/pkg/diffpb"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"google.golang.org/grpc"
+	"net"
 	"os"
+	"os/signal"
 	"regexp"
+	"strings"
+	"time"
 )
 
 var rootCmd *cobra.Command
 
+// Every flag can get its default from an env var, e.g. --no-dir-mtime from BTRFS_DIFF_NO_DIR_MTIME
+const envPrefix = "BTRFS_DIFF_"
+
 var argIgnore []string
+var argIgnoreFile string
+var argIgnoreFileGlob bool
+var argFormat string
 var argJSON bool
+var argJSONErrors bool
+var argDOT bool
+var argSortBy string
+var argOrder string
+var argRecoveryManifest bool
+var argNoDirMTime bool
+var argStrictTypes bool
+var argSecurity bool
+var argChurnOnly bool
+var argLeavesOnly bool
+var argXattrPrefix string
+var argOffset int
+var argCount int
+var argTop int
+var argBytes string
+var argCountOnly bool
+var argByExtension bool
+var argRenamesOnly bool
+var argAudit bool
+var argAuditRules string
+var argAlertThreshold int
+var argBaseline string
+var argJSONStyle string
+var argColorJSON bool
+var argNoNewline bool
+var argMoves bool
+var argCoalesceAtomicSaves bool
+var argAtomicSavePattern string
+var argFollowHardlinks bool
+var argExpectParent string
+var argExpected string
+var argOp string
+var argPrint0 bool
+var argMinChangePct float64
+var argTimeout time.Duration
+var argRootLabel string
+var argCaptureTimes bool
+var argIgnoreTimestamps bool
+var argSkipUnknownTypes bool
+var argStrict bool
+var argStopAfter int
+var argTripwire string
+var argOverlay bool
+var argMergeConflicts bool
+var argPrefixSubvolume bool
+var argRelativeTime bool
+var argRelativeTimeRef string
+var argShowInode bool
+var argDecodeACLs bool
+var argParseOnly bool
+var argProgress bool
+var argWatchInterval time.Duration
+var argWatchPattern string
+var argWatchFormat string
+var argServeListen string
 
 func init() {
 	rootCmd = &cobra.Command{
-		Args: cobra.MatchAll(cobra.ExactArgs(1)),
+		Args: cobra.MatchAll(cobra.MinimumNArgs(1)),
+		// Persistent, so that the subcommands (e.g. watch) get their defaults from the env as well
+		PersistentPreRunE: loadEnvDefaults,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			argFile := args[0]
+			if argParseOnly {
+				p := &pkg.Processor{}
+				for _, fileName := range args {
+					if err := parseOnlyFile(p, fileName); err != nil {
+						return errors.Wrapf(err, "failed to parse file %s", fileName)
+					}
+				}
+				return nil
+			}
 
 			var ignorePaths pkg.DiffIgnorePaths
 
 			for _, reStr := range argIgnore {
 				ignorePaths = append(ignorePaths, regexp.MustCompile(reStr))
 			}
+			if argIgnoreFile != "" {
+				filePaths, err := pkg.LoadDiffIgnorePaths(argIgnoreFile, argIgnoreFileGlob)
+				if err != nil {
+					return errors.Wrapf(err, "invalid ignore file %s", argIgnoreFile)
+				}
+				ignorePaths = append(ignorePaths, filePaths...)
+			}
 
 			processArgs := &pkg.ProcessFileWithOutputArgs{
-				ArgFile:     argFile,
+				ArgFiles:    args,
 				IgnorePaths: ignorePaths,
-				JSON:        argJSON,
+				Format:      argFormat,
+				SortBy:      argSortBy,
+				NoDirMTime:  argNoDirMTime,
+				StrictTypes: argStrictTypes,
+				Security:    argSecurity,
+				ChurnOnly:   argChurnOnly,
+				XattrPrefix: argXattrPrefix,
+				Offset:      argOffset,
+				Count:       argCount,
+				Top:         argTop,
+				Bytes:       argBytes,
+				CountOnly:   argCountOnly,
+				JSONStyle:   argJSONStyle,
+				ColorJSON:   argColorJSON && colorsEnabled(os.Stdout),
+				NoNewline:   argNoNewline,
+				Moves:       argMoves,
+				Op:          argOp,
+				Print0:      argPrint0,
+				RootLabel:   argRootLabel,
+
+				ExpectParent:        argExpectParent,
+				CoalesceAtomicSaves: argCoalesceAtomicSaves,
+				FollowHardlinks:     argFollowHardlinks,
+
+				MinChangePct: argMinChangePct,
+				LeavesOnly:   argLeavesOnly,
+
+				AlertThreshold: argAlertThreshold,
+				ShowInode:      argShowInode,
+				ByExtension:    argByExtension,
+				RenamesOnly:    argRenamesOnly,
+				Audit:          argAudit,
+				DecodeACLs:     argDecodeACLs,
+			}
+
+			if argBaseline != "" {
+				baseline, err := pkg.ReadBaseline(argBaseline)
+				if err != nil {
+					return err
+				}
+				processArgs.Baseline = baseline
+			}
+
+			if argExpected != "" {
+				expected, err := pkg.LoadDiffExpected(argExpected)
+				if err != nil {
+					return err
+				}
+				processArgs.Expected = expected
+			}
+
+			if argAuditRules != "" {
+				rules, err := pkg.LoadAuditRules(argAuditRules)
+				if err != nil {
+					return err
+				}
+				processArgs.AuditRules = append(append([]*pkg.AuditRule{}, pkg.DefaultAuditRules...), rules...)
+				// The rules are only used by the audit output
+				processArgs.Audit = true
+			}
+
+			switch argOrder {
+			case "":
+			case pkg.DiffSortByRestore:
+				processArgs.SortBy = pkg.DiffSortByRestore
+			default:
+				return errors.Errorf("unsupported order %q", argOrder)
 			}
 
+			// Deprecated aliases of --format
 			if argJSON {
-				pkg.InfoMode = false
-				pkg.DebugMode = false
+				processArgs.Format = pkg.OutputFormatJSON
+			} else if argDOT {
+				processArgs.Format = pkg.OutputFormatDOT
+			} else if argRecoveryManifest {
+				processArgs.Format = pkg.OutputFormatRecoveryManifest
+			}
+
+			if argRelativeTime {
+				ref := time.Now()
+				if argRelativeTimeRef != "" {
+					var err error
+					ref, err = time.Parse(time.RFC3339, argRelativeTimeRef)
+					if err != nil {
+						return errors.Wrapf(err, "invalid relative time reference")
+					}
+				}
+				processArgs.RelativeTimeRef = &ref
+			}
+
+			if argOffset < 0 || argCount < 0 || argTop < 0 || argStopAfter < 0 || argAlertThreshold < 0 {
+				return errors.New("offset, count, top, stop-after and alert-threshold cannot be negative")
 			}
 
-			if err := pkg.ProcessFileAndOutput(processArgs); err != nil {
+			p := pkg.NewProcessor()
+			if processArgs.Format != pkg.OutputFormatText || processArgs.CountOnly || processArgs.ByExtension || processArgs.RenamesOnly || processArgs.Audit {
+				p.InfoLogger, p.DebugLogger = nil, nil
+			}
+			p.CaptureTimestamps = argCaptureTimes
+			p.IgnoreTimestamps = argIgnoreTimestamps
+			p.SkipUnknownTypes = argSkipUnknownTypes
+			p.Strict = argStrict
+			p.StopAfter = argStopAfter
+			p.Overlay = argOverlay
+			p.MergeConflictingNodes = argMergeConflicts
+			p.PrefixSubvolume = argPrefixSubvolume
+			if argAtomicSavePattern != pkg.DefaultAtomicSavePattern {
+				pattern, err := regexp.Compile(argAtomicSavePattern)
+				if err != nil {
+					return errors.Wrapf(err, "invalid atomic save pattern")
+				}
+				processArgs.AtomicSavePattern = pattern
+			}
+			if argTripwire != "" {
+				tripwire, err := regexp.Compile(argTripwire)
+				if err != nil {
+					return errors.Wrapf(err, "invalid tripwire")
+				}
+				p.Tripwire = tripwire
+			}
+			if argProgress {
+				p.OnProgress = func(progress pkg.Progress) {
+					_, _ = fmt.Fprintf(os.Stderr, "progress: %s\n", progress)
+				}
+			}
+			processArgs.Processor = p
+
+			ctx := context.Background()
+			if argTimeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, argTimeout)
+				defer cancel()
+			}
+
+			if err := pkg.ProcessFileAndOutputContext(ctx, processArgs); err != nil {
+				if errors.Is(err, context.DeadlineExceeded) {
+					return errors.Errorf("processing timed out after %s", argTimeout)
+				}
 				return errors.Wrapf(err, "failed to process snapshot file")
 			}
 			return nil
 		},
 	}
+	rootCmd.AddCommand(&cobra.Command{
+		Use:   "dump DIFF_FILE...",
+		Short: "print the raw command sequence of the streams, with all their attributes",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			p := &pkg.Processor{}
+			for _, fileName := range args {
+				if err := dumpFile(p, fileName); err != nil {
+					return errors.Wrapf(err, "failed to dump file %s", fileName)
+				}
+			}
+			return nil
+		},
+	})
+	watchCmd := &cobra.Command{
+		Use:   "watch DIR",
+		Short: "apply the stream files appearing in a directory to a running tree, printing the cumulative diff after each one",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			outputArgs := &pkg.ProcessFileWithOutputArgs{Format: argWatchFormat}
+			p := pkg.NewProcessor()
+			if argWatchFormat != pkg.OutputFormatText {
+				p.InfoLogger, p.DebugLogger = nil, nil
+			}
+
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+			defer stop()
+
+			w := &pkg.Watcher{
+				Processor: p,
+				Dir:       args[0],
+				Pattern:   argWatchPattern,
+				Interval:  argWatchInterval,
+				OnApply: func(fileName string, d *pkg.Diff) error {
+					return pkg.WriteDiff(os.Stdout, d, outputArgs)
+				},
+			}
+			if err := w.Run(ctx); err != nil && !errors.Is(err, context.Canceled) {
+				return errors.Wrapf(err, "failed to watch directory %s", args[0])
+			}
+			return nil
+		},
+	}
+	watchCmd.Flags().DurationVar(&argWatchInterval, "interval", time.Second, "how often to check the directory for new files")
+	watchCmd.Flags().StringVar(&argWatchPattern, "pattern", "*.snap", "glob of the stream file names")
+	watchCmd.Flags().StringVar(&argWatchFormat, "format", pkg.OutputFormatText, fmt.Sprintf("output format: %s", strings.Join(pkg.FormatNames(), "|")))
+	rootCmd.AddCommand(watchCmd)
+	serveCmd := &cobra.Command{
+		Use:   "serve",
+		Short: "serve the gRPC DiffService of pkg/diff.proto, which processes uploaded streams and streams back their diff",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			lis, err := net.Listen("tcp", argServeListen)
+			if err != nil {
+				return errors.Wrapf(err, "failed to listen on %s", argServeListen)
+			}
+			server := grpc.NewServer()
+			diffpb.RegisterDiffServiceServer(server, &pkg.DiffServer{Processor: &pkg.Processor{}})
+
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+			defer stop()
+			go func() {
+				<-ctx.Done()
+				server.GracefulStop()
+			}()
+
+			if err := server.Serve(lis); err != nil {
+				return errors.Wrap(err, "failed to serve")
+			}
+			return nil
+		},
+	}
+	serveCmd.Flags().StringVar(&argServeListen, "listen", "localhost:50051", "address to listen on")
+	rootCmd.AddCommand(serveCmd)
+	// All the errors are printed by main, as json if requested
+	for _, cmd := range append(rootCmd.Commands(), rootCmd) {
+		cmd.SilenceErrors = true
+		cmd.SilenceUsage = true
+	}
+
 	rootCmd.Flags().StringArrayVar(&argIgnore, "ignore", []string{}, "regex list of node paths to ignore")
+	rootCmd.Flags().StringVar(&argIgnoreFile, "ignore-file", "", "if defined, also ignore the node paths matching the regexes in this file, one per line (blank lines and # comments are skipped)")
+	rootCmd.Flags().BoolVar(&argIgnoreFileGlob, "ignore-file-glob", false, "if defined, the patterns of --ignore-file are globs (e.g. /var/log/*.log), also matching the descendants of the matched paths")
+	rootCmd.Flags().StringVar(&argFormat, "format", pkg.OutputFormatText, fmt.Sprintf("output format: %s", strings.Join(pkg.FormatNames(), "|")))
 	rootCmd.Flags().BoolVar(&argJSON, "json", false, "if defined, output json instead of debug logging")
+	rootCmd.Flags().BoolVar(&argJSONErrors, "json-errors", false, "if defined, print fatal errors to stderr as a json object with the byte offset where parsing failed, if any, e.g. {\"error\":\"...\",\"offset\":30} (implied by --format json|ndjson)")
+	rootCmd.Flags().StringVar(&argJSONStyle, "json-style", pkg.JSONStyleCompact, "json output: compact|pretty")
+	rootCmd.Flags().BoolVar(&argColorJSON, "color-json", false, "if defined, colorize the json output when writing to a terminal, unless NO_COLOR is set")
+	rootCmd.Flags().StringVar(&argOp, "op", "", "json, ndjson and names output: only output the nodes which have been added|changed|deleted")
+	rootCmd.Flags().BoolVar(&argPrint0, "print0", false, "names output: separate the paths with null bytes instead of newlines (e.g. for xargs -0)")
+	rootCmd.Flags().BoolVar(&argCoalesceAtomicSaves, "coalesce-atomic-saves", false, "if defined, report files replaced by the atomic save of an editor (renamed over from a temporary file, or with a deleted backup) as modified")
+	rootCmd.Flags().StringVar(&argAtomicSavePattern, "atomic-save-pattern", pkg.DefaultAtomicSavePattern, "regex of the names of the temporary and backup files of atomic saves, see --coalesce-atomic-saves")
+	rootCmd.Flags().BoolVar(&argFollowHardlinks, "follow-hardlinks", false, "if defined, report the content changes of a file on all its other links in the diff (with a known inode, e.g. created or linked ones), marked as changed via hardlink")
+	rootCmd.Flags().BoolVar(&argMoves, "moves", false, "json output: report renamed nodes as moved (from/to), instead of added and deleted")
+	rootCmd.Flags().BoolVar(&argNoNewline, "no-newline", false, "if defined, do not end the output with a newline")
+	rootCmd.Flags().BoolVar(&argNoDirMTime, "no-dir-mtime", false, "if defined, hide directories which only had metadata changes (created/deleted ones are kept)")
+	rootCmd.Flags().Float64Var(&argMinChangePct, "min-change-pct", 0, "if defined, only output files with at least this percentage of their final size written (files with an unknown size are hidden)")
+	rootCmd.Flags().BoolVar(&argParseOnly, "parse-only", false, "if defined, only read and decode the commands of the streams, without building any diff, and report the parsing throughput to stderr")
+	rootCmd.Flags().BoolVar(&argProgress, "progress", false, "if defined, report the progress of the processing to stderr, with a percentage when reading regular files")
+	rootCmd.Flags().DurationVar(&argTimeout, "timeout", 0, "if defined, abort if processing takes longer than this (e.g. 30s)")
+	rootCmd.Flags().StringVar(&argXattrPrefix, "xattr-prefix", "", "if defined, only output nodes which had an xattr with this prefix (e.g. security.) set or removed")
+	rootCmd.Flags().BoolVar(&argChurnOnly, "churn-only", false, "if defined, only output nodes which have been both created and deleted (e.g. temporary files across a chain of streams)")
+	rootCmd.Flags().BoolVar(&argLeavesOnly, "leaves-only", false, "if defined, hide created directories containing created nodes (e.g. the parents of mkdir -p), only showing the deepest created paths")
+	rootCmd.Flags().BoolVar(&argSecurity, "security", false, "if defined, only output nodes with security relevant changes (e.g. gained executable bit)")
+	rootCmd.Flags().StringVar(&argExpected, "expected", "", "if defined, only output the unexpected nodes, not covered by this manifest: a json list of paths and glob patterns, or a json diff")
+	rootCmd.Flags().StringVar(&argExpectParent, "expect-parent", "", "if defined, fail before processing if the (first) stream has not been sent from the snapshot with this uuid")
+	rootCmd.Flags().BoolVar(&argStrictTypes, "strict-types", false, "if defined, fail if any node in the output has an unknown type")
+	rootCmd.Flags().BoolVar(&argCaptureTimes, "capture-times", false, "if defined, process timestamp changes (utimes), which are ignored by default")
+	rootCmd.Flags().BoolVar(&argIgnoreTimestamps, "ignore-timestamps", false, "if defined, always ignore timestamp changes (utimes), even with --capture-times, e.g. to compare the contents of backups")
+	rootCmd.Flags().BoolVar(&argSkipUnknownTypes, "skip-unknown-types", false, "if defined, skip commands with unknown types (e.g. vendor-specific ones) instead of failing")
+	rootCmd.Flags().StringVar(&argTripwire, "tripwire", "", fmt.Sprintf("if defined, stop processing with exit code %d as soon as a path matching this regex is deleted", exitCodeTripwire))
+	rootCmd.Flags().BoolVar(&argStrict, "strict", false, "if defined, fail on commands with params which have not been read (e.g. unknown attributes added by newer kernels)")
+	rootCmd.Flags().IntVar(&argStopAfter, "stop-after", 0, "if defined, stop processing right after the Nth command (from 1) and output the partial tree, e.g. to bisect which command breaks it")
+	rootCmd.Flags().BoolVar(&argMergeConflicts, "merge-conflicts", false, "if defined, merge nodes added on top of existing ones (e.g. in malformed streams) with a warning, instead of failing")
+	rootCmd.Flags().BoolVar(&argOverlay, "overlay", false, "if defined, interpret the stream as an overlayfs upper layer: whiteouts become deletions, and opaque dirs are marked")
+	rootCmd.Flags().BoolVar(&argRelativeTime, "relative-time", false, "if defined, show captured timestamps relative to now in text output (json is always absolute)")
+	rootCmd.Flags().StringVar(&argRelativeTimeRef, "relative-time-ref", "", "RFC3339 reference time for --relative-time, instead of now")
+	rootCmd.Flags().BoolVar(&argShowInode, "show-inode", false, "if defined, show the inode numbers of the nodes in text output, when known (only created, renamed and linked nodes carry one)")
+	rootCmd.Flags().BoolVar(&argDecodeACLs, "decode-acls", false, "if defined, show the POSIX ACLs set on the nodes (system.posix_acl_access and _default xattrs) decoded in text output, e.g. [acl=user::rw-,group::r--,other::r--]")
+	rootCmd.Flags().IntVar(&argTop, "top", 0, "text output: end with the N files with the most bytes written")
+	rootCmd.Flags().StringVar(&argBytes, "bytes", "", "text and diff-stat output: show amounts of bytes as human|raw (default human), json always has raw bytes")
+	rootCmd.Flags().StringVar(&argBaseline, "baseline", "", fmt.Sprintf("if defined, the fingerprint (or a file with it, see --format fingerprint) of a known-good diff: output nothing if the diff has the same one, otherwise output it and exit with code %d", exitCodeBaselineChanged))
+	rootCmd.Flags().IntVar(&argAlertThreshold, "alert-threshold", 0, fmt.Sprintf("if defined, exit with code %d after the output if there are more reportable nodes than this (e.g. for anomaly detection)", exitCodeAlertThreshold))
+	rootCmd.Flags().BoolVar(&argCountOnly, "count-only", false, "if defined, only output the amount of added, changed and deleted nodes (as json with --format json)")
+	rootCmd.Flags().BoolVar(&argByExtension, "by-extension", false, "if defined, only output the amount of files and bytes written by file extension, the most common first (as json with --format json)")
+	rootCmd.Flags().BoolVar(&argAudit, "audit", false, "if defined, only output the suspicious permission changes (world-writable files, setuid scripts, owners changed to root, writable /etc files), with their severity and rule (as json with --format json)")
+	rootCmd.Flags().StringVar(&argAuditRules, "audit-rules", "", "if defined, also audit with the rules in this file, one per line: a path regex, the forbidden octal mode bits and an optional low|medium|high severity, e.g. ^/srv/www/ 0022 high (implies --audit)")
+	rootCmd.Flags().BoolVar(&argRenamesOnly, "renames-only", false, "if defined, only output the nodes which have only been renamed (not modified), as from -> to lines (as json with --format json)")
+	rootCmd.Flags().BoolVar(&argPrefixSubvolume, "prefix-subvolume", false, "if defined, prefix the paths in the output with the path of the (last) received subvolume, e.g. /home/user/file, to combine the reports of multiple subvolumes")
+	rootCmd.Flags().StringVar(&argRootLabel, "root-label", pkg.DiffRootLabelSlash, "how to show the root of the subvolume: /|.|subvolume")
+	rootCmd.Flags().StringVar(&argSortBy, "sort-by", "", "sort the output nodes by: changes|path|bytes|restore")
+	rootCmd.Flags().StringVar(&argOrder, "order", "", "restore: order the output so that it can be safely applied, deletions children first, then creations parents first (same as --sort-by restore)")
+	rootCmd.Flags().IntVar(&argOffset, "offset", 0, "json output: skip the first N nodes (added, then changed, then deleted)")
+	rootCmd.Flags().IntVar(&argCount, "count", 0, "json output: output at most N nodes, 0 for no limit")
+	rootCmd.Flags().BoolVar(&argDOT, "dot", false, "if defined, output a graphviz dot graph of the diff tree")
+	rootCmd.Flags().BoolVar(&argRecoveryManifest, "recovery-manifest", false, "if defined, output only the deleted nodes as TYPE<TAB>PATH lines, parents first")
+
+	_ = rootCmd.Flags().MarkDeprecated("json", "use --format json")
+	_ = rootCmd.Flags().MarkDeprecated("dot", "use --format dot")
+	_ = rootCmd.Flags().MarkDeprecated("recovery-manifest", "use --format recovery-manifest")
+}
+
+// loadEnvDefaults sets all the flags not defined on the command line from their env vars, if any.
+// Repeatable flags (e.g. --ignore) take one value per line.
+func loadEnvDefaults(cmd *cobra.Command, _ []string) error {
+	var err error
+	cmd.Flags().VisitAll(func(f *pflag.Flag) {
+		if err != nil || f.Changed {
+			return
+		}
+		envName := envPrefix + strings.ToUpper(strings.ReplaceAll(f.Name, "-", "_"))
+		value, ok := os.LookupEnv(envName)
+		if !ok {
+			return
+		}
+
+		values := []string{value}
+		if f.Value.Type() == "stringArray" {
+			values = strings.Split(strings.TrimSpace(value), "\n")
+		}
+		for _, v := range values {
+			if setErr := cmd.Flags().Set(f.Name, v); setErr != nil {
+				err = errors.Wrapf(setErr, "invalid value for env var %s", envName)
+				return
+			}
+		}
+	})
+	return err
+}
+
+// colorsEnabled returns whether colors can be written to the file, only if it is a terminal and
+// colors have not been disabled with NO_COLOR (see https://no-color.org)
+func colorsEnabled(f *os.File) bool {
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	stat, err := f.Stat()
+	return err == nil && stat.Mode()&os.ModeCharDevice != 0
+}
+
+func dumpFile(p *pkg.Processor, fileName string) error {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return errors.Wrap(err, "failed to open file")
+	}
+	defer f.Close()
+	return p.Dump(f, os.Stdout)
+}
+
+// parseOnlyFile decodes all the commands of a stream file, reporting the throughput to stderr
+func parseOnlyFile(p *pkg.Processor, fileName string) error {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return errors.Wrap(err, "failed to open file")
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return errors.Wrap(err, "failed to stat file")
+	}
+
+	start := time.Now()
+	count, err := p.CountCommands(f)
+	if err != nil {
+		return err
+	}
+	elapsed := time.Since(start).Seconds()
+	_, _ = fmt.Fprintf(os.Stderr, "%s: %d commands, %d bytes in %.3fs (%.0f commands/s, %.2f MB/s)\n",
+		fileName, count, stat.Size(), elapsed, float64(count)/elapsed, float64(stat.Size())/1e6/elapsed)
+	return nil
+}
+
+// exitCodeTripwire is the exit code when a path watched by --tripwire has been deleted
+const exitCodeTripwire = 3
+
+// exitCodeAlertThreshold is the exit code when the diff has more nodes than --alert-threshold
+const exitCodeAlertThreshold = 4
+
+// exitCodeBaselineChanged is the exit code when the fingerprint of the diff differs from --baseline
+const exitCodeBaselineChanged = 5
+
+// jsonErrors returns whether fatal errors are printed as json, see --json-errors
+func jsonErrors() bool {
+	return argJSONErrors || argJSON || argFormat == pkg.OutputFormatJSON || argFormat == pkg.OutputFormatNDJSON
 }
 
 func main() {
 	if err := rootCmd.Execute(); err != nil {
-		_, _ = fmt.Fprintln(os.Stderr, err)
+		if jsonErrors() {
+			_ = pkg.WriteErrorJSON(os.Stderr, err)
+		} else {
+			_, _ = fmt.Fprintln(os.Stderr, err)
+		}
+		if errors.Is(err, pkg.ErrTripwireTriggered) {
+			os.Exit(exitCodeTripwire)
+		}
+		if errors.Is(err, pkg.ErrAlertThresholdExceeded) {
+			os.Exit(exitCodeAlertThreshold)
+		}
+		if errors.Is(err, pkg.ErrBaselineChanged) {
+			os.Exit(exitCodeBaselineChanged)
+		}
 		os.Exit(1)
 	}
 }
@@ -3,9 +3,11 @@ package main
 import (
 	"fmt"
 	"github.com/cmaster11/btrfs-diff/pkg"
+	"github.com/spf13/cobra"
 	"github.com/stretchr/testify/require"
 	"os"
 	"path"
+	"path/filepath"
 	"strings"
 	"testing"
 )
@@ -75,7 +77,7 @@ func TestDiff(t *testing.T) {
 		{"echo yep > dir/subdir/yep", []*Expect{{ETypeCreated, "/dir/subdir/yep"}}},
 		{"echo leaf > dir/subdir/leafdir/leaf", []*Expect{{ETypeCreated, "/dir/subdir/leafdir/leaf"}}},
 		{"mv dir topdir", []*Expect{{ETypeCreated, "/topdir"}, {ETypeDeleted, "/dir"}}},
-		{"rm -rf topdir", []*Expect{{ETypeDeleted, "/topdir"}, {ETypeDeleted, "/topdir/hardlink.rn"}, {ETypeDeleted, "/topdir/fifo.rn"}, {ETypeDeleted, "/topdir/symlink.rn"}, {ETypeDeleted, "/topdir/file_to_del"}, {ETypeDeleted, "/topdir/file"}}},
+		{"rm -rf topdir", []*Expect{{ETypeDeleted, "/topdir"}, {ETypeDeleted, "/topdir/hardlink.rn"}, {ETypeDeleted, "/topdir/fifo.rn"}, {ETypeDeleted, "/topdir/symlink.rn"}, {ETypeDeleted, "/topdir/file_to_del"}, {ETypeDeleted, "/topdir/file"}, {ETypeDeleted, "/topdir/subdir"}, {ETypeDeleted, "/topdir/subdir/yep"}, {ETypeDeleted, "/topdir/subdir/leafdir"}, {ETypeDeleted, "/topdir/subdir/leafdir/leaf"}}},
 	}
 
 	{
@@ -93,6 +95,7 @@ func TestDiff(t *testing.T) {
 				snapFile := fmt.Sprintf("%s/%s-%03d.snap", testDir, prefix, idx+1)
 				diff, err := pkg.ProcessFile(snapFile)
 				require.NoError(t, err)
+				require.NoError(t, diff.Validate())
 
 				diffStr := diff.GetDiffStruct(nil)
 				printExpect(diffStr)
@@ -130,3 +133,38 @@ func TestDiff(t *testing.T) {
 	}
 
 }
+
+func TestLoadEnvDefaults(t *testing.T) {
+	run := func(args ...string) string {
+		var value string
+		parent := &cobra.Command{Use: "parent", PersistentPreRunE: loadEnvDefaults}
+		child := &cobra.Command{Use: "child", RunE: func(cmd *cobra.Command, args []string) error { return nil }}
+		child.Flags().StringVar(&value, "some-flag", "default", "")
+		parent.AddCommand(child)
+		parent.SetArgs(append([]string{"child"}, args...))
+		require.NoError(t, parent.Execute())
+		return value
+	}
+
+	require.Equal(t, "default", run())
+	t.Setenv(envPrefix+"SOME_FLAG", "env")
+	require.Equal(t, "env", run())
+	require.Equal(t, "flag", run("--some-flag", "flag"))
+
+	// The subcommands of the cli read the env too
+	t.Setenv(envPrefix+"INTERVAL", "not-a-duration")
+	rootCmd.SetArgs([]string{"watch", t.TempDir()})
+	t.Cleanup(func() { rootCmd.SetArgs(nil) })
+	require.ErrorContains(t, rootCmd.Execute(), "invalid value for env var BTRFS_DIFF_INTERVAL")
+}
+
+func TestAuditRulesImplyAudit(t *testing.T) {
+	rulesFile := filepath.Join(t.TempDir(), "rules.txt")
+	require.NoError(t, os.WriteFile(rulesFile, []byte("^/srv/ 0020 high\n"), 0644))
+	t.Cleanup(func() { argAuditRules, argFormat = "", pkg.OutputFormatText })
+
+	// The audit output does not support the dot format, so the rules are not silently ignored
+	rootCmd.SetArgs([]string{"--audit-rules", rulesFile, "--format", pkg.OutputFormatDOT, path.Join(testDir, "inc-001.snap")})
+	t.Cleanup(func() { rootCmd.SetArgs(nil) })
+	require.ErrorContains(t, rootCmd.Execute(), "audit output is not supported with the dot format")
+}
@@ -0,0 +1,34 @@
+package pkg
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+)
+
+// ErrAlertThresholdExceeded is matched (see errors.Is) by the AlertThresholdError returned when a diff
+// has more reportable nodes than allowed
+var ErrAlertThresholdExceeded = errors.New("alert threshold exceeded")
+
+// AlertThresholdError reports an unusual amount of changes, e.g. caused by ransomware or a runaway process
+type AlertThresholdError struct {
+	// The amount of reportable nodes (added, changed and deleted)
+	Count     int
+	Threshold int
+}
+
+func (e *AlertThresholdError) Error() string {
+	return fmt.Sprintf("%s: %d reportable nodes, more than %d", ErrAlertThresholdExceeded, e.Count, e.Threshold)
+}
+
+func (e *AlertThresholdError) Is(target error) bool {
+	return target == ErrAlertThresholdExceeded
+}
+
+// CheckAlertThreshold returns an AlertThresholdError if the diff has more than threshold reportable
+// nodes, counted like Diff.WriteCounts
+func (d *Diff) CheckAlertThreshold(ignore DiffNodeMatcher, threshold int) error {
+	if count := d.Stats(ignore).Total(); count > threshold {
+		return &AlertThresholdError{count, threshold}
+	}
+	return nil
+}
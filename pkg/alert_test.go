@@ -0,0 +1,25 @@
+package pkg_test
+
+import (
+	"fmt"
+	"github.com/cmaster11/btrfs-diff/pkg"
+	"github.com/stretchr/testify/require"
+	"regexp"
+	"testing"
+)
+
+func TestAlertThreshold(t *testing.T) {
+	diff, err := pkg.ProcessFile(fmt.Sprintf("%s/inc-008.snap", testDir))
+	require.NoError(t, err)
+
+	require.NoError(t, diff.CheckAlertThreshold(nil, 3))
+	err = diff.CheckAlertThreshold(nil, 2)
+	require.ErrorIs(t, err, pkg.ErrAlertThresholdExceeded)
+	var alertErr *pkg.AlertThresholdError
+	require.ErrorAs(t, err, &alertErr)
+	require.Equal(t, 3, alertErr.Count)
+
+	// Only reportable nodes count
+	args := &pkg.ProcessFileWithOutputArgs{IgnorePaths: pkg.DiffIgnorePaths{regexp.MustCompile("^/bar/baz_file$")}}
+	require.NoError(t, diff.CheckAlertThreshold(args.IgnoreMatcher(), 2))
+}
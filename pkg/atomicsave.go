@@ -0,0 +1,92 @@
+package pkg
+
+import (
+	"regexp"
+)
+
+// DefaultAtomicSavePattern matches the names of the temporary and backup files of common editors and
+// tools saving files atomically: gedit (.goutputstream-XXXXXX), sed -i (sedXXXXXX), vim and emacs
+// backups (file~), JetBrains IDEs (file___jb_tmp___, file___jb_old___) and generic .tmp files
+const DefaultAtomicSavePattern = `^(\.goutputstream-\w+|sed\w{6}|.+~|.+\.tmp|.+___jb_(tmp|old)___)$`
+
+var defaultAtomicSaveRegex = regexp.MustCompile(DefaultAtomicSavePattern)
+
+// CoalesceAtomicSaves turns the files replaced by an atomic save into plain modified files, returning
+// how many have been coalesced. A file is coalesced only if it existed before, and it has been either
+// renamed over from a temporary file in the same directory, or its previous version renamed to a
+// backup file there which has been deleted afterwards. The names of the temporary and backup files
+// have to match the pattern (DefaultAtomicSavePattern if nil), and these files are removed from the
+// diff. The changes reported are only the ones done to the real file.
+//
+// Streams only carry the final state of the snapshot, so the temporary files created and renamed
+// between two snapshots never show up: these files are replaced (deleted and created again), and
+// are left as they are, as nothing tells them apart from files really deleted and created again.
+func (d *Diff) CoalesceAtomicSaves(pattern *regexp.Regexp) int {
+	if pattern == nil {
+		pattern = defaultAtomicSaveRegex
+	}
+
+	// Only files replacing a pre-existing one
+	var replaced []*DiffNode
+	d.root.traverse(func(n *DiffNode) {
+		if n.State == opCreate && n.DeletedInSnapshot && n.NodeType != DiffNodeTypeDir && n.TypeChange() == nil {
+			replaced = append(replaced, n)
+		}
+	})
+
+	count := 0
+	for _, n := range replaced {
+		leftovers := n.atomicSaveLeftovers(pattern)
+		if len(leftovers) == 0 {
+			continue
+		}
+
+		isLeftover := make(map[*DiffNode]bool)
+		for _, l := range leftovers {
+			isLeftover[l] = true
+			if err := l.removeFromParent(); err != nil {
+				d.proc().info("failed to remove atomic save leftover %s: %s", l, err)
+			}
+		}
+
+		// The relations of the previous version (e.g. its rename to the backup file) go as well
+		var relations []*DiffNodeRelation
+		for _, rel := range n.Relations {
+			if isLeftover[rel.Node] || rel.Node.isBTRFSTemporaryNode() {
+				continue
+			}
+			relations = append(relations, rel)
+		}
+		n.Relations = relations
+		n.State = opModify
+		n.CreatedInSnapshot = false
+		n.DeletedInSnapshot = false
+		n.DeleteCause = ""
+		n.previous = nil
+
+		d.proc().info("coalesced atomic save of %s", n)
+		count++
+	}
+	return count
+}
+
+// atomicSaveLeftovers returns the temporary and backup files of an atomic save of the node, if any
+func (n *DiffNode) atomicSaveLeftovers(pattern *regexp.Regexp) []*DiffNode {
+	var leftovers []*DiffNode
+	if tmp := n.renameSource(); tmp != nil {
+		// Any other rename over an existing file is a real change
+		if tmp.Parent != n.Parent || !pattern.MatchString(tmp.Path) {
+			return nil
+		}
+		leftovers = append(leftovers, tmp)
+	}
+
+	if n.previous != nil {
+		for _, sibling := range n.Parent.Children {
+			if sibling.State == opDelete && pattern.MatchString(sibling.Path) && sibling.renameSource() == n.previous {
+				leftovers = append(leftovers, sibling)
+			}
+		}
+	}
+	return leftovers
+}
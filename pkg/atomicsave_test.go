@@ -0,0 +1,66 @@
+package pkg_test
+
+import (
+	"bytes"
+	"github.com/cmaster11/btrfs-diff/pkg"
+	"github.com/stretchr/testify/require"
+	"regexp"
+	"testing"
+)
+
+func TestCoalesceAtomicSaves(t *testing.T) {
+	stream := buildStream(t, newTestStream().
+		// gedit: a temporary file renamed over the real one, which is orphanized first
+		Rename("dir/real", "o260-5-0").
+		Rename("dir/.goutputstream-ABC123", "dir/real").
+		Unlink("o260-5-0").
+		Write("dir/real", 0, []byte("new")).
+		// vim: the real file renamed to a backup, which is deleted once the new file is written
+		Rename("notes.txt", "notes.txt~").
+		MkFile("o261-5-0", 261).
+		Rename("o261-5-0", "notes.txt").
+		Write("notes.txt", 0, []byte("notes")).
+		Unlink("notes.txt~").
+		// A regular rename over an existing file
+		Unlink("b").
+		Rename("a", "b").
+		// A replaced file, with nothing telling that it has been saved atomically
+		Unlink("c").
+		MkFile("o262-5-0", 262).
+		Rename("o262-5-0", "c").
+		End())
+
+	paths := func(nodes []*pkg.DiffNode) []string {
+		var out []string
+		for _, n := range nodes {
+			out = append(out, n.DisplayPath())
+		}
+		return out
+	}
+
+	diff, err := pkg.ProcessBTRFSStream(bytes.NewReader(stream))
+	require.NoError(t, err)
+	s := diff.GetDiffStruct(nil)
+	require.ElementsMatch(t, []string{"/dir/real", "/notes.txt", "/b", "/c"}, paths(s.Added))
+	require.Contains(t, paths(s.Deleted), "/dir/.goutputstream-ABC123")
+
+	require.Equal(t, 2, diff.CoalesceAtomicSaves(nil))
+	s = diff.GetDiffStruct(nil)
+	require.ElementsMatch(t, []string{"/b", "/c"}, paths(s.Added))
+	require.ElementsMatch(t, []string{"/dir/real", "/notes.txt"}, paths(s.Changed))
+	require.ElementsMatch(t, []string{"/a", "/b", "/c"}, paths(s.Deleted))
+	for _, n := range s.Changed {
+		require.Empty(t, n.Relations)
+		require.Empty(t, n.RenameHistory())
+	}
+	require.Equal(t, []string{"write:offset=0:data_len=5"}, diff.FlatMap(nil)["/notes.txt"].Changes)
+
+	// Coalescing again changes nothing
+	require.Equal(t, 0, diff.CoalesceAtomicSaves(nil))
+
+	// Only temporary files matching the pattern are coalesced
+	diff, err = pkg.ProcessBTRFSStream(bytes.NewReader(stream))
+	require.NoError(t, err)
+	require.Equal(t, 1, diff.CoalesceAtomicSaves(regexp.MustCompile(`^\.goutputstream-\w+$`)))
+	require.Equal(t, []string{"/dir/real"}, paths(diff.GetDiffStruct(nil).Changed))
+}
@@ -0,0 +1,188 @@
+package pkg
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type DiffAuditSeverity = string
+
+const (
+	DiffAuditSeverityLow    DiffAuditSeverity = "low"
+	DiffAuditSeverityMedium DiffAuditSeverity = "medium"
+	DiffAuditSeverityHigh   DiffAuditSeverity = "high"
+)
+
+// IDs of the built-in audit rules, see DefaultAuditRules
+const (
+	AuditRuleWorldWritable = "world-writable"
+	AuditRuleSetuidScript  = "setuid-script"
+	AuditRuleChownRoot     = "chown-root"
+	AuditRuleEtcWritable   = "etc-writable"
+)
+
+// scriptExtensions are the extensions of the files considered scripts even if the stream does not
+// show their first bytes
+var scriptExtensions = map[string]bool{".sh": true, ".bash": true, ".py": true, ".pl": true, ".rb": true, ".php": true}
+
+// AuditRule flags the nodes with suspicious changes, see Diff.Audit
+type AuditRule struct {
+	ID       string
+	Severity DiffAuditSeverity
+	// Check returns why the node is flagged, or an empty string
+	Check func(n *DiffNode) string
+}
+
+// DiffAuditFinding is a node flagged by an audit rule
+type DiffAuditFinding struct {
+	Rule     string            `json:"rule"`
+	Severity DiffAuditSeverity `json:"severity"`
+	Path     string            `json:"path"`
+	Message  string            `json:"message"`
+}
+
+// DefaultAuditRules are the built-in audit rules: world-writable files, setuid/setgid scripts, existing
+// nodes changing owner to root and /etc nodes becoming writable by group or others. Modes and owners
+// are only known when changed by the stream, so only the changed ones are checked.
+var DefaultAuditRules = []*AuditRule{
+	{AuditRuleWorldWritable, DiffAuditSeverityHigh, func(n *DiffNode) string {
+		// Sticky directories (e.g. /tmp) are meant to be shared
+		if n.mode == nil || *n.mode&0002 == 0 || n.NodeType == DiffNodeTypeDir && *n.mode&01000 != 0 {
+			return ""
+		}
+		return fmt.Sprintf("mode %04o is world writable", *n.mode)
+	}},
+	{AuditRuleSetuidScript, DiffAuditSeverityHigh, func(n *DiffNode) string {
+		if n.mode == nil || *n.mode&06000 == 0 || !n.shebang && !scriptExtensions[path.Ext(n.Path)] {
+			return ""
+		}
+		return fmt.Sprintf("script with mode %04o runs as its owner or group", *n.mode)
+	}},
+	{AuditRuleChownRoot, DiffAuditSeverityMedium, func(n *DiffNode) string {
+		if n.uid == nil || *n.uid != 0 || n.CreatedInSnapshot {
+			return ""
+		}
+		return "owner changed to root"
+	}},
+	{AuditRuleEtcWritable, DiffAuditSeverityMedium, func(n *DiffNode) string {
+		if n.mode == nil || *n.mode&0022 == 0 || !isPathUnder(n.GetChainPath(), "/etc") {
+			return ""
+		}
+		return fmt.Sprintf("mode %04o is writable by group or others", *n.mode)
+	}},
+}
+
+// forbiddenModeRule returns a rule flagging the nodes matching pattern with any of the bits of mode
+func forbiddenModeRule(id string, severity DiffAuditSeverity, pattern *regexp.Regexp, mode uint64) *AuditRule {
+	return &AuditRule{id, severity, func(n *DiffNode) string {
+		if n.mode == nil || *n.mode&mode == 0 || !pattern.MatchString(n.GetChainPath()) {
+			return ""
+		}
+		return fmt.Sprintf("mode %04o has forbidden bits %04o", *n.mode, *n.mode&mode)
+	}}
+}
+
+// ParseAuditRules reads custom audit rules, one per line as a path regex, the octal mode bits forbidden
+// on the matching nodes and optionally the severity (medium by default), e.g. `^/srv/www/ 0022 high`.
+// Blank lines and lines starting with # are skipped. Rules are named after their line, e.g. "custom:3".
+func ParseAuditRules(r io.Reader) ([]*AuditRule, error) {
+	var rules []*AuditRule
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, errors.Errorf("invalid rule on line %d: expected a pattern, mode bits and an optional severity", line)
+		}
+		re, err := regexp.Compile(fields[0])
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid pattern on line %d", line)
+		}
+		mode, err := strconv.ParseUint(fields[1], 8, 32)
+		if err != nil || mode == 0 || mode > 07777 {
+			return nil, errors.Errorf("invalid mode bits %q on line %d", fields[1], line)
+		}
+		severity := DiffAuditSeverityMedium
+		if len(fields) == 3 {
+			switch fields[2] {
+			case DiffAuditSeverityLow, DiffAuditSeverityMedium, DiffAuditSeverityHigh:
+				severity = fields[2]
+			default:
+				return nil, errors.Errorf("unsupported severity %q on line %d", fields[2], line)
+			}
+		}
+		rules = append(rules, forbiddenModeRule(fmt.Sprintf("custom:%d", line), severity, re, mode))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to read audit rules")
+	}
+	return rules, nil
+}
+
+// LoadAuditRules reads custom audit rules from a file, see ParseAuditRules
+func LoadAuditRules(fileName string) ([]*AuditRule, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open audit rules file")
+	}
+	defer f.Close()
+	return ParseAuditRules(f)
+}
+
+// Audit checks all the reportable nodes which have not been deleted against the rules
+// (DefaultAuditRules if nil), returning the findings sorted by path, then in the order of the rules
+func (d *Diff) Audit(ignore DiffNodeMatcher, rules []*AuditRule) []*DiffAuditFinding {
+	if rules == nil {
+		rules = DefaultAuditRules
+	}
+	var findings []*DiffAuditFinding
+	d.traverseReportable(ignore, func(n *DiffNode) {
+		if n.State == opDelete || n.NodeType == DiffNodeTypeSymLink {
+			return
+		}
+		for _, rule := range rules {
+			if msg := rule.Check(n); msg != "" {
+				findings = append(findings, &DiffAuditFinding{rule.ID, rule.Severity, n.DisplayPath(), msg})
+			}
+		}
+	})
+	sort.SliceStable(findings, func(i, j int) bool {
+		return findings[i].Path < findings[j].Path
+	})
+	return findings
+}
+
+// WriteAudit writes the findings of Audit, one line each with the severity, the rule and the path (e.g.
+// "high    world-writable /srv/file: mode 0777 is world writable"), or as json
+func (d *Diff) WriteAudit(w io.Writer, ignore DiffNodeMatcher, rules []*AuditRule, asJSON bool) error {
+	findings := d.Audit(ignore, rules)
+	if asJSON {
+		if findings == nil {
+			findings = []*DiffAuditFinding{}
+		}
+		out, err := json.Marshal(findings)
+		if err != nil {
+			return errors.Wrap(err, "failed to marshal audit findings")
+		}
+		_, err = fmt.Fprintf(w, "%s\n", out)
+		return err
+	}
+	for _, f := range findings {
+		if _, err := fmt.Fprintf(w, "%-7s %s %s: %s\n", f.Severity, f.Rule, f.Path, f.Message); err != nil {
+			return err
+		}
+	}
+	return nil
+}
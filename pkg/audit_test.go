@@ -0,0 +1,61 @@
+package pkg_test
+
+import (
+	"bytes"
+	"github.com/cmaster11/btrfs-diff/pkg"
+	"github.com/stretchr/testify/require"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestAudit(t *testing.T) {
+	diff, err := pkg.ProcessBTRFSStream(bytes.NewReader(buildStream(t, newTestStream().
+		Chmod("srv/file", 0777).
+		MkDir("tmp", 257).
+		Chmod("tmp", 01777).
+		MkFile("o258-7-0", 258).
+		Rename("o258-7-0", "bin/run").
+		Write("bin/run", 0, []byte("#!/bin/sh\n")).
+		Chmod("bin/run", 04755).
+		Chmod("usr/bin/tool.sh", 02755).
+		Write("usr/bin/binary", 0, []byte("\x7fELF")).
+		Chmod("usr/bin/binary", 04755).
+		Chown("etc/shadow", 0, 0).
+		Chmod("etc/passwd", 0664).
+		MkFile("o259-7-0", 259).
+		Rename("o259-7-0", "new").
+		Chown("new", 0, 0).
+		End())))
+	require.NoError(t, err)
+
+	require.Equal(t, []*pkg.DiffAuditFinding{
+		{Rule: pkg.AuditRuleSetuidScript, Severity: pkg.DiffAuditSeverityHigh, Path: "/bin/run", Message: "script with mode 4755 runs as its owner or group"},
+		{Rule: pkg.AuditRuleEtcWritable, Severity: pkg.DiffAuditSeverityMedium, Path: "/etc/passwd", Message: "mode 0664 is writable by group or others"},
+		{Rule: pkg.AuditRuleChownRoot, Severity: pkg.DiffAuditSeverityMedium, Path: "/etc/shadow", Message: "owner changed to root"},
+		{Rule: pkg.AuditRuleWorldWritable, Severity: pkg.DiffAuditSeverityHigh, Path: "/srv/file", Message: "mode 0777 is world writable"},
+		{Rule: pkg.AuditRuleSetuidScript, Severity: pkg.DiffAuditSeverityHigh, Path: "/usr/bin/tool.sh", Message: "script with mode 2755 runs as its owner or group"},
+	}, diff.Audit(nil, nil))
+
+	rules, err := pkg.ParseAuditRules(strings.NewReader("# no group writable web files\n^/srv/ 0020 high\n\n^/usr/ 06000\n"))
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	args := &pkg.ProcessFileWithOutputArgs{Audit: true, AuditRules: rules}
+	require.NoError(t, pkg.WriteDiff(&buf, diff, args))
+	require.Equal(t, `high    custom:2 /srv/file: mode 0777 has forbidden bits 0020
+medium  custom:4 /usr/bin/binary: mode 4755 has forbidden bits 4000
+medium  custom:4 /usr/bin/tool.sh: mode 2755 has forbidden bits 2000
+`, buf.String())
+
+	buf.Reset()
+	args = &pkg.ProcessFileWithOutputArgs{Audit: true, Format: pkg.OutputFormatJSON, IgnorePaths: pkg.DiffIgnorePaths{regexp.MustCompile("^/(bin|etc|usr)/")}}
+	require.NoError(t, pkg.WriteDiff(&buf, diff, args))
+	require.JSONEq(t, `[{"rule":"world-writable","severity":"high","path":"/srv/file","message":"mode 0777 is world writable"}]`, buf.String())
+
+	for _, invalid := range []string{"^/srv/", "^/srv/ 0999", "^/srv/ 0 high", "^/srv/ 0020 critical", "[ 0020"} {
+		_, err := pkg.ParseAuditRules(strings.NewReader(invalid))
+		require.Error(t, err, invalid)
+	}
+	require.Error(t, pkg.WriteDiff(&buf, diff, &pkg.ProcessFileWithOutputArgs{Audit: true, Format: pkg.OutputFormatNames}))
+	require.Error(t, pkg.WriteDiff(&buf, diff, &pkg.ProcessFileWithOutputArgs{Audit: true, RenamesOnly: true}))
+}
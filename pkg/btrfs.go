@@ -5,7 +5,9 @@ import (
 	"encoding/binary"
 	"encoding/hex"
 	"fmt"
+	"io"
 	"strings"
+	"sync"
 	"time"
 	"unicode/utf8"
 )
@@ -43,6 +45,14 @@ type commandInst struct {
 	OriginalType uint16
 	Type         *commandMapOp
 	data         []byte
+	// The protocol version of the stream
+	version uint32
+	// The pooled buffer holding data, see release
+	buf  *[]byte
+	proc *Processor
+	// Only populated by ReadAllParams
+	params     map[int]interface{}
+	paramOrder []int
 }
 
 // initCommandsDefinitions initialize the commands mapping with operations
@@ -80,20 +90,22 @@ func initCommandsDefinitions() *[BTRFS_SEND_C_MAX_PLUS_ONE]commandMapOp {
 	commandsDefs[BTRFS_SEND_C_END] = commandMapOp{Name: "BTRFS_SEND_C_END", Op: opEnd}
 	commandsDefs[BTRFS_SEND_C_UPDATE_EXTENT] = commandMapOp{Name: "BTRFS_SEND_C_UPDATE_EXTENT", Op: opModify}
 
+	/* Version 2 */
+	commandsDefs[BTRFS_SEND_C_ENCODED_WRITE] = commandMapOp{Name: "BTRFS_SEND_C_ENCODED_WRITE", Op: opModify}
+
 	// --- Unsupported V2/3
 
 	/* Version 2 */
 	commandsDefs[BTRFS_SEND_C_FALLOCATE] = commandMapOp{Name: "BTRFS_SEND_C_FALLOCATE", Op: opIgnore}
 	commandsDefs[BTRFS_SEND_C_FILEATTR] = commandMapOp{Name: "BTRFS_SEND_C_FILEATTR", Op: opIgnore}
-	commandsDefs[BTRFS_SEND_C_ENCODED_WRITE] = commandMapOp{Name: "BTRFS_SEND_C_ENCODED_WRITE", Op: opIgnore}
 
 	/* Version 3 */
 	commandsDefs[BTRFS_SEND_C_ENABLE_VERITY] = commandMapOp{Name: "BTRFS_SEND_C_ENABLE_VERITY", Op: opIgnore}
 
-	// Sanity check (hopefully no holes).
+	// Sanity check: a hole would only show up as a broken command on first use
 	for i, command := range commandsDefs {
 		if i != BTRFS_SEND_C_UNSPEC && command.Op == opUnspec {
-			return nil
+			panic(fmt.Sprintf("initCommandsDefinitions: missing definition of command type %d", i))
 		}
 	}
 	return &commandsDefs
@@ -123,9 +135,19 @@ func attrConverterBytes(b []byte) interface{} {
 		isUTF8: utf8.Valid(b),
 	}
 }
+func attrConverterData(b []byte) interface{} {
+	// File contents are always previewed as binary, even if they happen to be valid UTF-8
+	return &bytesData{
+		bytes:  b,
+		isUTF8: false,
+	}
+}
 func attrConverterUint64(b []byte) interface{} {
 	return binary.LittleEndian.Uint64(b)
 }
+func attrConverterUint32(b []byte) interface{} {
+	return binary.LittleEndian.Uint32(b)
+}
 func attrConverterString(b []byte) interface{} {
 	return string(b)
 }
@@ -150,8 +172,8 @@ func attrConverterTime(b []byte) interface{} {
 }
 
 // initAttributeDefinitions initialize the attribute mapping with their debugging names
-func initAttributeDefinitions() *[BTRFS_SEND_A_MAX_V1_PLUS_ONE]attrMapping {
-	var attrDefs [BTRFS_SEND_A_MAX_V1_PLUS_ONE]attrMapping
+func initAttributeDefinitions() *[BTRFS_SEND_A_MAX_V2_PLUS_ONE]attrMapping {
+	var attrDefs [BTRFS_SEND_A_MAX_V2_PLUS_ONE]attrMapping
 
 	attrDefs[BTRFS_SEND_A_UNSPEC] = attrMapping{"BTRFS_SEND_A_UNSPEC", nil}
 	attrDefs[BTRFS_SEND_A_UUID] = attrMapping{"BTRFS_SEND_A_UUID", attrConverterUUID}
@@ -172,17 +194,26 @@ func initAttributeDefinitions() *[BTRFS_SEND_A_MAX_V1_PLUS_ONE]attrMapping {
 	attrDefs[BTRFS_SEND_A_PATH_TO] = attrMapping{"BTRFS_SEND_A_PATH_TO", attrConverterPath}
 	attrDefs[BTRFS_SEND_A_PATH_LINK] = attrMapping{"BTRFS_SEND_A_PATH_LINK", attrConverterPathLink}
 	attrDefs[BTRFS_SEND_A_FILE_OFFSET] = attrMapping{"BTRFS_SEND_A_FILE_OFFSET", attrConverterUint64}
-	attrDefs[BTRFS_SEND_A_DATA] = attrMapping{"BTRFS_SEND_A_DATA", attrConverterBytes}
+	attrDefs[BTRFS_SEND_A_DATA] = attrMapping{"BTRFS_SEND_A_DATA", attrConverterData}
 	attrDefs[BTRFS_SEND_A_CLONE_UUID] = attrMapping{"BTRFS_SEND_A_CLONE_UUID", attrConverterUUID}
 	attrDefs[BTRFS_SEND_A_CLONE_CTRANSID] = attrMapping{"BTRFS_SEND_A_CLONE_CTRANSID", attrConverterUint64}
 	attrDefs[BTRFS_SEND_A_CLONE_PATH] = attrMapping{"BTRFS_SEND_A_CLONE_PATH", attrConverterPath}
 	attrDefs[BTRFS_SEND_A_CLONE_OFFSET] = attrMapping{"BTRFS_SEND_A_CLONE_OFFSET", attrConverterUint64}
 	attrDefs[BTRFS_SEND_A_CLONE_LEN] = attrMapping{"BTRFS_SEND_A_CLONE_LEN", attrConverterUint64}
 
-	// Sanity check (hopefully no holes).
+	/* Version 2 */
+	attrDefs[BTRFS_SEND_A_FALLOCATE_MODE] = attrMapping{"BTRFS_SEND_A_FALLOCATE_MODE", attrConverterUint32}
+	attrDefs[BTRFS_SEND_A_FILEATTR] = attrMapping{"BTRFS_SEND_A_FILEATTR", attrConverterUint64}
+	attrDefs[BTRFS_SEND_A_UNENCODED_FILE_LEN] = attrMapping{"BTRFS_SEND_A_UNENCODED_FILE_LEN", attrConverterUint64}
+	attrDefs[BTRFS_SEND_A_UNENCODED_LEN] = attrMapping{"BTRFS_SEND_A_UNENCODED_LEN", attrConverterUint64}
+	attrDefs[BTRFS_SEND_A_UNENCODED_OFFSET] = attrMapping{"BTRFS_SEND_A_UNENCODED_OFFSET", attrConverterUint64}
+	attrDefs[BTRFS_SEND_A_COMPRESSION] = attrMapping{"BTRFS_SEND_A_COMPRESSION", attrConverterUint32}
+	attrDefs[BTRFS_SEND_A_ENCRYPTION] = attrMapping{"BTRFS_SEND_A_ENCRYPTION", attrConverterUint32}
+
+	// Sanity check: a hole would only show up as a broken attribute on first use
 	for i, attr := range attrDefs {
 		if i != BTRFS_SEND_A_UNSPEC && attr.converter == nil {
-			return nil
+			panic(fmt.Sprintf("initAttributeDefinitions: missing definition of attribute type %d", i))
 		}
 	}
 	return &attrDefs
@@ -190,61 +221,152 @@ func initAttributeDefinitions() *[BTRFS_SEND_A_MAX_V1_PLUS_ONE]attrMapping {
 
 // do the initialization of the commands mapping
 var commandsDefs *[BTRFS_SEND_C_MAX_PLUS_ONE]commandMapOp = initCommandsDefinitions()
-var attrDefs *[BTRFS_SEND_A_MAX_V1_PLUS_ONE]attrMapping = initAttributeDefinitions()
+var attrDefs *[BTRFS_SEND_A_MAX_V2_PLUS_ONE]attrMapping = initAttributeDefinitions()
+
+// maxPooledCommandSize is the size of the biggest command buffers kept in commandBufferPool, so that
+// a few huge commands do not pin their memory
+const maxPooledCommandSize = 1 << 20
+
+// commandBufferPool recycles the buffers holding the data of the commands, which are otherwise
+// allocated for every command bigger than the read buffer (e.g. all the writes)
+var commandBufferPool = sync.Pool{
+	New: func() interface{} {
+		return new([]byte)
+	},
+}
 
 // readCommand return a command from reading and parsing the stream input
-func readCommand(input *bufio.Reader) (*commandInst, error) {
-	cmdSizeB, err := peekAndDiscard(input, 4)
-	if err != nil {
-		return nil, fmt.Errorf("short read on command size: %v", err)
-	}
-	cmdSize := binary.LittleEndian.Uint32(cmdSizeB)
-	// debug("command size: '%v' (%v)", cmdSize, cmdSizeB)
-	cmdTypeB, err := peekAndDiscard(input, 2)
-	if err != nil {
-		return nil, fmt.Errorf("short read on command type: %v", err)
-	}
-	cmdType := binary.LittleEndian.Uint16(cmdTypeB)
-	// debug("command type: '%v' (%v)", cmdType, cmdTypeB)
-	if cmdType > BTRFS_SEND_C_MAX {
-		return nil, fmt.Errorf("stream contains invalid command type %v", cmdType)
-	}
-	_, err = peekAndDiscard(input, 4)
-	if err != nil {
-		return nil, fmt.Errorf("short read on command checksum: %v", err)
-	}
-	cmdData, err := peekAndDiscard(input, int(cmdSize))
-	if err != nil {
-		return nil, fmt.Errorf("short read on command data: %v", err)
+func (p *Processor) readCommand(input *bufio.Reader, version uint32) (*commandInst, error) {
+	var cmdSize uint32
+	var cmdType uint16
+	// Unknown commands are skipped until a known one, without recursing over runs of them
+	for {
+		cmdSizeB, err := peekAndDiscard(input, 4)
+		if err != nil {
+			return nil, decodeErrorf("short read on command size: %w", err)
+		}
+		cmdSize = binary.LittleEndian.Uint32(cmdSizeB)
+		if p.MaxCommandSize > 0 && cmdSize > p.MaxCommandSize {
+			return nil, decodeErrorf("command size %d exceeds the maximum of %d", cmdSize, p.MaxCommandSize)
+		}
+		// debug("command size: '%v' (%v)", cmdSize, cmdSizeB)
+		cmdTypeB, err := peekAndDiscard(input, 2)
+		if err != nil {
+			return nil, decodeErrorf("short read on command type: %w", err)
+		}
+		cmdType = binary.LittleEndian.Uint16(cmdTypeB)
+		// debug("command type: '%v' (%v)", cmdType, cmdTypeB)
+		if cmdType <= BTRFS_SEND_C_MAX {
+			break
+		}
+		if !p.SkipUnknownTypes {
+			return nil, decodeErrorf("stream contains invalid command type %v", cmdType)
+		}
+		// The size is still valid for unknown commands, so we can skip over them
+		if _, err := input.Discard(4 + int(cmdSize)); err != nil {
+			return nil, decodeErrorf("short read while skipping unknown command type %v: %w", cmdType, err)
+		}
+		p.info("skipped unknown command type %v [len=%d]", cmdType, cmdSize)
+	}
+	if _, err := peekAndDiscard(input, 4); err != nil {
+		return nil, decodeErrorf("short read on command checksum: %w", err)
+	}
+	buf := commandBufferPool.Get().(*[]byte)
+	if cap(*buf) < int(cmdSize) {
+		*buf = make([]byte, cmdSize)
+	}
+	*buf = (*buf)[:cmdSize]
+	if _, err := io.ReadFull(input, *buf); err != nil {
+		commandBufferPool.Put(buf)
+		return nil, decodeErrorf("short read on command data: %w", err)
 	}
 	return &commandInst{
 		OriginalType: cmdType,
 		Type:         &commandsDefs[cmdType],
-		data:         cmdData,
+		data:         *buf,
+		buf:          buf,
+		proc:         p,
+		version:      version,
 	}, nil
 }
 
+// release returns the data buffer of the command to the pool, once the command has been fully
+// processed. Converted params still referring to the data (e.g. bytesData) must not be used anymore,
+// so anything to keep has to be copied out before.
+func (command *commandInst) release() {
+	if command.buf == nil {
+		return
+	}
+	if cap(*command.buf) <= maxPooledCommandSize {
+		commandBufferPool.Put(command.buf)
+	}
+	command.buf = nil
+	command.data = nil
+	command.params = nil
+}
+
 // ReadParam return a parameter of a command, if it matches the one expected
 func (command *commandInst) ReadParam(expectedType int) (interface{}, error) {
-	if len(command.data) < 4 {
-		return nil, fmt.Errorf("no more parameters")
+	if len(command.data) < 2 {
+		return nil, decodeErrorf("no more parameters")
 	}
 	paramType := binary.LittleEndian.Uint16(command.data[0:2])
 	// debug("param type: '%v' (expected: %v, raw: %v)", attrDefs[paramType].Name, attrDefs[expectedType].Name, command.data[0:2])
 	if int(paramType) != expectedType {
-		return nil, fmt.Errorf("expect type %v; got %v", attrDefs[expectedType].Name, attrDefs[paramType].Name)
+		return nil, decodeErrorf("expect type %v; got %v", attrDefs[expectedType].Name, attrDefs[paramType].Name)
 	}
-	paramLength := binary.LittleEndian.Uint16(command.data[2:4])
-	// debug("param length: '%v' (raw: %v)", paramLength, command.data[2:4])
-	if int(paramLength)+4 > len(command.data) {
-		return nil, fmt.Errorf("short command param; length was %v but only %v left", paramLength, len(command.data)-4)
+
+	// Since version 2, the data has no length and runs to the end of the command
+	headerLength := 2
+	paramLength := len(command.data) - headerLength
+	if paramType != BTRFS_SEND_A_DATA || command.version < 2 {
+		if len(command.data) < 4 {
+			return nil, decodeErrorf("no more parameters")
+		}
+		headerLength = 4
+		paramLength = int(binary.LittleEndian.Uint16(command.data[2:4]))
+		// debug("param length: '%v' (raw: %v)", paramLength, command.data[2:4])
+		if paramLength+4 > len(command.data) {
+			return nil, decodeErrorf("short command param; length was %v but only %v left", paramLength, len(command.data)-4)
+		}
 	}
 
 	attr := attrDefs[paramType]
-	data := command.data[4 : 4+paramLength]
+	data := command.data[headerLength : headerLength+paramLength]
 	converted := attr.converter(data)
-	debugInd(1, "param %s [len=%d]: %v", attr.Name, paramLength, converted)
+	command.proc.debugInd(1, "param %s [len=%d]: %v", attr.Name, paramLength, converted)
 
-	command.data = command.data[4+paramLength:]
+	command.data = command.data[headerLength+paramLength:]
 	return converted, nil
 }
+
+// ReadAllParams reads all the remaining parameters of a command, regardless of their order,
+// making them available through Param
+func (command *commandInst) ReadAllParams() error {
+	command.params = make(map[int]interface{})
+	for len(command.data) > 0 {
+		if len(command.data) < 2 {
+			return decodeErrorf("short command param header; only %v bytes left", len(command.data))
+		}
+		paramType := int(binary.LittleEndian.Uint16(command.data[0:2]))
+		if paramType >= len(attrDefs) || attrDefs[paramType].converter == nil {
+			return decodeErrorf("unsupported param type %v", paramType)
+		}
+		param, err := command.ReadParam(paramType)
+		if err != nil {
+			return err
+		}
+		command.params[paramType] = param
+		command.paramOrder = append(command.paramOrder, paramType)
+	}
+	return nil
+}
+
+// Param return a parameter previously read by ReadAllParams
+func (command *commandInst) Param(paramType int) (interface{}, error) {
+	param, ok := command.params[paramType]
+	if !ok {
+		return nil, decodeErrorf("missing param %v", attrDefs[paramType].Name)
+	}
+	return param, nil
+}
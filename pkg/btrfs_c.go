@@ -129,4 +129,19 @@ const (
 )
 
 const BTRFS_SEND_A_MAX_V1_PLUS_ONE = BTRFS_SEND_A_MAX_V1 + 1
+const BTRFS_SEND_A_MAX_V2_PLUS_ONE = BTRFS_SEND_A_MAX_V2 + 1
 const BTRFS_SEND_A_MAX_PLUS_ONE = __BTRFS_SEND_A_MAX + 1
+
+// Compression of the data of encoded writes (BTRFS_SEND_A_COMPRESSION), from linux kernel btrfs.h
+// https://github.com/torvalds/linux/blob/master/include/uapi/linux/btrfs.h
+const (
+	BTRFS_ENCODED_IO_COMPRESSION_NONE = 0
+	BTRFS_ENCODED_IO_COMPRESSION_ZLIB = 1
+	BTRFS_ENCODED_IO_COMPRESSION_ZSTD = 2
+	// LZO compressed with the sector size of the filesystem, from 4KiB to 64KiB
+	BTRFS_ENCODED_IO_COMPRESSION_LZO_4K  = 3
+	BTRFS_ENCODED_IO_COMPRESSION_LZO_8K  = 4
+	BTRFS_ENCODED_IO_COMPRESSION_LZO_16K = 5
+	BTRFS_ENCODED_IO_COMPRESSION_LZO_32K = 6
+	BTRFS_ENCODED_IO_COMPRESSION_LZO_64K = 7
+)
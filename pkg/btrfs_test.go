@@ -0,0 +1,81 @@
+package pkg_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/cmaster11/btrfs-diff/internal/streamtest"
+	"github.com/cmaster11/btrfs-diff/pkg"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+// TestDefinitions checks that every command and attribute type up to the supported versions has a
+// name, through a stream carrying all of them
+func TestDefinitions(t *testing.T) {
+	builder := streamtest.NewBuilderVersion(2)
+	for cmdType := uint16(1); cmdType <= pkg.BTRFS_SEND_C_MAX; cmdType++ {
+		if cmdType != pkg.BTRFS_SEND_C_END {
+			builder.Command(cmdType)
+		}
+	}
+	var attrs []streamtest.Attr
+	for attrType := uint16(1); attrType <= pkg.BTRFS_SEND_A_MAX_V2; attrType++ {
+		if attrType != pkg.BTRFS_SEND_A_DATA {
+			attrs = append(attrs, streamtest.Attr{Type: attrType, Data: make([]byte, 16)})
+		}
+	}
+	// Since version 2, the data runs to the end of the command
+	attrs = append(attrs, streamtest.Attr{Type: pkg.BTRFS_SEND_A_DATA, Data: make([]byte, 16)})
+	stream := buildStream(t, builder.Command(pkg.BTRFS_SEND_C_WRITE, attrs...).End())
+
+	var out bytes.Buffer
+	require.NoError(t, (&pkg.Processor{}).Dump(bytes.NewReader(stream), &out))
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	// The stream header, all the commands, and the one with all the attributes
+	require.Len(t, lines, 1+pkg.BTRFS_SEND_C_MAX+1)
+	for _, line := range lines {
+		require.NotContains(t, line, "UNKNOWN_")
+		require.NotContains(t, line, " =")
+	}
+	require.Len(t, strings.Fields(lines[len(lines)-2]), 1+pkg.BTRFS_SEND_A_MAX_V2)
+}
+
+func TestEncodedWrite(t *testing.T) {
+	stream, err := streamtest.NewBuilderVersion(2).
+		Snapshot("002", "8ceaf94ac851d346841abc2b82323625", 12, "b4233aaf045b6a4b89a2c08c8c1b4743", 10).
+		MkFile("file", 1).
+		EncodedWrite("file", 0, 8192, pkg.BTRFS_ENCODED_IO_COMPRESSION_ZSTD, make([]byte, 100)).
+		EncodedWrite("file", 8192, 4096, pkg.BTRFS_ENCODED_IO_COMPRESSION_LZO_4K, make([]byte, 50)).
+		EncodedWrite("file", 12288, 4096, pkg.BTRFS_ENCODED_IO_COMPRESSION_ZSTD, make([]byte, 10)).
+		Write("plain", 0, []byte("hello")).
+		End().
+		Bytes()
+	require.NoError(t, err)
+
+	// All the params, the data without length included, are consumed
+	diff, err := (&pkg.Processor{Strict: true}).ProcessStreams(bytes.NewReader(stream))
+	require.NoError(t, err)
+
+	file := diff.FlatMap(nil)["/file"]
+	require.Equal(t, pkg.DiffNodeTypeFile, file.NodeType)
+	require.Equal(t, uint64(16384), file.TotalBytesWritten())
+	require.Equal(t, []string{"write:offset=0:data_len=16384"}, file.Changes)
+	require.Equal(t, []string{pkg.DiffCompressionZstd, pkg.DiffCompressionLZO}, file.CompressionTypes())
+	require.Equal(t, pkg.DiffCompressionLZO, file.Extents[1].Compression)
+
+	jsonBytes, err := json.Marshal(file)
+	require.NoError(t, err)
+	require.Contains(t, string(jsonBytes), `{"kind":"write","offset":0,"len":8192,"compression":"zstd"}`)
+	require.Contains(t, string(jsonBytes), `"compression_types":["zstd","lzo"]`)
+
+	// Plain writes have no length in version 2 as well, and no compression
+	plain := diff.FlatMap(nil)["/plain"]
+	require.Equal(t, uint64(5), plain.TotalBytesWritten())
+	require.Empty(t, plain.CompressionTypes())
+
+	var out bytes.Buffer
+	require.NoError(t, (&pkg.Processor{}).Dump(bytes.NewReader(stream), &out))
+	require.Contains(t, out.String(), `ENCODED_WRITE path="file" file_offset=8192 unencoded_file_len=4096 unencoded_len=4096 unencoded_offset=0 compression=3 encryption=0 data=bytes:len=50`)
+	require.Contains(t, out.String(), `WRITE path="plain" file_offset=0 data=bytes:len=5`)
+}
@@ -1,10 +1,14 @@
 package pkg
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"github.com/pkg/errors"
+	"math"
 	"strings"
+	"time"
 )
 
 type DiffNodeType = string
@@ -19,6 +23,14 @@ const (
 	DiffNodeTypeNode    DiffNodeType = "NODE"
 )
 
+type DiffRootLabel = string
+
+const (
+	DiffRootLabelSlash     DiffRootLabel = "/"
+	DiffRootLabelDot       DiffRootLabel = "."
+	DiffRootLabelSubvolume DiffRootLabel = "subvolume"
+)
+
 type DiffNodeReason = string
 
 const (
@@ -27,18 +39,160 @@ const (
 	DiffNodeReasonLinkDest   DiffNodeReason = "LINK_DEST"
 )
 
+type DiffDeleteCause = string
+
+const (
+	DiffDeleteCauseUnlink DiffDeleteCause = "unlink"
+	DiffDeleteCauseRmdir  DiffDeleteCause = "rmdir"
+	DiffDeleteCauseRename DiffDeleteCause = "rename"
+	// An overlayfs whiteout, see Processor.Overlay
+	DiffDeleteCauseWhiteout DiffDeleteCause = "whiteout"
+)
+
+// deleteCauses maps the commands which can delete a node to the cause of the deletion
+var deleteCauses = map[uint16]DiffDeleteCause{
+	BTRFS_SEND_C_UNLINK: DiffDeleteCauseUnlink,
+	BTRFS_SEND_C_RMDIR:  DiffDeleteCauseRmdir,
+	BTRFS_SEND_C_RENAME: DiffDeleteCauseRename,
+}
+
+type DiffChangeKind = string
+
+const (
+	DiffChangeKindWrite       DiffChangeKind = "write"
+	DiffChangeKindTruncate    DiffChangeKind = "truncate"
+	DiffChangeKindUtime       DiffChangeKind = "utime"
+	DiffChangeKindChmod       DiffChangeKind = "chmod"
+	DiffChangeKindChown       DiffChangeKind = "chown"
+	DiffChangeKindSetXattr    DiffChangeKind = "set_xattr"
+	DiffChangeKindRemoveXattr DiffChangeKind = "remove_xattr"
+	DiffChangeKindClone       DiffChangeKind = "clone"
+	// Derived from chmod changes, see DiffModeBitChange
+	DiffChangeKindExec   DiffChangeKind = "exec"
+	DiffChangeKindSetuid DiffChangeKind = "setuid"
+	DiffChangeKindSetgid DiffChangeKind = "setgid"
+	DiffChangeKindSticky DiffChangeKind = "sticky"
+	// An overlayfs opaque directory, which replaces the one in the lower layers, see Processor.Overlay
+	DiffChangeKindOpaque DiffChangeKind = "opaque"
+)
+
+// Content changes alter the data of a node, all other kinds only alter its metadata
+var contentChangeKinds = map[DiffChangeKind]bool{
+	DiffChangeKindWrite:    true,
+	DiffChangeKindTruncate: true,
+	DiffChangeKindClone:    true,
+	DiffChangeKindOpaque:   true,
+}
+
+type DiffXattrOp = string
+
+const (
+	DiffXattrOpSet    DiffXattrOp = "set"
+	DiffXattrOpRemove DiffXattrOp = "remove"
+)
+
+type DiffXattrChange struct {
+	Op   DiffXattrOp `json:"op"`
+	Name string      `json:"name"`
+
+	// Only for set xattrs, the value encoded as told by Encoding
+	Value    string            `json:"value,omitempty"`
+	Encoding DiffXattrEncoding `json:"encoding,omitempty"`
+}
+
+type DiffExtentKind = string
+
+const (
+	DiffExtentKindWrite DiffExtentKind = "write"
+	DiffExtentKindClone DiffExtentKind = "clone"
+)
+
+// DiffCompression is the compression of the data of an encoded write
+type DiffCompression = string
+
+const (
+	DiffCompressionNone DiffCompression = "none"
+	DiffCompressionZlib DiffCompression = "zlib"
+	DiffCompressionZstd DiffCompression = "zstd"
+	DiffCompressionLZO  DiffCompression = "lzo"
+)
+
+// compressionName returns the compression of a BTRFS_SEND_A_COMPRESSION value, where all the sector
+// sizes of LZO are the same algorithm
+func compressionName(value uint32) DiffCompression {
+	switch value {
+	case BTRFS_ENCODED_IO_COMPRESSION_NONE:
+		return DiffCompressionNone
+	case BTRFS_ENCODED_IO_COMPRESSION_ZLIB:
+		return DiffCompressionZlib
+	case BTRFS_ENCODED_IO_COMPRESSION_ZSTD:
+		return DiffCompressionZstd
+	case BTRFS_ENCODED_IO_COMPRESSION_LZO_4K, BTRFS_ENCODED_IO_COMPRESSION_LZO_8K, BTRFS_ENCODED_IO_COMPRESSION_LZO_16K,
+		BTRFS_ENCODED_IO_COMPRESSION_LZO_32K, BTRFS_ENCODED_IO_COMPRESSION_LZO_64K:
+		return DiffCompressionLZO
+	}
+	return fmt.Sprintf("unknown:%d", value)
+}
+
+// Clones of whole blocks can share the source extents, btrfs blocks being 4KiB on most systems
+const cloneBlockSize = 4096
+
+// DiffExtent is a range of a file which has been filled either with fresh data or by a clone (reflink)
+type DiffExtent struct {
+	Kind   DiffExtentKind
+	Offset uint64
+	Len    uint64
+	// Only for clones, the source range
+	CloneSourcePath string
+	CloneOffset     uint64
+	// Only for encoded writes (sent with --compressed-data), the compression of the data
+	Compression DiffCompression
+}
+
+type DiffExtentJSON struct {
+	Kind            DiffExtentKind `json:"kind"`
+	Offset          uint64         `json:"offset"`
+	Len             uint64         `json:"len"`
+	CloneSourcePath string         `json:"clone_source_path,omitempty"`
+	CloneOffset     *uint64        `json:"clone_offset,omitempty"`
+	CloneLen        *uint64        `json:"clone_len,omitempty"`
+	CloneAligned    *bool          `json:"clone_aligned,omitempty"`
+	Compression     string         `json:"compression,omitempty"`
+}
+
+// CloneAligned returns whether the clone source offset is block-aligned
+func (e *DiffExtent) CloneAligned() bool {
+	return e.Kind == DiffExtentKindClone && e.CloneOffset%cloneBlockSize == 0
+}
+
+func (e *DiffExtent) MarshalJSON() ([]byte, error) {
+	out := &DiffExtentJSON{Kind: e.Kind, Offset: e.Offset, Len: e.Len, Compression: e.Compression}
+	if e.Kind == DiffExtentKindClone {
+		aligned := e.CloneAligned()
+		out.CloneSourcePath = e.CloneSourcePath
+		out.CloneOffset = &e.CloneOffset
+		out.CloneLen = &e.Len
+		out.CloneAligned = &aligned
+	}
+	return json.Marshal(out)
+}
+
 type DiffNodeRelation struct {
 	Node   *DiffNode
 	Reason DiffNodeReason
 }
 
+// DiffNodeRelationJSON only carries the scalar fields of the target node, as relations can point
+// back to each other (e.g. the source and destination of a rename)
 type DiffNodeRelationJSON struct {
-	Path   string         `json:"path"`
-	Reason DiffNodeReason `json:"reason"`
+	Path     string         `json:"path"`
+	Reason   DiffNodeReason `json:"reason"`
+	NodeType DiffNodeType   `json:"node_type"`
+	State    operation      `json:"state"`
 }
 
 func (r *DiffNodeRelation) MarshalJSON() ([]byte, error) {
-	return json.Marshal(&DiffNodeRelationJSON{r.Node.GetChainPath(), r.Reason})
+	return json.Marshal(&DiffNodeRelationJSON{r.Node.DisplayPath(), r.Reason, r.Node.NodeType, r.Node.State})
 }
 
 type DiffNode struct {
@@ -52,22 +206,371 @@ type DiffNode struct {
 	Parent            *DiffNode
 	Children          map[string]*DiffNode
 	DeletedInSnapshot bool
+	// Why the node has been deleted in the snapshot, if it has been
+	DeleteCause DiffDeleteCause
+	// Whether the node has been created (not just renamed) while processing the stream(s)
+	CreatedInSnapshot bool
+
+	// Whether the node gained or lost any executable bit with its latest mode change
+	GainedExecutable bool
+	LostExecutable   bool
+	// Whether the node gained the setuid/setgid/sticky bits with its latest mode change
+	GainedSetuid bool
+	GainedSetgid bool
+	GainedSticky bool
+
+	// Overlayfs markers, only detected if Processor.Overlay is enabled
+	Whiteout bool
+	Opaque   bool
+
+	// All the xattrs set or removed, in order
+	Xattrs []*DiffXattrChange
+
+	// Final size of the file, only known if the stream truncated it, see FinalSize
+	Size *uint64
+
+	// Latest timestamps, only captured if CaptureTimestamps is enabled
+	Times *DiffNodeTimes
+
+	// Extents filled by WRITE/UPDATE_EXTENT/CLONE commands, in the order they have been applied, where
+	// contiguous ones are merged (see addExtent)
+	Extents []*DiffExtent
+
+	// The index (in processing order) and the uuid of the last stream which changed the node
+	SourceStreamIndex int
+	SourceUUID        string
+
+	// The inode number from the create command of the node (kept through renames and links), 0 if unknown
+	Ino uint64
+	// Whether the content changes of the node are the ones of another link to it, see Diff.FollowHardlinks
+	ChangedViaHardlink bool
+
+	// The deleted node this one has replaced at the same path, if any
+	previous *DiffNode
+	// The parent this node was in before being replaced by a new node at the same path, if any, so
+	// that relations to it (e.g. the source of an in-place rename) keep its full path
+	replacedIn *DiffNode
+
+	// Ranges written by all WRITE/UPDATE_EXTENT commands
+	written byteRanges
+	// Whether the write changes have to be rendered again from the written ranges, see Diff.renderWrites
+	writesPending bool
+	// TRUNCATE commands, in order
+	truncates []extentTruncate
+	// Latest known mode, if any
+	mode *uint64
+	// The bits of the mode set while the mode before the stream was unknown, see applyMode
+	unknownModeBits uint64
+	// Latest known owner, if any
+	uid *uint64
+	// Whether the data written at the start of the file begins with #!, see DefaultAuditRules
+	shebang bool
+
+	// The processor building the tree, set when the node is added to it
+	processor *Processor
+	// Only set on the root node, the name of the last received subvolume
+	subvolume string
+	// Only set on the root node, the diff owning the tree
+	diff *Diff
+}
+
+type DiffNodeTimes struct {
+	ATime time.Time `json:"atime"`
+	MTime time.Time `json:"mtime"`
+	CTime time.Time `json:"ctime"`
+}
 
-	// Tmp storage to help logs
-	lastDataWrittenOffset uint64
-	lastDataWrittenLen    uint64
+// change renders the timestamps as a utime change, relative to ref if defined
+func (t *DiffNodeTimes) change(ref *time.Time) string {
+	format := func(tm time.Time) string {
+		if ref != nil {
+			return humanizeTimeSince(tm, *ref)
+		}
+		return tm.String()
+	}
+	return fmt.Sprintf("utime:atime=%s,mtime=%s,ctime=%s", format(t.ATime), format(t.MTime), format(t.CTime))
+}
+
+type DiffNodeStats struct {
+	TotalBytesWritten uint64 `json:"total_bytes_written"`
+	WrittenBytes      uint64 `json:"written_bytes"`
+	ClonedBytes       uint64 `json:"cloned_bytes"`
 }
 
 type DiffNodeJSON struct {
-	NodeType  DiffNodeType        `json:"node_type"`
-	Path      string              `json:"path"`
-	State     operation           `json:"state"`
-	Relations []*DiffNodeRelation `json:"relations"`
-	Changes   []string            `json:"changes"`
+	NodeType    DiffNodeType        `json:"node_type"`
+	Path        string              `json:"path"`
+	State       operation           `json:"state"`
+	Relations   []*DiffNodeRelation `json:"relations"`
+	Changes     []string            `json:"changes"`
+	Times       *DiffNodeTimes      `json:"times,omitempty"`
+	DeleteCause DiffDeleteCause     `json:"delete_cause,omitempty"`
+	Stats       *DiffNodeStats      `json:"stats,omitempty"`
+	// Prior paths of the node, oldest first
+	RenameHistory    []string           `json:"rename_history,omitempty"`
+	GainedExecutable bool               `json:"gained_executable,omitempty"`
+	LostExecutable   bool               `json:"lost_executable,omitempty"`
+	GainedSetuid     bool               `json:"gained_setuid,omitempty"`
+	GainedSetgid     bool               `json:"gained_setgid,omitempty"`
+	GainedSticky     bool               `json:"gained_sticky,omitempty"`
+	ChangedFraction  *float64           `json:"changed_fraction,omitempty"`
+	Xattrs           []*DiffXattrChange `json:"xattrs,omitempty"`
+	Depth            int                `json:"depth"`
+	Extents          []*DiffExtent      `json:"extents,omitempty"`
+	TypeChanged      *DiffTypeChange    `json:"type_changed,omitempty"`
+	Whiteout         bool               `json:"whiteout,omitempty"`
+	Opaque           bool               `json:"opaque,omitempty"`
+	FinalSize        *uint64            `json:"final_size,omitempty"`
+	RenamedAncestor  bool               `json:"renamed_ancestor,omitempty"`
+	// Only defined when processing multiple streams, see DiffNode.SourceStreamIndex
+	SourceStreamIndex *int   `json:"source_stream_index,omitempty"`
+	SourceUUID        string `json:"source_uuid,omitempty"`
+	Empty             bool   `json:"empty,omitempty"`
+	// The compressions of the encoded writes of the node, see DiffNode.CompressionTypes
+	CompressionTypes []string `json:"compression_types,omitempty"`
+	ID               string   `json:"id"`
+	// Only defined when following hardlinks, see Diff.FollowHardlinks
+	ChangedViaHardlink bool `json:"changed_via_hardlink,omitempty"`
 }
 
 func (n *DiffNode) MarshalJSON() ([]byte, error) {
-	return json.Marshal(&DiffNodeJSON{n.NodeType, n.GetChainPath(), n.State, n.Relations, n.Changes})
+	var stats *DiffNodeStats
+	if len(n.Extents) > 0 {
+		written, cloned := n.extentRanges()
+		stats = &DiffNodeStats{n.TotalBytesWritten(), written.total(), cloned.total()}
+	}
+	sourceIndex, sourceUUID := n.sourceStream()
+	return json.Marshal(&DiffNodeJSON{
+		NodeType:           n.NodeType,
+		Path:               n.DisplayPath(),
+		State:              n.State,
+		Relations:          n.Relations,
+		Changes:            n.Changes,
+		Times:              n.Times,
+		DeleteCause:        n.DeleteCause,
+		Stats:              stats,
+		RenameHistory:      n.RenameHistory(),
+		GainedExecutable:   n.GainedExecutable,
+		LostExecutable:     n.LostExecutable,
+		GainedSetuid:       n.GainedSetuid,
+		GainedSetgid:       n.GainedSetgid,
+		GainedSticky:       n.GainedSticky,
+		ChangedFraction:    n.ChangedFraction(),
+		Xattrs:             n.Xattrs,
+		Depth:              n.Depth(),
+		Extents:            n.Extents,
+		TypeChanged:        n.TypeChange(),
+		Whiteout:           n.Whiteout,
+		Opaque:             n.Opaque,
+		FinalSize:          n.FinalSize(),
+		RenamedAncestor:    n.HasRenamedAncestor(),
+		SourceStreamIndex:  sourceIndex,
+		SourceUUID:         sourceUUID,
+		Empty:              n.IsEmpty(),
+		CompressionTypes:   n.CompressionTypes(),
+		ID:                 n.ID(),
+		ChangedViaHardlink: n.ChangedViaHardlink,
+	})
+}
+
+// sourceStream returns the source stream of the node, only if its diff has more than one stream, as
+// otherwise it is always the only one
+func (n *DiffNode) sourceStream() (*int, string) {
+	d := n.root().diff
+	if d == nil || d.StreamCount <= 1 {
+		return nil, ""
+	}
+	index := n.SourceStreamIndex
+	return &index, n.SourceUUID
+}
+
+// ChangeCount returns how many changes have been recorded on the node (contiguous writes count as one)
+func (n *DiffNode) ChangeCount() int {
+	return len(n.Changes)
+}
+
+// TotalBytesWritten returns the amount of distinct bytes written to the node, so overwriting
+// the same range multiple times counts only once
+func (n *DiffNode) TotalBytesWritten() uint64 {
+	return n.written.total()
+}
+
+// HasXattrChange returns true if any xattr whose name starts with prefix has been set or removed
+func (n *DiffNode) HasXattrChange(prefix string) bool {
+	for _, x := range n.Xattrs {
+		if strings.HasPrefix(x.Name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsChurn returns true if the node has been both created and deleted while processing the streams,
+// e.g. a temporary file created in an incremental stream and deleted in a later one
+func (n *DiffNode) IsChurn() bool {
+	return n.CreatedInSnapshot && n.State == opDelete && n.DeleteCause != DiffDeleteCauseRename
+}
+
+// FinalSize returns the best-effort size of the file after the stream: the size of the latest
+// truncate (or zero for created files), extended by any extent written after it. It is nil if the
+// stream does not reveal the size, e.g. for files only partially rewritten.
+func (n *DiffNode) FinalSize() *uint64 {
+	if n.NodeType != DiffNodeTypeFile || n.State == opDelete {
+		return nil
+	}
+	var size uint64
+	if n.Size != nil {
+		size = *n.Size
+	} else if !n.CreatedInSnapshot {
+		return nil
+	}
+	extents := n.Extents
+	if len(n.truncates) > 0 {
+		extents = extents[n.truncates[len(n.truncates)-1].extents:]
+	}
+	for _, e := range extents {
+		if end := e.Offset + e.Len; end > size {
+			size = end
+		}
+	}
+	return &size
+}
+
+// CompressionTypes returns the unique compressions of the encoded writes of the node, in order of
+// appearance. Plain writes carry no compression, as the data has been decompressed to be sent.
+func (n *DiffNode) CompressionTypes() []string {
+	var types []string
+	seen := make(map[DiffCompression]bool)
+	for _, e := range n.Extents {
+		if e.Compression != "" && !seen[e.Compression] {
+			seen[e.Compression] = true
+			types = append(types, e.Compression)
+		}
+	}
+	return types
+}
+
+// IsEmpty returns true if the file is known to be empty after the stream, e.g. created with `touch`
+// and never written, or truncated to zero. Files with an unknown size are not empty.
+func (n *DiffNode) IsEmpty() bool {
+	size := n.FinalSize()
+	return size != nil && *size == 0
+}
+
+// ID returns a SHA-256 of the path of the node, and of its inode number when known (see Ino), to
+// correlate the same node across different diffs. The ID is stable as long as the path and the inode
+// number are: a rename changes it, and so does having the inode number in one diff and not in the
+// other, e.g. a file created in one diff (which carries its inode number) and only changed in the next.
+func (n *DiffNode) ID() string {
+	key := n.GetChainPath()
+	if n.Ino != 0 {
+		key += fmt.Sprintf("\x00%d", n.Ino)
+	}
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// ChangedFraction returns the fraction (0-1) of the final size of the file which has been written
+// or cloned, or nil if the final size is unknown (or zero)
+func (n *DiffNode) ChangedFraction() *float64 {
+	size := n.FinalSize()
+	if size == nil || *size == 0 {
+		return nil
+	}
+	changed := append(byteRanges(nil), n.written...)
+	for _, e := range n.Extents {
+		if e.Kind == DiffExtentKindClone {
+			changed = changed.add(e.Offset, e.Len)
+		}
+	}
+	// Bytes changed past the final size have been truncated away
+	fraction := float64(changed.remove(*size, math.MaxUint64-*size).total()) / float64(*size)
+	return &fraction
+}
+
+// ChangeKinds returns the unique kinds of the changes recorded on the node, in order of appearance
+func (n *DiffNode) ChangeKinds() []DiffChangeKind {
+	var kinds []DiffChangeKind
+	seen := make(map[DiffChangeKind]bool)
+	for _, change := range n.Changes {
+		kind, _, _ := strings.Cut(change, ":")
+		if !seen[kind] {
+			seen[kind] = true
+			kinds = append(kinds, kind)
+		}
+	}
+	return kinds
+}
+
+// HasContentChanges returns true if the data of the node has changed, and not only its metadata
+func (n *DiffNode) HasContentChanges() bool {
+	for _, kind := range n.ChangeKinds() {
+		if contentChangeKinds[kind] {
+			return true
+		}
+	}
+	return false
+}
+
+// extentTruncate is a TRUNCATE command, applied after the first extents of a node
+type extentTruncate struct {
+	extents int
+	size    uint64
+}
+
+// addExtent records an extent, extending the last one instead if the new one continues it (e.g. the
+// sequential writes of a big file), as the result is the same
+func (n *DiffNode) addExtent(e *DiffExtent) {
+	if len(n.Extents) > 0 && (len(n.truncates) == 0 || n.truncates[len(n.truncates)-1].extents < len(n.Extents)) {
+		last := n.Extents[len(n.Extents)-1]
+		if last.Kind == e.Kind && last.Compression == e.Compression && last.Offset+last.Len == e.Offset &&
+			(e.Kind != DiffExtentKindClone || last.CloneSourcePath == e.CloneSourcePath && last.CloneOffset+last.Len == e.CloneOffset) {
+			last.Len += e.Len
+			return
+		}
+	}
+	n.Extents = append(n.Extents, e)
+}
+
+// extentRanges returns the final ranges filled with fresh data and by clones: as the kernel applies
+// extents in order, a later extent overrides any earlier one on the same range, and a truncate drops
+// anything past its size
+func (n *DiffNode) extentRanges() (written byteRanges, cloned byteRanges) {
+	truncates := n.truncates
+	applyTruncates := func(extents int) {
+		for ; len(truncates) > 0 && truncates[0].extents <= extents; truncates = truncates[1:] {
+			size := truncates[0].size
+			written = written.remove(size, math.MaxUint64-size)
+			cloned = cloned.remove(size, math.MaxUint64-size)
+		}
+	}
+	for i, e := range n.Extents {
+		applyTruncates(i)
+		written = written.remove(e.Offset, e.Len)
+		cloned = cloned.remove(e.Offset, e.Len)
+		switch e.Kind {
+		case DiffExtentKindWrite:
+			written = written.add(e.Offset, e.Len)
+		case DiffExtentKindClone:
+			cloned = cloned.add(e.Offset, e.Len)
+		}
+	}
+	applyTruncates(len(n.Extents))
+	return written, cloned
+}
+
+// WrittenBytes returns the amount of bytes of the node which contain fresh data, excluding the ones
+// later overridden by clones
+func (n *DiffNode) WrittenBytes() uint64 {
+	written, _ := n.extentRanges()
+	return written.total()
+}
+
+// ClonedBytes returns the amount of bytes of the node which come from clones (reflinks), excluding
+// the ones later overridden by writes
+func (n *DiffNode) ClonedBytes() uint64 {
+	_, cloned := n.extentRanges()
+	return cloned.total()
 }
 
 func (n *DiffNode) isBTRFSTemporaryNode() bool {
@@ -81,10 +584,69 @@ func (n *DiffNode) GetChainPath() string {
 	if n.Parent != nil {
 		return fmt.Sprintf("%s/%s", n.Parent.GetChainPath(), n.Path)
 	}
+	if n.replacedIn != nil {
+		return fmt.Sprintf("%s/%s", n.replacedIn.GetChainPath(), n.Path)
+	}
 
 	return fmt.Sprintf("%s", n.Path)
 }
 
+type DiffTypeChange struct {
+	From DiffNodeType `json:"from"`
+	To   DiffNodeType `json:"to"`
+}
+
+// TypeChange returns the old and new types of a node which has been deleted and re-created with a
+// different type, e.g. a directory replaced by a file. Deleted nodes have often an unknown type,
+// but an unlinked node can never have been a directory.
+func (n *DiffNode) TypeChange() *DiffTypeChange {
+	if n.previous == nil || n.NodeType == DiffNodeTypeUnknown {
+		return nil
+	}
+	from := n.previous.NodeType
+	if from == n.NodeType {
+		return nil
+	}
+	if from == DiffNodeTypeUnknown && (n.NodeType != DiffNodeTypeDir || n.previous.DeleteCause != DiffDeleteCauseUnlink) {
+		return nil
+	}
+	return &DiffTypeChange{from, n.NodeType}
+}
+
+// Depth returns how many parents the node has, the root being at depth 0
+func (n *DiffNode) Depth() int {
+	depth := 0
+	for p := n.Parent; p != nil; p = p.Parent {
+		depth++
+	}
+	return depth
+}
+
+// DisplayPath returns the path of the node as shown in the outputs, where the root node is labeled
+// according to Diff.RootLabel, unless prefixed with its subvolume (see Processor.PrefixSubvolume)
+func (n *DiffNode) DisplayPath() string {
+	p := n.GetChainPath()
+	if root := n.root(); root.subvolume != "" && root.processor != nil && root.processor.PrefixSubvolume {
+		return "/" + root.subvolume + p
+	}
+	if p != "" {
+		return p
+	}
+	var label DiffRootLabel
+	if n.diff != nil {
+		label = n.diff.RootLabel
+	}
+	switch label {
+	case DiffRootLabelDot:
+		return "."
+	case DiffRootLabelSubvolume:
+		if n.subvolume != "" {
+			return n.subvolume
+		}
+	}
+	return "/"
+}
+
 func (n *DiffNode) root() *DiffNode {
 	if n.Parent != nil {
 		return n.Parent.root()
@@ -93,39 +655,96 @@ func (n *DiffNode) root() *DiffNode {
 	return n
 }
 
-func (n *DiffNode) StringForDeleted() string {
-	p := n.GetChainPath()
-	if p == "" {
-		p = "/"
+// isAttached returns true if the node is still part of the tree of a diff, and has not been removed
+// from it (with all of its ancestors)
+func (n *DiffNode) isAttached() bool {
+	return n.root().diff != nil
+}
+
+// proc returns the processor which is building the tree the node belongs to, or has belonged to
+func (n *DiffNode) proc() *Processor {
+	if n.processor == nil {
+		// Only fake nodes never added to a tree, e.g. link destinations outside of the stream, which
+		// have no processing to log about
+		n.processor = &Processor{}
 	}
+	return n.processor
+}
+
+func (n *DiffNode) StringForDeleted() string {
+	p := n.DisplayPath()
 
 	var parts []string
 
 	parts = append(parts, fmt.Sprintf("[%s][%s]", n.NodeType, opDelete))
 	parts = append(parts, p)
 
+	if n.DeleteCause != "" {
+		parts = append(parts, fmt.Sprintf("[cause=%s]", n.DeleteCause))
+	}
+
 	return strings.Join(parts, " ")
 }
 
 func (n *DiffNode) String() string {
-	p := n.GetChainPath()
-	if p == "" {
-		p = "/"
-	}
+	return n.string(nil, false, false)
+}
+
+// StringRelative is like String, but renders the captured timestamps relative to ref (e.g. "2h ago")
+func (n *DiffNode) StringRelative(ref time.Time) string {
+	return n.string(&ref, false, false)
+}
+
+// StringWithInode is like String, with the inode number of the node appended when known
+func (n *DiffNode) StringWithInode() string {
+	return n.string(nil, true, false)
+}
+
+// StringWithACLs is like String, with the POSIX ACLs set on the node decoded (e.g.
+// "[acl=user::rw-,group::r--,other::r--]")
+func (n *DiffNode) StringWithACLs() string {
+	return n.string(nil, false, true)
+}
+
+func (n *DiffNode) string(timeRef *time.Time, showInode bool, decodeACLs bool) string {
+	p := n.DisplayPath()
 
 	var parts []string
 
 	parts = append(parts, fmt.Sprintf("[%s][%s]", n.NodeType, n.State.String()))
 	parts = append(parts, p)
 
+	if n.State == opDelete && n.DeleteCause != "" {
+		parts = append(parts, fmt.Sprintf("[cause=%s]", n.DeleteCause))
+	}
+
+	if c := n.TypeChange(); c != nil {
+		parts = append(parts, fmt.Sprintf("[type_changed=%s->%s]", c.From, c.To))
+	}
+
 	for _, r := range n.Relations {
-		parts = append(parts, fmt.Sprintf("[rel=%s:%s]", r.Node.GetChainPath(), r.Reason))
+		parts = append(parts, fmt.Sprintf("[rel=%s:%s]", r.Node.DisplayPath(), r.Reason))
 	}
 
 	for _, r := range n.Changes {
+		if timeRef != nil && n.Times != nil && strings.HasPrefix(r, "utime:") {
+			r = n.Times.change(timeRef)
+		}
 		parts = append(parts, fmt.Sprintf("[change=%s]", r))
 	}
 
+	if n.ChangedViaHardlink {
+		parts = append(parts, "[via=hardlink]")
+	}
+
+	if decodeACLs {
+		parts = append(parts, n.aclStrings()...)
+	}
+
+	if showInode && n.Ino != 0 {
+		parts = append(parts, fmt.Sprintf("[ino=%d]", n.Ino))
+	}
+
 	return strings.Join(parts, " ")
 }
 
@@ -152,6 +771,34 @@ func (n *DiffNode) followRenameChainSrc() *DiffNode {
 	return n
 }
 
+// RenameHistory returns all the prior paths of the node, oldest first. Renamed nodes inherit
+// the relations of their source, so the whole rename chain is available on the node itself.
+// BTRFS temporary paths are skipped.
+func (n *DiffNode) RenameHistory() []string {
+	var history []string
+	for _, rel := range n.Relations {
+		if rel.Reason != DiffNodeReasonRenameSrc || rel.Node.isBTRFSTemporaryNode() {
+			continue
+		}
+		history = append(history, rel.Node.DisplayPath())
+	}
+	return history
+}
+
+// HasRenamedAncestor returns true if any directory above the node has been renamed, so that its path
+// changed without the node itself being renamed. Renames from and to BTRFS temporary paths (e.g. of
+// new directories) are skipped.
+func (n *DiffNode) HasRenamedAncestor() bool {
+	for p := n.Parent; p != nil && p.Parent != nil; p = p.Parent {
+		for _, rel := range p.Relations {
+			if (rel.Reason == DiffNodeReasonRenameSrc || rel.Reason == DiffNodeReasonRenameDest) && !rel.Node.isBTRFSTemporaryNode() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 func (n *DiffNode) mkdirp(path string, oldNodesAreCreatedInSnapshot bool, newNodesAreCreatedInSnapshot bool) *DiffNode {
 	entries := strings.Split(path, "/")
 	if entries[0] == "" {
@@ -181,10 +828,12 @@ func (n *DiffNode) mkdirp(path string, oldNodesAreCreatedInSnapshot bool, newNod
 				Parent:   currentNode,
 				Children: make(map[string]*DiffNode),
 				State:    state,
+
+				processor: currentNode.processor,
 			}
 			currentNode.Children[entry] = newNode
 			currentNode = newNode
-			debug("created intermediate dir node %s", currentNode)
+			n.proc().debug("created intermediate dir node %s", currentNode)
 		}
 	}
 	return currentNode
@@ -200,7 +849,13 @@ func (n *DiffNode) addNode(node *DiffNode) error {
 		// If we have an already existing node, the only reason for this to be is that
 		// the existing node is a deleted one, in which case we can override it completely.
 		if existingNode.State != opDelete {
-			return errors.Errorf("found existing children node %s while adding new node", node.Path)
+			d := n.root().diff
+			if d == nil || !n.proc().MergeConflictingNodes {
+				return errors.Errorf("found existing children node %s while adding new node", node.Path)
+			}
+			// Warnings carry the paths as found in the stream
+			path := strings.TrimPrefix(existingNode.GetChainPath(), "/")
+			d.warn(DiffWarningCodeNodeConflict, path, "found existing node %s while adding new node, merging them", path)
 		}
 	}
 	if node.Parent != nil {
@@ -210,11 +865,13 @@ func (n *DiffNode) addNode(node *DiffNode) error {
 	}
 
 	node.Parent = n
+	node.processor = n.processor
 
 	if existingNode != nil {
 		if err := existingNode.removeFromParent(); err != nil {
 			return errors.Wrapf(err, "failed to delete fake existing node %s from its parent %s", existingNode.GetChainPath(), existingNode.Parent.GetChainPath())
 		}
+		existingNode.replacedIn = n
 
 		// Merge the fake node into the new one
 		// NOTE: this will break links references :( TODO maybe not?
@@ -224,18 +881,70 @@ func (n *DiffNode) addNode(node *DiffNode) error {
 				return errors.Wrapf(err, "failed to move deleted fake node %s children %s to really deleted node %s", existingNode.GetChainPath(), val.GetChainPath(), node.GetChainPath())
 			}
 		}
-		node.DeletedInSnapshot = true
+		if existingNode.State == opDelete {
+			node.DeletedInSnapshot = true
+			node.DeleteCause = existingNode.DeleteCause
+			node.previous = existingNode
+		} else {
+			node.mergeConflicting(existingNode, n.root().diff)
+		}
 
 		n.Children[node.Path] = node
-		debug("replaced existing deleted node %s with new node %s in parent %s", existingNode, node, n)
+		n.proc().debug("replaced existing deleted node %s with new node %s in parent %s", existingNode, node, n)
 		return nil
 	}
 
 	n.Children[node.Path] = node
-	debug("added node %s to parent %s", node, n)
+	n.proc().debug("added node %s to parent %s", node, n)
 	return nil
 }
 
+// mergeConflicting merges into the node all the changes of an existing live node at the same path
+// (see Processor.MergeConflictingNodes), as if they had been applied to the node before its own
+func (n *DiffNode) mergeConflicting(existing *DiffNode, d *Diff) {
+	n.Changes = append(existing.Changes, n.Changes...)
+	n.Xattrs = append(existing.Xattrs, n.Xattrs...)
+
+	truncates := append([]extentTruncate{}, existing.truncates...)
+	for _, t := range n.truncates {
+		truncates = append(truncates, extentTruncate{t.extents + len(existing.Extents), t.size})
+	}
+	n.truncates = truncates
+	n.Extents = append(existing.Extents, n.Extents...)
+	if len(existing.written) > 0 {
+		written := append(byteRanges(nil), existing.written...)
+		for _, r := range n.written {
+			written = written.add(r.Start, r.Len())
+		}
+		n.written = written
+		d.markWritten(n)
+	}
+	if len(n.Extents) == len(existing.Extents) {
+		n.shebang = existing.shebang
+	}
+
+	if n.Size == nil {
+		n.Size = existing.Size
+	}
+	if n.mode == nil {
+		n.mode = existing.mode
+		n.GainedExecutable, n.LostExecutable = existing.GainedExecutable, existing.LostExecutable
+		n.GainedSetuid, n.GainedSetgid, n.GainedSticky = existing.GainedSetuid, existing.GainedSetgid, existing.GainedSticky
+		n.unknownModeBits = existing.unknownModeBits
+	}
+	if n.uid == nil {
+		n.uid = existing.uid
+	}
+	if n.Times == nil {
+		n.Times = existing.Times
+	}
+	if n.Ino == 0 {
+		n.Ino = existing.Ino
+	}
+	n.CreatedInSnapshot = n.CreatedInSnapshot || existing.CreatedInSnapshot
+	n.Opaque = n.Opaque || existing.Opaque
+}
+
 func (n *DiffNode) deleteNode(node *DiffNode) error {
 	var key string
 	for k, v := range n.Children {
@@ -258,7 +967,7 @@ func (n *DiffNode) removeFromParent() error {
 		if err := parent.deleteNode(n); err != nil {
 			return errors.Errorf("failed to remove node %s from parent %s", n.GetChainPath(), n.Parent.GetChainPath())
 		}
-		debug("deleted node %s from parent %s", n, parent)
+		n.proc().debug("deleted node %s from parent %s", n, parent)
 	}
 	return nil
 }
@@ -0,0 +1,305 @@
+package pkg_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/cmaster11/btrfs-diff/internal/streamtest"
+	"github.com/cmaster11/btrfs-diff/pkg"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestHasRenamedAncestor(t *testing.T) {
+	diff, err := pkg.ProcessBTRFSStream(bytes.NewReader(buildStream(t, newTestStream().
+		Rename("old", "moved").
+		Chmod("moved/sub/file", 0644).
+		Rename("file", "renamed").
+		Chmod("same/file", 0644).
+		// New directories are created with a temporary name
+		MkDir("o258-12-0", 1).
+		Rename("o258-12-0", "new").
+		MkFile("new/file", 2).
+		End())))
+	require.NoError(t, err)
+
+	m := diff.FlatMap(nil)
+	require.True(t, m["/moved/sub/file"].HasRenamedAncestor())
+	require.True(t, m["/moved/sub/file"].Parent.HasRenamedAncestor())
+	require.False(t, m["/moved"].HasRenamedAncestor())
+	require.False(t, m["/renamed"].HasRenamedAncestor())
+	require.False(t, m["/same/file"].HasRenamedAncestor())
+	require.False(t, m["/new/file"].HasRenamedAncestor())
+
+	jsonBytes, err := json.Marshal(m["/moved/sub/file"])
+	require.NoError(t, err)
+	require.Contains(t, string(jsonBytes), `"renamed_ancestor":true`)
+	jsonBytes, err = json.Marshal(m["/same/file"])
+	require.NoError(t, err)
+	require.NotContains(t, string(jsonBytes), "renamed_ancestor")
+}
+
+func TestRenameHistory(t *testing.T) {
+	stream, err := newTestStream().
+		Rename("a", "o257-10-0").
+		Rename("o257-10-0", "b").
+		Rename("b", "c").
+		End().
+		Bytes()
+	require.NoError(t, err)
+
+	diff, err := pkg.ProcessBTRFSStream(bytes.NewReader(stream))
+	require.NoError(t, err)
+
+	diffStr := diff.GetDiffStruct(nil)
+	require.Len(t, diffStr.Added, 1)
+	node := diffStr.Added[0]
+	require.Equal(t, "/c", node.GetChainPath())
+	require.Equal(t, []string{"/a", "/b"}, node.RenameHistory())
+
+	jsonBytes, err := json.Marshal(node)
+	require.NoError(t, err)
+	require.Contains(t, string(jsonBytes), `"rename_history":["/a","/b"]`)
+}
+
+func TestTypeChange(t *testing.T) {
+	diff, err := pkg.ProcessBTRFSStream(bytes.NewReader(buildStream(t, newTestStream().
+		Rmdir("dir-to-file").
+		MkFile("dir-to-file", 1).
+		// The old file is moved out of the way, and deleted after the new dir takes its place
+		Rename("file-to-dir", "o257-7-0").
+		MkDir("o300-7-0", 300).
+		Rename("o300-7-0", "file-to-dir").
+		Unlink("o257-7-0").
+		Rmdir("same").
+		MkDir("same", 2).
+		End())))
+	require.NoError(t, err)
+
+	m := diff.FlatMap(nil)
+	require.Equal(t, &pkg.DiffTypeChange{From: pkg.DiffNodeTypeDir, To: pkg.DiffNodeTypeFile}, m["/dir-to-file"].TypeChange())
+	require.Equal(t, &pkg.DiffTypeChange{From: pkg.DiffNodeTypeUnknown, To: pkg.DiffNodeTypeDir}, m["/file-to-dir"].TypeChange())
+	require.Nil(t, m["/same"].TypeChange())
+	require.True(t, m["/same"].DeletedInSnapshot)
+
+	jsonBytes, err := json.Marshal(m["/dir-to-file"])
+	require.NoError(t, err)
+	require.Contains(t, string(jsonBytes), `"type_changed":{"from":"DIR","to":"FILE"}`)
+}
+
+func TestDepth(t *testing.T) {
+	diff, err := pkg.ProcessBTRFSStream(bytes.NewReader(buildStream(t, newTestStream().
+		MkDir("a", 1).
+		MkFile("a/b", 2).
+		End())))
+	require.NoError(t, err)
+
+	nodes := diff.FlatMap(nil)
+	require.Equal(t, 0, nodes["/a"].Parent.Depth())
+	require.Equal(t, 1, nodes["/a"].Depth())
+
+	jsonBytes, err := json.Marshal(nodes["/a/b"])
+	require.NoError(t, err)
+	var parsed struct {
+		Depth int `json:"depth"`
+	}
+	require.NoError(t, json.Unmarshal(jsonBytes, &parsed))
+	require.Equal(t, 2, parsed.Depth)
+}
+
+func TestChangedFraction(t *testing.T) {
+	stream, err := newTestStream().
+		Write("edited", 10, make([]byte, 10)).
+		Truncate("edited", 100).
+		Write("rewritten", 0, make([]byte, 120)).
+		Truncate("rewritten", 100).
+		Write("unknown", 0, make([]byte, 10)).
+		End().
+		Bytes()
+	require.NoError(t, err)
+
+	diff, err := pkg.ProcessBTRFSStream(bytes.NewReader(stream))
+	require.NoError(t, err)
+
+	m := diff.FlatMap(nil)
+	require.InDelta(t, 0.1, *m["/edited"].ChangedFraction(), 0.0001)
+	require.InDelta(t, 1, *m["/rewritten"].ChangedFraction(), 0.0001)
+	require.Nil(t, m["/unknown"].ChangedFraction())
+
+	jsonBytes, err := json.Marshal(m["/unknown"])
+	require.NoError(t, err)
+	require.NotContains(t, string(jsonBytes), "changed_fraction")
+
+	args := &pkg.ProcessFileWithOutputArgs{MinChangePct: 50}
+	filtered := diff.FlatMap(args.IgnoreMatcher())
+	require.Len(t, filtered, 1)
+	require.Contains(t, filtered, "/rewritten")
+}
+
+func TestFinalSize(t *testing.T) {
+	diff, err := pkg.ProcessBTRFSStream(bytes.NewReader(buildStream(t, newTestStream().
+		MkFile("created", 1).
+		Write("created", 100, make([]byte, 50)).
+		MkFile("empty", 2).
+		Write("grown", 0, make([]byte, 10)).
+		Truncate("grown", 100).
+		Write("grown", 200, make([]byte, 10)).
+		Write("shrunk", 0, make([]byte, 120)).
+		Truncate("shrunk", 100).
+		Write("unknown", 0, make([]byte, 10)).
+		MkDir("dir", 3).
+		End())))
+	require.NoError(t, err)
+
+	m := diff.FlatMap(nil)
+	for p, size := range map[string]uint64{"/created": 150, "/empty": 0, "/grown": 210, "/shrunk": 100} {
+		require.NotNil(t, m[p].FinalSize(), p)
+		require.Equal(t, size, *m[p].FinalSize(), p)
+	}
+	require.Nil(t, m["/unknown"].FinalSize())
+	require.Nil(t, m["/dir"].FinalSize())
+
+	// Created files without a truncate now have a known changed fraction as well
+	require.InDelta(t, 50.0/150, *m["/created"].ChangedFraction(), 0.0001)
+
+	jsonBytes, err := json.Marshal(m["/grown"])
+	require.NoError(t, err)
+	require.Contains(t, string(jsonBytes), `"final_size":210`)
+	jsonBytes, err = json.Marshal(m["/unknown"])
+	require.NoError(t, err)
+	require.NotContains(t, string(jsonBytes), "final_size")
+}
+
+func TestNodeID(t *testing.T) {
+	process := func(builder *streamtest.Builder) map[string]*pkg.DiffNode {
+		diff, err := pkg.ProcessBTRFSStream(bytes.NewReader(buildStream(t, builder)))
+		require.NoError(t, err)
+		nodes := make(map[string]*pkg.DiffNode)
+		diffStr := diff.GetDiffStruct(nil)
+		for _, n := range append(diffStr.Added, diffStr.Changed...) {
+			nodes[n.GetChainPath()] = n
+		}
+		return nodes
+	}
+
+	first := process(newTestStream().
+		MkFile("new", 257).
+		Write("file", 0, []byte("a")).
+		End())
+	second := process(streamtest.NewBuilder().
+		Snapshot("003", "4379e89e4c343e468229796bca6cbb49", 14, "8ceaf94ac851d346841abc2b82323625", 12).
+		Write("file", 1, []byte("b")).
+		Rename("new", "renamed").
+		End())
+
+	require.Len(t, first["/file"].ID(), 64)
+	require.Equal(t, first["/file"].ID(), second["/file"].ID())
+	require.NotEqual(t, first["/file"].ID(), first["/new"].ID())
+	// Renames change the path, and so the ID
+	require.NotEqual(t, first["/new"].ID(), second["/renamed"].ID())
+
+	jsonBytes, err := json.Marshal(first["/file"])
+	require.NoError(t, err)
+	require.Contains(t, string(jsonBytes), fmt.Sprintf(`"id":"%s"`, first["/file"].ID()))
+}
+
+func TestEmptyFiles(t *testing.T) {
+	// touch dir/file
+	diff, err := pkg.ProcessFile(fmt.Sprintf("%s/inc-012.snap", testDir))
+	require.NoError(t, err)
+	file := diff.FlatMap(nil)["/dir/file"]
+	require.Equal(t, pkg.DiffNodeTypeFile, file.NodeType)
+	require.True(t, file.CreatedInSnapshot)
+	require.Zero(t, file.TotalBytesWritten())
+	require.True(t, file.IsEmpty())
+
+	jsonBytes, err := json.Marshal(file)
+	require.NoError(t, err)
+	require.Contains(t, string(jsonBytes), `"final_size":0,"empty":true`)
+	require.NotContains(t, string(jsonBytes), "stats")
+
+	diff, err = pkg.ProcessBTRFSStream(bytes.NewReader(buildStream(t, newTestStream().
+		MkFile("written", 1).
+		Write("written", 0, []byte("data")).
+		Write("emptied", 0, []byte("data")).
+		Truncate("emptied", 0).
+		Chmod("existing", 0644).
+		MkDir("dir", 2).
+		End())))
+	require.NoError(t, err)
+
+	m := diff.FlatMap(nil)
+	require.False(t, m["/written"].IsEmpty())
+	// Writes do not make a file non-empty if it is truncated afterwards
+	require.True(t, m["/emptied"].IsEmpty())
+	require.Equal(t, uint64(4), m["/emptied"].TotalBytesWritten())
+	// Unknown sizes are not empty
+	require.False(t, m["/existing"].IsEmpty())
+	require.False(t, m["/dir"].IsEmpty())
+}
+
+func TestSourceStream(t *testing.T) {
+	inc1, err := newTestStream().
+		MkFile("first", 1).
+		MkFile("both", 2).
+		Unlink("deleted").
+		End().
+		Bytes()
+	require.NoError(t, err)
+	inc2, err := streamtest.NewBuilder().
+		Snapshot("003", "4379e89e4c343e468229796bca6cbb49", 14, "8ceaf94ac851d346841abc2b82323625", 12).
+		Write("both", 0, []byte("data")).
+		MkFile("second", 3).
+		End().
+		Bytes()
+	require.NoError(t, err)
+
+	diff, err := pkg.ProcessStreams(bytes.NewReader(inc1), bytes.NewReader(inc2))
+	require.NoError(t, err)
+	require.Equal(t, 2, diff.StreamCount)
+
+	m := diff.FlatMap(nil)
+	for path, expected := range map[string]string{
+		"/first":   "8ceaf94ac851d346841abc2b82323625",
+		"/deleted": "8ceaf94ac851d346841abc2b82323625",
+		"/both":    "4379e89e4c343e468229796bca6cbb49",
+		"/second":  "4379e89e4c343e468229796bca6cbb49",
+	} {
+		require.Equal(t, expected, m[path].SourceUUID, path)
+	}
+	require.Equal(t, 0, m["/first"].SourceStreamIndex)
+	require.Equal(t, 1, m["/both"].SourceStreamIndex)
+
+	jsonBytes, err := json.Marshal(m["/first"])
+	require.NoError(t, err)
+	require.Contains(t, string(jsonBytes), `"source_stream_index":0,"source_uuid":"8ceaf94ac851d346841abc2b82323625"`)
+
+	// A single stream is the source of all the nodes, so it is not output
+	single, err := pkg.ProcessStreams(bytes.NewReader(inc1))
+	require.NoError(t, err)
+	jsonBytes, err = json.Marshal(single.FlatMap(nil)["/first"])
+	require.NoError(t, err)
+	require.NotContains(t, string(jsonBytes), "source_")
+}
+
+func TestRelationJSON(t *testing.T) {
+	diff, err := pkg.ProcessBTRFSStream(bytes.NewReader(buildStream(t, newTestStream().
+		MkDir("dir", 257).
+		Rename("dir", "moved").
+		End())))
+	require.NoError(t, err)
+
+	// The source and destination of the rename refer to each other, only their scalar fields are output
+	jsonBytes, err := json.Marshal(diff.GetDiffStruct(nil))
+	require.NoError(t, err)
+	var out struct {
+		Added []struct {
+			Relations []map[string]interface{} `json:"relations"`
+		} `json:"added"`
+	}
+	require.NoError(t, json.Unmarshal(jsonBytes, &out))
+	require.Len(t, out.Added, 1)
+	require.Equal(t, []map[string]interface{}{
+		{"path": "/dir", "reason": "RENAME_SRC", "node_type": "DIR", "state": float64(4)},
+	}, out.Added[0].Relations)
+}
@@ -0,0 +1,150 @@
+// Schema of the proto output format (--format proto), mirroring the json output (DiffJSONStruct).
+// The output is a single Diff message, prefixed by its length as a varint.
+// DiffService is served by the serve subcommand.
+// The Go bindings are generated in pkg/diffpb, see go:generate in pkg/proto.go.
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             v4.24.4
+// source: pkg/diff.proto
+
+package diffpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	DiffService_Diff_FullMethodName = "/btrfsdiff.DiffService/Diff"
+)
+
+// DiffServiceClient is the client API for DiffService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type DiffServiceClient interface {
+	// Diff processes a btrfs send stream uploaded in chunks, then streams back the nodes of its diff (in the
+	// order of the ndjson output). The response ends once the stream is processed, even if more data follows.
+	Diff(ctx context.Context, opts ...grpc.CallOption) (DiffService_DiffClient, error)
+}
+
+type diffServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewDiffServiceClient(cc grpc.ClientConnInterface) DiffServiceClient {
+	return &diffServiceClient{cc}
+}
+
+func (c *diffServiceClient) Diff(ctx context.Context, opts ...grpc.CallOption) (DiffService_DiffClient, error) {
+	stream, err := c.cc.NewStream(ctx, &DiffService_ServiceDesc.Streams[0], DiffService_Diff_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &diffServiceDiffClient{stream}
+	return x, nil
+}
+
+type DiffService_DiffClient interface {
+	Send(*UploadRequest) error
+	Recv() (*DiffNode, error)
+	grpc.ClientStream
+}
+
+type diffServiceDiffClient struct {
+	grpc.ClientStream
+}
+
+func (x *diffServiceDiffClient) Send(m *UploadRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *diffServiceDiffClient) Recv() (*DiffNode, error) {
+	m := new(DiffNode)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// DiffServiceServer is the server API for DiffService service.
+// All implementations must embed UnimplementedDiffServiceServer
+// for forward compatibility
+type DiffServiceServer interface {
+	// Diff processes a btrfs send stream uploaded in chunks, then streams back the nodes of its diff (in the
+	// order of the ndjson output). The response ends once the stream is processed, even if more data follows.
+	Diff(DiffService_DiffServer) error
+	mustEmbedUnimplementedDiffServiceServer()
+}
+
+// UnimplementedDiffServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedDiffServiceServer struct {
+}
+
+func (UnimplementedDiffServiceServer) Diff(DiffService_DiffServer) error {
+	return status.Errorf(codes.Unimplemented, "method Diff not implemented")
+}
+func (UnimplementedDiffServiceServer) mustEmbedUnimplementedDiffServiceServer() {}
+
+// UnsafeDiffServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to DiffServiceServer will
+// result in compilation errors.
+type UnsafeDiffServiceServer interface {
+	mustEmbedUnimplementedDiffServiceServer()
+}
+
+func RegisterDiffServiceServer(s grpc.ServiceRegistrar, srv DiffServiceServer) {
+	s.RegisterService(&DiffService_ServiceDesc, srv)
+}
+
+func _DiffService_Diff_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(DiffServiceServer).Diff(&diffServiceDiffServer{stream})
+}
+
+type DiffService_DiffServer interface {
+	Send(*DiffNode) error
+	Recv() (*UploadRequest, error)
+	grpc.ServerStream
+}
+
+type diffServiceDiffServer struct {
+	grpc.ServerStream
+}
+
+func (x *diffServiceDiffServer) Send(m *DiffNode) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *diffServiceDiffServer) Recv() (*UploadRequest, error) {
+	m := new(UploadRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// DiffService_ServiceDesc is the grpc.ServiceDesc for DiffService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var DiffService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "btrfsdiff.DiffService",
+	HandlerType: (*DiffServiceServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Diff",
+			Handler:       _DiffService_Diff_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "pkg/diff.proto",
+}
@@ -0,0 +1,71 @@
+package pkg
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"strings"
+)
+
+// diffStatBarWidth is the width of the bar of the most written file
+const diffStatBarWidth = 40
+
+// WriteDiffStat writes a summary like `git diff --stat`: one line per reportable file (anything but
+// directories) with its bytes written and a bar scaled to the most written file, then a footer with
+// the totals, with amounts of bytes rendered as requested by bytes. Files are sorted by path, unless
+// sortBy is defined.
+func (d *Diff) WriteDiffStat(w io.Writer, ignore DiffNodeMatcher, sortBy DiffSortBy, bytes BytesFormat) error {
+	var nodes []*DiffNode
+	for _, f := range d.FlatMap(ignore) {
+		if f.NodeType != DiffNodeTypeDir {
+			nodes = append(nodes, f)
+		}
+	}
+	if sortBy == DiffSortByNone {
+		sortBy = DiffSortByPath
+	}
+	if err := SortDiffNodes(nodes, sortBy); err != nil {
+		return err
+	}
+
+	var maxWritten, totalWritten uint64
+	pathWidth, bytesWidth := 0, 1
+	for _, f := range nodes {
+		written := f.TotalBytesWritten()
+		totalWritten += written
+		if written > maxWritten {
+			maxWritten = written
+		}
+		if l := len(f.DisplayPath()); l > pathWidth {
+			pathWidth = l
+		}
+		if l := len(formatBytes(written, bytes)); l > bytesWidth {
+			bytesWidth = l
+		}
+	}
+
+	var sb strings.Builder
+	for _, f := range nodes {
+		written := f.TotalBytesWritten()
+		line := fmt.Sprintf(" %-*s | %*s", pathWidth, f.DisplayPath(), bytesWidth, formatBytes(written, bytes))
+		if written > 0 {
+			// Round up, so that any written file gets at least one mark
+			bar := int((written*diffStatBarWidth + maxWritten - 1) / maxWritten)
+			line += " " + strings.Repeat("+", bar)
+		} else if f.State == opDelete {
+			line += " -"
+		}
+		sb.WriteString(line + "\n")
+	}
+
+	noun := "files"
+	if len(nodes) == 1 {
+		noun = "file"
+	}
+	sb.WriteString(fmt.Sprintf(" %d %s changed, %s written\n", len(nodes), noun, formatBytes(totalWritten, bytes)))
+
+	if _, err := io.WriteString(w, sb.String()); err != nil {
+		return errors.Wrap(err, "failed to write diff stat")
+	}
+	return nil
+}
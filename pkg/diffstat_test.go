@@ -0,0 +1,51 @@
+package pkg_test
+
+import (
+	"bytes"
+	"github.com/cmaster11/btrfs-diff/pkg"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+func TestDiffStat(t *testing.T) {
+	diff, err := pkg.ProcessBTRFSStream(bytes.NewReader(buildStream(t, newTestStream().
+		MkFile("dir/big", 1).
+		Write("dir/big", 0, make([]byte, 4000)).
+		Write("small", 0, make([]byte, 10)).
+		Chmod("chmoded", 0644).
+		Unlink("deleted").
+		MkDir("dir", 2).
+		End())))
+	require.NoError(t, err)
+
+	write := func(args *pkg.ProcessFileWithOutputArgs) string {
+		args.Format = pkg.OutputFormatDiffStat
+		var buf bytes.Buffer
+		require.NoError(t, pkg.WriteDiff(&buf, diff, args))
+		return buf.String()
+	}
+	require.Equal(t, ""+
+		" /chmoded |     0 B\n"+
+		" /deleted |     0 B -\n"+
+		" /dir/big | 3.9 KiB "+strings.Repeat("+", 40)+"\n"+
+		" /small   |    10 B +\n"+
+		" 4 files changed, 3.9 KiB written\n", write(&pkg.ProcessFileWithOutputArgs{}))
+	require.Equal(t, ""+
+		" /chmoded |    0\n"+
+		" /deleted |    0 -\n"+
+		" /dir/big | 4000 "+strings.Repeat("+", 40)+"\n"+
+		" /small   |   10 +\n"+
+		" 4 files changed, 4010 written\n", write(&pkg.ProcessFileWithOutputArgs{Bytes: pkg.BytesFormatRaw}))
+	require.True(t, strings.HasPrefix(write(&pkg.ProcessFileWithOutputArgs{SortBy: pkg.DiffSortByBytes}), " /dir/big "))
+
+	empty, err := pkg.ProcessBTRFSStream(bytes.NewReader(buildStream(t, newTestStream().
+		End())))
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	require.NoError(t, pkg.WriteDiff(&buf, empty, &pkg.ProcessFileWithOutputArgs{Format: pkg.OutputFormatDiffStat}))
+	require.Equal(t, " 0 files changed, 0 B written\n", buf.String())
+
+	require.ErrorContains(t, pkg.WriteDiff(&buf, diff, &pkg.ProcessFileWithOutputArgs{Format: pkg.OutputFormatJSON, Bytes: pkg.BytesFormatHuman}), "always has raw bytes")
+	require.ErrorContains(t, pkg.WriteDiff(&buf, diff, &pkg.ProcessFileWithOutputArgs{Format: pkg.OutputFormatDiffStat, Bytes: "kb"}), "unsupported bytes format")
+}
@@ -0,0 +1,73 @@
+package pkg
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"sort"
+	"strings"
+)
+
+var dotStateColors = map[operation]string{
+	opCreate: "green",
+	opModify: "orange",
+	opDelete: "red",
+}
+
+func dotQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	s = strings.ReplaceAll(s, "\n", `\n`)
+	return `"` + s + `"`
+}
+
+// WriteDOT writes the diff tree as a Graphviz digraph, where every node is a file/dir
+// colored by its state, and every edge is a relation between two nodes (e.g. a rename)
+func (d *Diff) WriteDOT(w io.Writer) error {
+	var nodes []*DiffNode
+	d.root.traverse(func(f *DiffNode) {
+		nodes = append(nodes, f)
+	})
+	sort.SliceStable(nodes, func(i, j int) bool {
+		return nodes[i].GetChainPath() < nodes[j].GetChainPath()
+	})
+
+	ids := make(map[*DiffNode]string)
+	var sb strings.Builder
+
+	// Relations can point to nodes which are not part of the tree anymore, so we
+	// register nodes lazily while walking the edges as well
+	var getID func(n *DiffNode) string
+	getID = func(n *DiffNode) string {
+		if id, ok := ids[n]; ok {
+			return id
+		}
+		id := fmt.Sprintf("n%d", len(ids))
+		ids[n] = id
+
+		p := n.DisplayPath()
+		color, ok := dotStateColors[n.State]
+		if !ok {
+			color = "gray"
+		}
+		sb.WriteString(fmt.Sprintf("  %s [label=%s, color=%s];\n", id, dotQuote(fmt.Sprintf("%s\n[%s][%s]", p, n.NodeType, n.State)), color))
+		return id
+	}
+
+	sb.WriteString("digraph diff {\n")
+	sb.WriteString("  node [shape=box];\n")
+	for _, n := range nodes {
+		getID(n)
+	}
+	for _, n := range nodes {
+		for _, rel := range n.Relations {
+			sb.WriteString(fmt.Sprintf("  %s -> %s [label=%s];\n", getID(n), getID(rel.Node), dotQuote(rel.Reason)))
+		}
+	}
+	sb.WriteString("}\n")
+
+	if _, err := io.WriteString(w, sb.String()); err != nil {
+		return errors.Wrap(err, "failed to write dot graph")
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package pkg_test
+
+import (
+	"bytes"
+	"github.com/cmaster11/btrfs-diff/pkg"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestWriteDOT(t *testing.T) {
+	diff, err := pkg.ProcessBTRFSStream(bytes.NewReader(buildStream(t, newTestStream().
+		MkFile("new", 257).
+		Rename("old", "renamed").
+		Chmod("changed", 0644).
+		Unlink("gone\"quoted").
+		End())))
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, diff.WriteDOT(&buf))
+	require.Equal(t, `digraph diff {
+  node [shape=box];
+  n0 [label="/changed\n[UNKNOWN][changed]", color=orange];
+  n1 [label="/gone\"quoted\n[UNKNOWN][deleted]", color=red];
+  n2 [label="/new\n[FILE][added]", color=green];
+  n3 [label="/old\n[UNKNOWN][deleted]", color=red];
+  n4 [label="/renamed\n[UNKNOWN][added]", color=green];
+  n3 -> n4 [label="RENAME_DEST"];
+  n4 -> n3 [label="RENAME_SRC"];
+}
+`, buf.String())
+}
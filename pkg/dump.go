@@ -0,0 +1,117 @@
+package pkg
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"strings"
+	"time"
+)
+
+func dumpValue(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return fmt.Sprintf("%q", v)
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+// Dump writes the raw command sequence of a stream, one command per line with all its attributes
+// in stream order (e.g. `WRITE path="file" file_offset=0 data=bytes:len=10`), without building
+// any diff tree
+func (p *Processor) Dump(r io.Reader, w io.Writer) error {
+	input := bufio.NewReader(r)
+
+	// Concatenated streams are dumped one after the other
+	for {
+		if err := p.dumpStream(input, w); err != nil {
+			return err
+		}
+		if _, err := input.Peek(1); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return errors.Wrap(err, "failed to read next stream")
+		}
+	}
+}
+
+func (p *Processor) dumpStream(input *bufio.Reader, w io.Writer) error {
+	ver, err := validateBTRFSStream(input)
+	if err != nil {
+		return errors.Wrap(err, "failed to validate btrfs stream")
+	}
+	if _, err := fmt.Fprintf(w, "STREAM version=%d\n", ver); err != nil {
+		return errors.Wrap(err, "failed to write dump")
+	}
+
+	for {
+		command, err := p.readCommand(input, ver)
+		if err != nil {
+			return errors.Wrap(err, "failed to read command")
+		}
+		if err := command.ReadAllParams(); err != nil {
+			return errors.Wrapf(err, "failed to read params of command %s", command.Type.Name)
+		}
+
+		name := strings.TrimPrefix(command.Type.Name, "BTRFS_SEND_C_")
+		if name == "" {
+			name = fmt.Sprintf("UNKNOWN_%d", command.OriginalType)
+		}
+		parts := []string{name}
+		for _, paramType := range command.paramOrder {
+			attrName := strings.ToLower(strings.TrimPrefix(attrDefs[paramType].Name, "BTRFS_SEND_A_"))
+			parts = append(parts, fmt.Sprintf("%s=%s", attrName, dumpValue(command.params[paramType])))
+		}
+		if _, err := fmt.Fprintln(w, strings.Join(parts, " ")); err != nil {
+			return errors.Wrap(err, "failed to write dump")
+		}
+
+		if command.OriginalType == BTRFS_SEND_C_END {
+			return nil
+		}
+		command.release()
+	}
+}
+
+// CountCommands reads and fully decodes every command of a stream (concatenated streams included),
+// building no diff tree, e.g. to measure the raw parsing throughput
+func (p *Processor) CountCommands(r io.Reader) (int, error) {
+	input := bufio.NewReader(r)
+	count := 0
+	for {
+		ver, err := validateBTRFSStream(input)
+		if err != nil {
+			return count, errors.Wrap(err, "failed to validate btrfs stream")
+		}
+		for {
+			command, err := p.readCommand(input, ver)
+			if err != nil {
+				return count, errors.Wrap(err, "failed to read command")
+			}
+			if err := command.ReadAllParams(); err != nil {
+				return count, errors.Wrapf(err, "failed to read params of command %s", command.Type.Name)
+			}
+			count++
+			end := command.OriginalType == BTRFS_SEND_C_END
+			command.release()
+			if end {
+				break
+			}
+		}
+
+		if _, err := input.Peek(1); err == io.EOF {
+			return count, nil
+		} else if err != nil {
+			return count, errors.Wrap(err, "failed to read next stream")
+		}
+	}
+}
+
+// CountCommands is like Processor.CountCommands, with the default settings
+func CountCommands(r io.Reader) (int, error) {
+	return NewProcessor().CountCommands(r)
+}
@@ -0,0 +1,47 @@
+package pkg_test
+
+import (
+	"bytes"
+	"github.com/cmaster11/btrfs-diff/pkg"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestDump(t *testing.T) {
+	stream, err := newTestStream().
+		Write("file", 0, []byte("hello")).
+		Clone("file", 5, 10, "b4233aaf045b6a4b89a2c08c8c1b4743", 10, "src", 0).
+		End().
+		Bytes()
+	require.NoError(t, err)
+
+	var out bytes.Buffer
+	require.NoError(t, (&pkg.Processor{}).Dump(bytes.NewReader(stream), &out))
+	require.Equal(t, `STREAM version=1
+SNAPSHOT path="002" uuid="8ceaf94ac851d346841abc2b82323625" ctransid=12 clone_uuid="b4233aaf045b6a4b89a2c08c8c1b4743" clone_ctransid=10
+WRITE path="file" file_offset=0 data=bytes:len=5
+CLONE file_offset=5 clone_len=10 path="file" clone_uuid="b4233aaf045b6a4b89a2c08c8c1b4743" clone_ctransid=10 clone_path="src" clone_offset=0
+END
+`, out.String())
+}
+
+func TestCountCommands(t *testing.T) {
+	stream, err := newTestStream().
+		Write("file", 0, []byte("hello")).
+		Unlink("old").
+		End().
+		Bytes()
+	require.NoError(t, err)
+
+	count, err := pkg.CountCommands(bytes.NewReader(stream))
+	require.NoError(t, err)
+	require.Equal(t, 4, count)
+
+	// Concatenated streams
+	count, err = pkg.CountCommands(bytes.NewReader(append(append([]byte{}, stream...), stream...)))
+	require.NoError(t, err)
+	require.Equal(t, 8, count)
+
+	_, err = pkg.CountCommands(bytes.NewReader(stream[:len(stream)-5]))
+	require.Error(t, err)
+}
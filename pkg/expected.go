@@ -0,0 +1,91 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"os"
+	"path"
+	"strings"
+)
+
+// DiffExpected matches the nodes covered by a manifest of expected changes, so that only the
+// unexpected ones are output (e.g. to detect drift from a known state)
+type DiffExpected struct {
+	paths map[string]bool
+	// Glob patterns, see path.Match
+	patterns []string
+}
+
+// Only the paths of the nodes of a JSON diff are needed
+type diffExpectedNodeJSON struct {
+	Path string `json:"path"`
+}
+
+type diffExpectedManifestJSON struct {
+	Added   []*diffExpectedNodeJSON `json:"added"`
+	Changed []*diffExpectedNodeJSON `json:"changed"`
+	Deleted []*diffExpectedNodeJSON `json:"deleted"`
+}
+
+// NewDiffExpected returns a matcher of the given paths, which can also be glob patterns (e.g.
+// "/var/log/*.log", see path.Match)
+func NewDiffExpected(paths []string) (*DiffExpected, error) {
+	e := &DiffExpected{paths: make(map[string]bool)}
+	for _, p := range paths {
+		if !strings.ContainsAny(p, "*?[") {
+			e.paths[p] = true
+			continue
+		}
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, errors.Wrapf(err, "invalid pattern %q", p)
+		}
+		e.patterns = append(e.patterns, p)
+	}
+	return e, nil
+}
+
+// ParseDiffExpected parses a manifest of expected changes, either a JSON list of paths and patterns,
+// or a JSON diff (see DiffJSONStruct), in which case all of its nodes are expected
+func ParseDiffExpected(data []byte) (*DiffExpected, error) {
+	var paths []string
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		if err := json.Unmarshal(data, &paths); err != nil {
+			return nil, errors.Wrap(err, "failed to parse list of expected paths")
+		}
+		return NewDiffExpected(paths)
+	}
+
+	var manifest diffExpectedManifestJSON
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, errors.Wrap(err, "failed to parse expected diff")
+	}
+	for _, nodes := range [][]*diffExpectedNodeJSON{manifest.Added, manifest.Changed, manifest.Deleted} {
+		for _, n := range nodes {
+			paths = append(paths, n.Path)
+		}
+	}
+	return NewDiffExpected(paths)
+}
+
+// LoadDiffExpected reads a manifest of expected changes from a file, see ParseDiffExpected
+func LoadDiffExpected(fileName string) (*DiffExpected, error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read expected manifest")
+	}
+	return ParseDiffExpected(data)
+}
+
+func (e *DiffExpected) Matches(f *DiffNode) bool {
+	p := f.DisplayPath()
+	if e.paths[p] {
+		return true
+	}
+	for _, pattern := range e.patterns {
+		if ok, _ := path.Match(pattern, p); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,39 @@
+package pkg_test
+
+import (
+	"bytes"
+	"github.com/cmaster11/btrfs-diff/pkg"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestExpected(t *testing.T) {
+	diff, err := pkg.ProcessBTRFSStream(bytes.NewReader(buildStream(t, newTestStream().
+		MkFile("etc/hostname", 1).
+		MkFile("var/log/a.log", 2).
+		MkFile("var/log/sub/b.log", 3).
+		Unlink("etc/passwd").
+		End())))
+	require.NoError(t, err)
+
+	unexpected := func(manifest string) []string {
+		expected, err := pkg.ParseDiffExpected([]byte(manifest))
+		require.NoError(t, err)
+		var paths []string
+		require.NoError(t, diff.EachNode((&pkg.ProcessFileWithOutputArgs{Expected: expected}).IgnoreMatcher(), func(_ pkg.DiffBucket, n *pkg.DiffNode) error {
+			paths = append(paths, n.DisplayPath())
+			return nil
+		}))
+		return paths
+	}
+	require.Equal(t, []string{"/var/log/sub/b.log", "/etc/passwd"}, unexpected(`["/etc/hostname", "/var/log/*"]`))
+	require.Equal(t, []string{"/etc/hostname", "/var/log/a.log"}, unexpected(`{"added": [{"path": "/var/log/sub/b.log"}], "deleted": [{"path": "/etc/passwd"}]}`))
+
+	// A diff is fully expected by itself
+	var buf bytes.Buffer
+	require.NoError(t, pkg.WriteDiff(&buf, diff, &pkg.ProcessFileWithOutputArgs{Format: pkg.OutputFormatJSON}))
+	require.Empty(t, unexpected(buf.String()))
+
+	_, err = pkg.ParseDiffExpected([]byte(`["/var/log/["]`))
+	require.ErrorContains(t, err, "invalid pattern")
+}
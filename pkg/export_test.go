@@ -0,0 +1,5 @@
+package pkg
+
+// UnregisterFormatter exposes unregisterFormatter to the tests of pkg_test, to clean up the formatters
+// they register
+var UnregisterFormatter = unregisterFormatter
@@ -0,0 +1,83 @@
+package pkg
+
+// DiffNodeMatcher matches the nodes which have to be left out of the output
+type DiffNodeMatcher interface {
+	Matches(f *DiffNode) bool
+}
+
+// DiffIgnoreFunc adapts a plain function to a DiffNodeMatcher
+type DiffIgnoreFunc func(f *DiffNode) bool
+
+func (fn DiffIgnoreFunc) Matches(f *DiffNode) bool {
+	return fn(f)
+}
+
+// DiffIgnoreAny matches a node if any of its matchers matches it
+type DiffIgnoreAny []DiffNodeMatcher
+
+func (a DiffIgnoreAny) Matches(f *DiffNode) bool {
+	for _, m := range a {
+		if isIgnored(m, f) {
+			return true
+		}
+	}
+	return false
+}
+
+func isIgnored(ignore DiffNodeMatcher, f *DiffNode) bool {
+	return ignore != nil && ignore.Matches(f)
+}
+
+// IgnoreDirMetadataChanges matches directories which have only been changed because of metadata
+// updates (e.g. a mtime bump because their contents changed). Created or deleted directories are kept.
+func IgnoreDirMetadataChanges(f *DiffNode) bool {
+	return f.NodeType == DiffNodeTypeDir && f.State == opModify && !f.DeletedInSnapshot && !f.HasContentChanges()
+}
+
+// IgnoreImpliedDirs matches created directories which contain created nodes, e.g. the parents created by
+// `mkdir -p dir/subdir/leafdir`, implied by the deepest created path. Empty created directories are kept.
+func IgnoreImpliedDirs(f *DiffNode) bool {
+	if f.NodeType != DiffNodeTypeDir || f.State != opCreate {
+		return false
+	}
+	for _, child := range f.Children {
+		if child.State == opCreate {
+			return true
+		}
+	}
+	return false
+}
+
+// IgnoreMatcher returns the matcher of all the nodes to be left out of the output
+func (args *ProcessFileWithOutputArgs) IgnoreMatcher() DiffNodeMatcher {
+	ignore := DiffIgnoreAny{args.IgnorePaths}
+	if args.NoDirMTime {
+		ignore = append(ignore, DiffIgnoreFunc(IgnoreDirMetadataChanges))
+	}
+	if args.MinChangePct > 0 {
+		ignore = append(ignore, DiffIgnoreFunc(func(f *DiffNode) bool {
+			fraction := f.ChangedFraction()
+			return fraction == nil || *fraction*100 < args.MinChangePct
+		}))
+	}
+	if args.XattrPrefix != "" {
+		ignore = append(ignore, DiffIgnoreFunc(func(f *DiffNode) bool {
+			return !f.HasXattrChange(args.XattrPrefix)
+		}))
+	}
+	if args.ChurnOnly {
+		ignore = append(ignore, DiffIgnoreFunc(func(f *DiffNode) bool {
+			return !f.IsChurn()
+		}))
+	}
+	if args.LeavesOnly {
+		ignore = append(ignore, DiffIgnoreFunc(IgnoreImpliedDirs))
+	}
+	if args.Security {
+		ignore = append(ignore, DiffIgnoreFunc(IgnoreNonSecurityChanges))
+	}
+	if args.Expected != nil {
+		ignore = append(ignore, args.Expected)
+	}
+	return ignore
+}
@@ -0,0 +1,84 @@
+package pkg
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/pkg/errors"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Fingerprint returns a SHA-256 of the structure of the diff: the path, state and change kinds of
+// every reportable node. Timestamps, offsets and sizes are left out, so that two diffs which changed
+// the same nodes in the same way have the same fingerprint.
+func (d *Diff) Fingerprint() string {
+	return d.FilteredFingerprint(nil)
+}
+
+// FilteredFingerprint is like Fingerprint, leaving out the nodes matched by ignore as well
+func (d *Diff) FilteredFingerprint(ignore DiffNodeMatcher) string {
+	var lines []string
+	d.traverseReportable(ignore, func(n *DiffNode) {
+		state := n.State.String()
+		if n.DeletedInSnapshot && n.State != opDelete {
+			state += "+" + opDelete.String()
+		}
+
+		var kinds []string
+		for _, kind := range n.ChangeKinds() {
+			if kind != DiffChangeKindUtime {
+				kinds = append(kinds, kind)
+			}
+		}
+		sort.Strings(kinds)
+
+		lines = append(lines, fmt.Sprintf("%q\t%s\t%s\n", n.GetChainPath(), state, strings.Join(kinds, ",")))
+	})
+	sort.Strings(lines)
+
+	h := sha256.New()
+	for _, line := range lines {
+		h.Write([]byte(line))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+var regexFingerprint = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// ErrBaselineChanged is matched (see errors.Is) by the BaselineError returned when the fingerprint of a
+// diff differs from the baseline one
+var ErrBaselineChanged = errors.New("diff changed from the baseline")
+
+// BaselineError reports a diff which changed structurally from a known-good one, see Fingerprint
+type BaselineError struct {
+	Fingerprint string
+	Baseline    string
+}
+
+func (e *BaselineError) Error() string {
+	return fmt.Sprintf("%s: fingerprint %s, expected %s", ErrBaselineChanged, e.Fingerprint, e.Baseline)
+}
+
+func (e *BaselineError) Is(target error) bool {
+	return target == ErrBaselineChanged
+}
+
+// ReadBaseline returns the baseline fingerprint, either given as is, or read from a file (e.g. the
+// output of a previous run with the fingerprint format)
+func ReadBaseline(value string) (string, error) {
+	if regexFingerprint.MatchString(value) {
+		return value, nil
+	}
+	data, err := os.ReadFile(value)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to read baseline file")
+	}
+	fingerprint := strings.TrimSpace(string(data))
+	if !regexFingerprint.MatchString(fingerprint) {
+		return "", errors.Errorf("baseline file %s does not contain a fingerprint", value)
+	}
+	return fingerprint, nil
+}
@@ -0,0 +1,71 @@
+package pkg_test
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/cmaster11/btrfs-diff/pkg"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFingerprint(t *testing.T) {
+	build := func(mtime time.Time, size uint64) *pkg.Diff {
+		p := &pkg.Processor{CaptureTimestamps: true}
+		diff, err := p.Process(bytes.NewReader(buildStream(t, newTestStream().
+			MkFile("a", 1).
+			Write("a", size, make([]byte, 10)).
+			Utimes("a", mtime, mtime, mtime).
+			Unlink("b").
+			End())))
+		require.NoError(t, err)
+		return diff
+	}
+
+	fp := build(time.Unix(1, 0), 0).Fingerprint()
+	require.Len(t, fp, 64)
+	require.Equal(t, fp, build(time.Unix(2, 0), 100).Fingerprint())
+
+	other, err := pkg.ProcessBTRFSStream(bytes.NewReader(buildStream(t, newTestStream().
+		MkFile("a", 1).
+		Unlink("b").
+		End())))
+	require.NoError(t, err)
+	require.NotEqual(t, fp, other.Fingerprint())
+}
+
+func TestBaseline(t *testing.T) {
+	diff, err := pkg.ProcessFile(fmt.Sprintf("%s/inc-008.snap", testDir))
+	require.NoError(t, err)
+	fp := diff.Fingerprint()
+
+	baseline, err := pkg.ReadBaseline(fp)
+	require.NoError(t, err)
+	require.Equal(t, fp, baseline)
+
+	baselineFile := filepath.Join(t.TempDir(), "baseline.txt")
+	require.NoError(t, os.WriteFile(baselineFile, []byte(fp+"\n"), 0644))
+	baseline, err = pkg.ReadBaseline(baselineFile)
+	require.NoError(t, err)
+	require.Equal(t, fp, baseline)
+
+	require.NoError(t, os.WriteFile(baselineFile, []byte("not a fingerprint"), 0644))
+	_, err = pkg.ReadBaseline(baselineFile)
+	require.Error(t, err)
+
+	// Ignored nodes are left out of the fingerprint
+	args := &pkg.ProcessFileWithOutputArgs{IgnorePaths: pkg.DiffIgnorePaths{regexp.MustCompile("^/bar/baz_file$")}}
+	require.Equal(t, fp, diff.FilteredFingerprint(nil))
+	require.NotEqual(t, fp, diff.FilteredFingerprint(args.IgnoreMatcher()))
+
+	var buf bytes.Buffer
+	require.NoError(t, pkg.WriteDiff(&buf, diff, &pkg.ProcessFileWithOutputArgs{Format: pkg.OutputFormatFingerprint}))
+	require.Equal(t, fp, strings.TrimSpace(buf.String()))
+
+	err = &pkg.BaselineError{Fingerprint: fp, Baseline: strings.Repeat("0", 64)}
+	require.ErrorIs(t, err, pkg.ErrBaselineChanged)
+}
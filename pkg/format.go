@@ -0,0 +1,340 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"sort"
+	"strings"
+)
+
+type OutputFormat = string
+
+const (
+	OutputFormatText             OutputFormat = "text"
+	OutputFormatJSON             OutputFormat = "json"
+	OutputFormatDOT              OutputFormat = "dot"
+	OutputFormatRecoveryManifest OutputFormat = "recovery-manifest"
+	OutputFormatScript           OutputFormat = "script"
+	OutputFormatNDJSON           OutputFormat = "ndjson"
+	OutputFormatNames            OutputFormat = "names"
+	OutputFormatJSONPatch        OutputFormat = "json-patch"
+	OutputFormatDiffStat         OutputFormat = "diff-stat"
+	// Binary, see diff.proto
+	OutputFormatProto OutputFormat = "proto"
+	// Only the fingerprint of the diff, see Diff.Fingerprint
+	OutputFormatFingerprint OutputFormat = "fingerprint"
+)
+
+type JSONStyle = string
+
+const (
+	JSONStyleCompact JSONStyle = "compact"
+	JSONStylePretty  JSONStyle = "pretty"
+)
+
+// Formatter writes a diff in a specific output format
+type Formatter interface {
+	Format(w io.Writer, d *Diff, args *ProcessFileWithOutputArgs) error
+}
+
+// FormatterFunc adapts a plain function to a Formatter
+type FormatterFunc func(w io.Writer, d *Diff, args *ProcessFileWithOutputArgs) error
+
+func (fn FormatterFunc) Format(w io.Writer, d *Diff, args *ProcessFileWithOutputArgs) error {
+	return fn(w, d, args)
+}
+
+var formatters = map[OutputFormat]Formatter{
+	OutputFormatText: FormatterFunc(func(w io.Writer, d *Diff, args *ProcessFileWithOutputArgs) error {
+		return d.print(w, args.IgnoreMatcher(), args)
+	}),
+	OutputFormatJSON: FormatterFunc(func(w io.Writer, d *Diff, args *ProcessFileWithOutputArgs) error {
+		str, err := d.printJSON(args.IgnoreMatcher(), args)
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(w, str)
+		return err
+	}),
+	OutputFormatDOT: FormatterFunc(func(w io.Writer, d *Diff, _ *ProcessFileWithOutputArgs) error {
+		return d.WriteDOT(w)
+	}),
+	OutputFormatRecoveryManifest: FormatterFunc(func(w io.Writer, d *Diff, args *ProcessFileWithOutputArgs) error {
+		return d.WriteRecoveryManifest(w, args.IgnoreMatcher())
+	}),
+	OutputFormatNDJSON: FormatterFunc(func(w io.Writer, d *Diff, args *ProcessFileWithOutputArgs) error {
+		return NewNDJSONEncoder(w).EncodeBucket(context.Background(), d, args.IgnoreMatcher(), args.Op)
+	}),
+	OutputFormatNames: FormatterFunc(func(w io.Writer, d *Diff, args *ProcessFileWithOutputArgs) error {
+		sep := byte('\n')
+		if args.Print0 {
+			sep = 0
+		}
+		return d.WriteNames(w, args.IgnoreMatcher(), args.Op, sep)
+	}),
+	OutputFormatJSONPatch: FormatterFunc(func(w io.Writer, d *Diff, args *ProcessFileWithOutputArgs) error {
+		return d.WriteJSONPatch(w, args.IgnoreMatcher(), args.JSONStyle)
+	}),
+	OutputFormatDiffStat: FormatterFunc(func(w io.Writer, d *Diff, args *ProcessFileWithOutputArgs) error {
+		return d.WriteDiffStat(w, args.IgnoreMatcher(), args.SortBy, args.Bytes)
+	}),
+	OutputFormatProto: FormatterFunc(func(w io.Writer, d *Diff, args *ProcessFileWithOutputArgs) error {
+		s, err := d.outputStruct(args.IgnoreMatcher(), args)
+		if err != nil {
+			return err
+		}
+		return writeProtoDelimited(w, s.ToProto())
+	}),
+	OutputFormatScript: FormatterFunc(func(w io.Writer, d *Diff, args *ProcessFileWithOutputArgs) error {
+		return d.WriteScript(w, args.IgnoreMatcher())
+	}),
+	OutputFormatFingerprint: FormatterFunc(func(w io.Writer, d *Diff, args *ProcessFileWithOutputArgs) error {
+		_, err := io.WriteString(w, d.FilteredFingerprint(args.IgnoreMatcher()))
+		return err
+	}),
+}
+
+// builtinFormats are the names of the formatters of the package, which cannot be unregistered
+var builtinFormats = func() map[OutputFormat]bool {
+	names := make(map[OutputFormat]bool, len(formatters))
+	for name := range formatters {
+		names[name] = true
+	}
+	return names
+}()
+
+// RegisterFormatter makes a custom formatter available by name, e.g. through --format.
+// The registry is not safe for concurrent use, so formatters should be registered at init time.
+// Registering a name twice (including the built-in ones) panics.
+func RegisterFormatter(name OutputFormat, f Formatter) {
+	if f == nil {
+		panic("RegisterFormatter: formatter is nil")
+	}
+	if _, ok := formatters[name]; ok {
+		panic(fmt.Sprintf("RegisterFormatter: formatter %q already registered", name))
+	}
+	formatters[name] = f
+}
+
+// unregisterFormatter removes a formatter added by RegisterFormatter in a test, so that its name can be
+// registered again. Built-in formatters are never removed.
+func unregisterFormatter(name OutputFormat) {
+	if builtinFormats[name] {
+		panic(fmt.Sprintf("unregisterFormatter: formatter %q is built-in", name))
+	}
+	delete(formatters, name)
+}
+
+// FormatNames returns the names of all the available output formats, sorted
+func FormatNames() []string {
+	var names []string
+	for name := range formatters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func getFormatter(name OutputFormat) (Formatter, error) {
+	f, ok := formatters[name]
+	if !ok {
+		return nil, errors.Errorf("unsupported output format %q", name)
+	}
+	return f, nil
+}
+
+func (args *ProcessFileWithOutputArgs) validateOutput() error {
+	format := args.getFormat()
+	if _, err := getFormatter(format); err != nil {
+		return err
+	}
+	if args.CountOnly && format != OutputFormatText && format != OutputFormatJSON {
+		return errors.Errorf("count only output is not supported with the %s format", format)
+	}
+	if args.ByExtension && format != OutputFormatText && format != OutputFormatJSON {
+		return errors.Errorf("by extension output is not supported with the %s format", format)
+	}
+	if args.ByExtension && args.CountOnly {
+		return errors.New("by extension and count only outputs cannot be combined")
+	}
+	if args.RenamesOnly && format != OutputFormatText && format != OutputFormatJSON {
+		return errors.Errorf("renames only output is not supported with the %s format", format)
+	}
+	if args.RenamesOnly && (args.CountOnly || args.ByExtension) {
+		return errors.New("renames only output cannot be combined with the count only and by extension ones")
+	}
+	if args.Audit && format != OutputFormatText && format != OutputFormatJSON {
+		return errors.Errorf("audit output is not supported with the %s format", format)
+	}
+	if args.Audit && (args.CountOnly || args.ByExtension || args.RenamesOnly) {
+		return errors.New("audit output cannot be combined with the count only, by extension and renames only ones")
+	}
+	switch args.Op {
+	case "":
+	case DiffBucketAdded, DiffBucketChanged, DiffBucketDeleted:
+		if format != OutputFormatJSON && format != OutputFormatNDJSON && format != OutputFormatNames && format != OutputFormatProto {
+			return errors.Errorf("filtering by operation is not supported with the %s format", format)
+		}
+	default:
+		return errors.Errorf("unsupported operation %q", args.Op)
+	}
+	switch args.RootLabel {
+	case "", DiffRootLabelSlash, DiffRootLabelDot, DiffRootLabelSubvolume:
+	default:
+		return errors.Errorf("unsupported root label %q", args.RootLabel)
+	}
+	if args.Print0 && format != OutputFormatNames {
+		return errors.Errorf("null separated output is not supported with the %s format", format)
+	}
+	if args.ColorJSON && !isJSONFormat(format) && !((args.CountOnly || args.ByExtension || args.RenamesOnly || args.Audit) && format == OutputFormatJSON) {
+		return errors.Errorf("json colors are not supported with the %s format", format)
+	}
+	switch args.Bytes {
+	case "":
+	case BytesFormatHuman, BytesFormatRaw:
+		if format != OutputFormatText && format != OutputFormatDiffStat {
+			return errors.Errorf("the bytes format is not supported with the %s format, which always has raw bytes", format)
+		}
+	default:
+		return errors.Errorf("unsupported bytes format %q", args.Bytes)
+	}
+	switch args.JSONStyle {
+	case "", JSONStyleCompact, JSONStylePretty:
+	default:
+		return errors.Errorf("unsupported json style %q", args.JSONStyle)
+	}
+	return nil
+}
+
+// newlineWriter writes the output of a formatter straight to the underlying writer, only tracking how
+// it ends, so that it can end with exactly one newline (or none, if noNewline is set)
+type newlineWriter struct {
+	w         io.Writer
+	noNewline bool
+	// Whether anything has been written, and whether it ended with a newline, which is held back if
+	// noNewline is set, until more output follows
+	written bool
+	newline bool
+}
+
+func (nw *newlineWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if nw.noNewline && nw.newline {
+		if _, err := nw.w.Write([]byte{'\n'}); err != nil {
+			return 0, err
+		}
+	}
+	nw.written = true
+	nw.newline = p[len(p)-1] == '\n'
+	data := p
+	if nw.noNewline && nw.newline {
+		data = p[:len(p)-1]
+	}
+	if len(data) > 0 {
+		if _, err := nw.w.Write(data); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Flush flushes the underlying writer, so that streaming formatters (see NDJSONEncoder) still flush
+// every line
+func (nw *newlineWriter) Flush() error {
+	return flushWriter(nw.w)
+}
+
+// end ends the output with a newline, unless nothing has been written
+func (nw *newlineWriter) end() error {
+	if !nw.written || nw.newline || nw.noNewline {
+		return nil
+	}
+	_, err := nw.w.Write([]byte{'\n'})
+	return err
+}
+
+// WriteDiff writes an already processed diff, as ProcessFileAndOutput does. The output always ends
+// with a newline, unless args.NoNewline is set. It is written while being generated (except for the
+// colorized JSON), so it can be partial if the formatting fails.
+func WriteDiff(w io.Writer, d *Diff, args *ProcessFileWithOutputArgs) error {
+	if err := args.validateOutput(); err != nil {
+		return err
+	}
+	if args.RootLabel != "" {
+		d.RootLabel = args.RootLabel
+	}
+
+	format := args.getFormat()
+	var out io.Writer
+	var colored *strings.Builder
+	var nw *newlineWriter
+	if args.Print0 || format == OutputFormatProto {
+		// Paths can end with a newline as well, and binary output cannot be altered, so the output is
+		// written as is
+		out = w
+	} else {
+		nw = &newlineWriter{w: w, noNewline: args.NoNewline}
+		out = nw
+		if args.ColorJSON {
+			// Highlighting needs the whole tokens
+			colored = &strings.Builder{}
+			out = colored
+		}
+	}
+
+	if args.CountOnly {
+		if err := d.WriteCounts(out, args.IgnoreMatcher(), format == OutputFormatJSON); err != nil {
+			return err
+		}
+	} else if args.ByExtension {
+		if err := d.WriteExtensions(out, args.IgnoreMatcher(), format == OutputFormatJSON, args.Bytes); err != nil {
+			return err
+		}
+	} else if args.RenamesOnly {
+		if err := d.WriteRenames(out, args.IgnoreMatcher(), format == OutputFormatJSON); err != nil {
+			return err
+		}
+	} else if args.Audit {
+		if err := d.WriteAudit(out, args.IgnoreMatcher(), args.AuditRules, format == OutputFormatJSON); err != nil {
+			return err
+		}
+	} else {
+		formatter, _ := getFormatter(format)
+		if err := formatter.Format(out, d, args); err != nil {
+			return errors.Wrapf(err, "failed to output %s", format)
+		}
+	}
+
+	if nw == nil {
+		return nil
+	}
+	if colored != nil {
+		if _, err := io.WriteString(nw, HighlightJSON(colored.String())); err != nil {
+			return err
+		}
+	}
+	return nw.end()
+}
+
+func isJSONFormat(format OutputFormat) bool {
+	return format == OutputFormatJSON || format == OutputFormatNDJSON || format == OutputFormatJSONPatch
+}
+
+// getFormat returns the output format, honoring the deprecated per-format flags
+func (args *ProcessFileWithOutputArgs) getFormat() OutputFormat {
+	switch {
+	case args.Format != "":
+		return args.Format
+	case args.RecoveryManifest:
+		return OutputFormatRecoveryManifest
+	case args.DOT:
+		return OutputFormatDOT
+	case args.JSON:
+		return OutputFormatJSON
+	}
+	return OutputFormatText
+}
@@ -0,0 +1,96 @@
+package pkg_test
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/cmaster11/btrfs-diff/pkg"
+	"github.com/stretchr/testify/require"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestWriteDiff(t *testing.T) {
+	diff, err := pkg.ProcessFile(fmt.Sprintf("%s/inc-001.snap", testDir))
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, pkg.WriteDiff(&buf, diff, &pkg.ProcessFileWithOutputArgs{Format: pkg.OutputFormatJSON}))
+	require.True(t, strings.HasSuffix(buf.String(), "}\n"))
+	require.NotContains(t, buf.String(), "\n  ")
+
+	buf.Reset()
+	require.NoError(t, pkg.WriteDiff(&buf, diff, &pkg.ProcessFileWithOutputArgs{Format: pkg.OutputFormatJSON, JSONStyle: pkg.JSONStylePretty, NoNewline: true}))
+	require.Contains(t, buf.String(), "\n  ")
+	require.True(t, strings.HasSuffix(buf.String(), "}"))
+
+	// Outputs which already end with a newline do not get a second one
+	buf.Reset()
+	require.NoError(t, pkg.WriteDiff(&buf, diff, &pkg.ProcessFileWithOutputArgs{Format: pkg.OutputFormatDOT}))
+	require.True(t, strings.HasSuffix(buf.String(), "}\n"))
+	require.False(t, strings.HasSuffix(buf.String(), "\n\n"))
+
+	require.ErrorContains(t, pkg.WriteDiff(&buf, diff, &pkg.ProcessFileWithOutputArgs{JSONStyle: "wide"}), "unsupported json style")
+
+	// The text output goes to the writer as well, not to the logs
+	buf.Reset()
+	require.NoError(t, pkg.WriteDiff(&buf, diff, &pkg.ProcessFileWithOutputArgs{Format: pkg.OutputFormatText}))
+	require.Equal(t, "=== Tree ===\n[FILE][added] /foo_file [change=write:offset=0:data_len=4] [change=chown:uid=1000,gid=1000] [change=chmod:mode=664]\n", buf.String())
+
+	// Every ndjson line reaches the writer, and is flushed, as soon as it is encoded
+	diff, err = pkg.ProcessBTRFSStream(bytes.NewReader(buildStream(t, newTestStream().
+		MkFile("a", 1).
+		Chmod("b", 0644).
+		Unlink("c").
+		End())))
+	require.NoError(t, err)
+	var out flushCounter
+	require.NoError(t, pkg.WriteDiff(&out, diff, &pkg.ProcessFileWithOutputArgs{Format: pkg.OutputFormatNDJSON}))
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	require.Len(t, lines, 3)
+	require.Equal(t, 3, out.flushes)
+	require.Len(t, out.flushed, len(lines))
+	for i, flushed := range out.flushed {
+		require.Equal(t, strings.Join(lines[:i+1], "\n")+"\n", flushed)
+	}
+}
+
+type flushCounter struct {
+	bytes.Buffer
+	flushes int
+	// The output written at every flush
+	flushed []string
+}
+
+func (f *flushCounter) Flush() {
+	f.flushes++
+	f.flushed = append(f.flushed, f.String())
+}
+
+func TestFormatNames(t *testing.T) {
+	require.Equal(t, []string{"diff-stat", "dot", "fingerprint", "json", "json-patch", "names", "ndjson", "proto", "recovery-manifest", "script", "text"}, pkg.FormatNames())
+}
+
+func TestRegisterFormatter(t *testing.T) {
+	var out bytes.Buffer
+	pkg.RegisterFormatter("test-paths", pkg.FormatterFunc(func(_ io.Writer, d *pkg.Diff, args *pkg.ProcessFileWithOutputArgs) error {
+		for _, node := range d.GetDiffStruct(args.IgnoreMatcher()).Added {
+			_, _ = fmt.Fprintln(&out, node.GetChainPath())
+		}
+		return nil
+	}))
+	t.Cleanup(func() { pkg.UnregisterFormatter("test-paths") })
+	require.Contains(t, pkg.FormatNames(), "test-paths")
+	require.Panics(t, func() {
+		pkg.RegisterFormatter(pkg.OutputFormatJSON, pkg.FormatterFunc(nil))
+	})
+	require.Panics(t, func() {
+		pkg.UnregisterFormatter(pkg.OutputFormatJSON)
+	})
+
+	require.NoError(t, pkg.ProcessFileAndOutput(&pkg.ProcessFileWithOutputArgs{
+		ArgFiles: []string{fmt.Sprintf("%s/inc-001.snap", testDir)},
+		Format:   "test-paths",
+	}))
+	require.Equal(t, "/foo_file\n", out.String())
+}
@@ -0,0 +1,61 @@
+package pkg
+
+import (
+	"sort"
+	"strings"
+)
+
+// FollowHardlinks reports the content changes of a file on all the other links to it in the diff,
+// returning how many nodes have been changed. Links are grouped by inode number, so only nodes whose
+// inode is known (see DiffNode.Ino) are considered. The links without content changes of their own
+// get the ones of the others, and are marked as ChangedViaHardlink: their extents are left as they
+// are, so that the bytes written to the file are still counted once in stats.
+func (d *Diff) FollowHardlinks() int {
+	links := make(map[uint64][]*DiffNode)
+	d.root.traverse(func(n *DiffNode) {
+		if n.Ino == 0 || n.State == opDelete || n.NodeType == DiffNodeTypeDir || n.isBTRFSTemporaryNode() {
+			return
+		}
+		links[n.Ino] = append(links[n.Ino], n)
+	})
+
+	count := 0
+	for _, nodes := range links {
+		if len(nodes) < 2 {
+			continue
+		}
+		sort.Slice(nodes, func(i, j int) bool {
+			return nodes[i].GetChainPath() < nodes[j].GetChainPath()
+		})
+
+		// Collected before changing any link, so that the result does not depend on their order
+		var changes []string
+		var unchanged []*DiffNode
+		for _, n := range nodes {
+			if !n.HasContentChanges() {
+				unchanged = append(unchanged, n)
+				continue
+			}
+			for _, change := range n.Changes {
+				kind, _, _ := strings.Cut(change, ":")
+				if contentChangeKinds[kind] {
+					changes = append(changes, change)
+				}
+			}
+		}
+		if len(changes) == 0 {
+			continue
+		}
+
+		for _, n := range unchanged {
+			n.Changes = append(n.Changes, changes...)
+			n.ChangedViaHardlink = true
+			if n.State != opCreate {
+				n.State = opModify
+			}
+			d.proc().info("changed %s via hardlink", n)
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,47 @@
+package pkg_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/cmaster11/btrfs-diff/pkg"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestFollowHardlinks(t *testing.T) {
+	stream := buildStream(t, newTestStream().
+		MkFile("o257-7-0", 257).
+		Rename("o257-7-0", "file").
+		Link("hard", "file").
+		MkDir("dir", 258).
+		Link("dir/other", "file").
+		Write("file", 0, make([]byte, 10)).
+		Chmod("hard", 0644).
+		MkFile("o259-7-0", 259).
+		Rename("o259-7-0", "single").
+		Write("single", 0, make([]byte, 5)).
+		End())
+
+	diff, err := pkg.ProcessBTRFSStream(bytes.NewReader(stream))
+	require.NoError(t, err)
+	require.Equal(t, 2, diff.FollowHardlinks())
+	nodes := diff.FlatMap(nil)
+
+	require.False(t, nodes["/file"].ChangedViaHardlink)
+	require.True(t, nodes["/hard"].ChangedViaHardlink)
+	require.Equal(t, []string{"chmod:mode=644", "write:offset=0:data_len=10"}, nodes["/hard"].Changes)
+	require.True(t, nodes["/dir/other"].ChangedViaHardlink)
+	require.Contains(t, nodes["/dir/other"].String(), "[change=write:offset=0:data_len=10] [via=hardlink]")
+	require.False(t, nodes["/single"].ChangedViaHardlink)
+
+	// Bytes are only counted on the written link
+	require.Equal(t, uint64(15), diff.Stats(nil).BytesWritten)
+	require.Zero(t, nodes["/hard"].TotalBytesWritten())
+
+	jsonBytes, err := json.Marshal(nodes["/hard"])
+	require.NoError(t, err)
+	require.Contains(t, string(jsonBytes), `"changed_via_hardlink":true`)
+
+	// Following again changes nothing
+	require.Equal(t, 0, diff.FollowHardlinks())
+}
@@ -0,0 +1,32 @@
+package pkg_test
+
+import (
+	"github.com/cmaster11/btrfs-diff/internal/streamtest"
+	"github.com/cmaster11/btrfs-diff/pkg"
+	"github.com/stretchr/testify/require"
+	"path"
+	"testing"
+)
+
+var testDir = path.Join("..", "test_data")
+
+// newTestStream returns a builder of an incremental stream, starting with the SNAPSHOT command which
+// most tests share
+func newTestStream() *streamtest.Builder {
+	return streamtest.NewBuilder().
+		Snapshot("002", "8ceaf94ac851d346841abc2b82323625", 12, "b4233aaf045b6a4b89a2c08c8c1b4743", 10)
+}
+
+func getPaths(nodes []*pkg.DiffNode) []string {
+	var paths []string
+	for _, n := range nodes {
+		paths = append(paths, n.GetChainPath())
+	}
+	return paths
+}
+
+func buildStream(t *testing.T, b *streamtest.Builder) []byte {
+	stream, err := b.Bytes()
+	require.NoError(t, err)
+	return stream
+}
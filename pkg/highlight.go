@@ -0,0 +1,66 @@
+package pkg
+
+import (
+	"strings"
+)
+
+const (
+	ansiReset   = "\x1b[0m"
+	ansiKey     = "\x1b[1;34m"
+	ansiString  = "\x1b[32m"
+	ansiNumber  = "\x1b[36m"
+	ansiLiteral = "\x1b[33m"
+	ansiNull    = "\x1b[90m"
+)
+
+// HighlightJSON colorizes JSON text (e.g. the output of the json formats) with ANSI escape codes,
+// for terminals. Whitespace and punctuation are left as they are.
+func HighlightJSON(s string) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == '"':
+			end := jsonStringEnd(s, i)
+			color := ansiString
+			if strings.HasPrefix(strings.TrimLeft(s[end:], " \t\r\n"), ":") {
+				color = ansiKey
+			}
+			sb.WriteString(color + s[i:end] + ansiReset)
+			i = end
+		case c == '-' || (c >= '0' && c <= '9'):
+			end := i + 1
+			for end < len(s) && strings.IndexByte("0123456789.eE+-", s[end]) >= 0 {
+				end++
+			}
+			sb.WriteString(ansiNumber + s[i:end] + ansiReset)
+			i = end
+		case strings.HasPrefix(s[i:], "true"):
+			sb.WriteString(ansiLiteral + "true" + ansiReset)
+			i += 4
+		case strings.HasPrefix(s[i:], "false"):
+			sb.WriteString(ansiLiteral + "false" + ansiReset)
+			i += 5
+		case strings.HasPrefix(s[i:], "null"):
+			sb.WriteString(ansiNull + "null" + ansiReset)
+			i += 4
+		default:
+			sb.WriteByte(c)
+			i++
+		}
+	}
+	return sb.String()
+}
+
+// jsonStringEnd returns the index right after the closing quote of the string starting at start
+func jsonStringEnd(s string, start int) int {
+	for i := start + 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i + 1
+		}
+	}
+	return len(s)
+}
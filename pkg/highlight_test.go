@@ -0,0 +1,29 @@
+package pkg_test
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/cmaster11/btrfs-diff/pkg"
+	"github.com/stretchr/testify/require"
+	"regexp"
+	"testing"
+)
+
+func TestColorJSON(t *testing.T) {
+	require.Equal(t, "{\x1b[1;34m\"a\\\"\"\x1b[0m: [\x1b[36m-1.5e3\x1b[0m, \x1b[32m\"b:\"\x1b[0m, \x1b[33mtrue\x1b[0m, \x1b[90mnull\x1b[0m]}",
+		pkg.HighlightJSON(`{"a\"": [-1.5e3, "b:", true, null]}`))
+
+	diff, err := pkg.ProcessFile(fmt.Sprintf("%s/inc-010.snap", testDir))
+	require.NoError(t, err)
+	for _, format := range []pkg.OutputFormat{pkg.OutputFormatJSON, pkg.OutputFormatNDJSON, pkg.OutputFormatJSONPatch} {
+		var plain, colored bytes.Buffer
+		require.NoError(t, pkg.WriteDiff(&plain, diff, &pkg.ProcessFileWithOutputArgs{Format: format, JSONStyle: pkg.JSONStylePretty}))
+		require.NoError(t, pkg.WriteDiff(&colored, diff, &pkg.ProcessFileWithOutputArgs{Format: format, JSONStyle: pkg.JSONStylePretty, ColorJSON: true}))
+		require.NotContains(t, plain.String(), "\x1b")
+		require.Contains(t, colored.String(), "\x1b")
+		require.Equal(t, plain.String(), regexp.MustCompile("\x1b\\[[0-9;]*m").ReplaceAllString(colored.String(), ""))
+	}
+
+	var buf bytes.Buffer
+	require.ErrorContains(t, pkg.WriteDiff(&buf, diff, &pkg.ProcessFileWithOutputArgs{Format: pkg.OutputFormatNames, ColorJSON: true}), "json colors are not supported")
+}
@@ -0,0 +1,82 @@
+package pkg
+
+import (
+	"bufio"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// ParseDiffIgnorePaths reads a list of patterns of paths to ignore, one per line, where blank lines
+// and lines starting with # are skipped. Patterns are regexes, or glob patterns (see path.Match) if
+// glob is true, which like in .gitignore also match the descendants of the matched paths.
+func ParseDiffIgnorePaths(r io.Reader, glob bool) (DiffIgnorePaths, error) {
+	var ignorePaths DiffIgnorePaths
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		pattern := strings.TrimSpace(scanner.Text())
+		if pattern == "" || strings.HasPrefix(pattern, "#") {
+			continue
+		}
+		if glob {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, errors.Wrapf(err, "invalid pattern %q on line %d", pattern, line)
+			}
+			pattern = globToRegexp(pattern)
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid pattern on line %d", line)
+		}
+		ignorePaths = append(ignorePaths, re)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to read ignore patterns")
+	}
+	return ignorePaths, nil
+}
+
+// LoadDiffIgnorePaths reads a list of patterns of paths to ignore from a file, see ParseDiffIgnorePaths
+func LoadDiffIgnorePaths(fileName string, glob bool) (DiffIgnorePaths, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open ignore file")
+	}
+	defer f.Close()
+	return ParseDiffIgnorePaths(f, glob)
+}
+
+// globToRegexp converts a valid glob pattern (see path.Match) to a regex matching the same paths and
+// their descendants
+func globToRegexp(pattern string) string {
+	var sb strings.Builder
+	sb.WriteString("^")
+	inClass := false
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case c == '\\' && i+1 < len(pattern):
+			i++
+			sb.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		case inClass:
+			if c == ']' {
+				inClass = false
+			}
+			sb.WriteByte(c)
+		case c == '[':
+			inClass = true
+			sb.WriteByte(c)
+		case c == '*':
+			sb.WriteString("[^/]*")
+		case c == '?':
+			sb.WriteString("[^/]")
+		default:
+			sb.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+	sb.WriteString("(/|$)")
+	return sb.String()
+}
@@ -0,0 +1,52 @@
+package pkg_test
+
+import (
+	"bytes"
+	"github.com/cmaster11/btrfs-diff/pkg"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+func TestIgnoreFile(t *testing.T) {
+	diff, err := pkg.ProcessBTRFSStream(bytes.NewReader(buildStream(t, newTestStream().
+		MkFile("app.log", 1).
+		MkFile("var/log/syslog.log", 2).
+		MkFile("var/log/nested/app.log", 3).
+		MkFile("cache/a/b", 4).
+		MkFile("kept", 5).
+		End())))
+	require.NoError(t, err)
+
+	getIgnored := func(content string, glob bool) []string {
+		ignorePaths, err := pkg.ParseDiffIgnorePaths(strings.NewReader(content), glob)
+		require.NoError(t, err)
+		all := diff.FlatMap(nil)
+		var paths []string
+		for p := range diff.FlatMap(ignorePaths) {
+			delete(all, p)
+		}
+		for p, n := range all {
+			if n.NodeType == pkg.DiffNodeTypeFile {
+				paths = append(paths, p)
+			}
+		}
+		return paths
+	}
+
+	require.ElementsMatch(t, []string{"/app.log", "/var/log/syslog.log", "/var/log/nested/app.log"}, getIgnored(`
+# Logs
+\.log$
+
+`, false))
+	require.ElementsMatch(t, []string{"/var/log/syslog.log", "/cache/a/b"}, getIgnored(`
+/var/log/*.log
+  # Caches
+/cache
+`, true))
+
+	_, err = pkg.ParseDiffIgnorePaths(strings.NewReader("# comment\n^/ok\n^/bad(\n"), false)
+	require.ErrorContains(t, err, "invalid pattern on line 3")
+	_, err = pkg.ParseDiffIgnorePaths(strings.NewReader("/bad[\n"), true)
+	require.ErrorContains(t, err, "on line 1")
+}
@@ -0,0 +1,262 @@
+package pkg
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// indexFileSuffix is appended to the name of a stream file to get the name of its sidecar index
+const indexFileSuffix = ".idx"
+
+// StreamIndex records where each command of a stream starts, so that the commands touching a path
+// can be read without parsing the whole stream, see Processor.LookupAt
+type StreamIndex struct {
+	// Size of the indexed stream, to detect stale indexes
+	Size int64 `json:"size"`
+	// Modification time of the indexed stream file, to detect stale indexes of files rewritten with the
+	// same size. Only known for the indexes of files, see IndexFile.
+	ModTime  time.Time           `json:"mod_time"`
+	Version  uint32              `json:"version"`
+	Commands []*StreamIndexEntry `json:"commands"`
+}
+
+type StreamIndexEntry struct {
+	Offset int64 `json:"offset"`
+	// Length of the whole command, header included
+	Len    int64  `json:"len"`
+	Type   uint16 `json:"type"`
+	Path   string `json:"path,omitempty"`
+	PathTo string `json:"path_to,omitempty"`
+}
+
+// BuildIndex reads a whole stream, recording the position and the paths of each command
+func (p *Processor) BuildIndex(r io.Reader) (*StreamIndex, error) {
+	counter := &countingReader{r: r}
+	input := bufio.NewReader(counter)
+	ver, err := validateBTRFSStream(input)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to validate btrfs stream")
+	}
+
+	idx := &StreamIndex{Version: ver}
+	for {
+		offset := counter.n - int64(input.Buffered())
+		command, err := p.readCommand(input, ver)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read command at offset %d", offset)
+		}
+		if err := command.ReadAllParams(); err != nil {
+			return nil, errors.Wrapf(err, "failed to read params of command %s at offset %d", command.Type.Name, offset)
+		}
+		entry := &StreamIndexEntry{
+			Offset: offset,
+			Len:    counter.n - int64(input.Buffered()) - offset,
+			Type:   command.OriginalType,
+		}
+		if path, err := command.Param(BTRFS_SEND_A_PATH); err == nil {
+			entry.Path = path.(string)
+		}
+		if pathTo, err := command.Param(BTRFS_SEND_A_PATH_TO); err == nil {
+			entry.PathTo = pathTo.(string)
+		}
+		command.release()
+
+		idx.Commands = append(idx.Commands, entry)
+		if entry.Type == BTRFS_SEND_C_END {
+			idx.Size = offset + entry.Len
+			return idx, nil
+		}
+	}
+}
+
+// Write stores the index as JSON, e.g. in a sidecar file
+func (idx *StreamIndex) Write(w io.Writer) error {
+	if err := json.NewEncoder(w).Encode(idx); err != nil {
+		return errors.Wrap(err, "failed to write index")
+	}
+	return nil
+}
+
+// ReadStreamIndex reads an index previously stored with StreamIndex.Write
+func ReadStreamIndex(r io.Reader) (*StreamIndex, error) {
+	idx := &StreamIndex{}
+	if err := json.NewDecoder(r).Decode(idx); err != nil {
+		return nil, errors.Wrap(err, "failed to read index")
+	}
+	return idx, nil
+}
+
+// IndexFile returns the index of a stream file, from its sidecar file (the stream file name plus
+// ".idx") if up to date (same size and modification time of the file), or building and storing it
+// otherwise
+func (p *Processor) IndexFile(fileName string) (*StreamIndex, error) {
+	stat, err := os.Stat(fileName)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to stat file")
+	}
+	indexFileName := fileName + indexFileSuffix
+	if f, err := os.Open(indexFileName); err == nil {
+		idx, err := ReadStreamIndex(f)
+		_ = f.Close()
+		if err == nil && idx.Size == stat.Size() && idx.ModTime.Equal(stat.ModTime()) {
+			return idx, nil
+		}
+		p.info("rebuilding stale index %s", indexFileName)
+	}
+
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open file")
+	}
+	defer f.Close()
+	idx, err := p.BuildIndex(f)
+	if err != nil {
+		return nil, err
+	}
+	idx.ModTime = stat.ModTime()
+
+	out, err := os.Create(indexFileName)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create index file")
+	}
+	if err := idx.Write(out); err != nil {
+		_ = out.Close()
+		return nil, err
+	}
+	if err := out.Close(); err != nil {
+		return nil, errors.Wrap(err, "failed to close index file")
+	}
+	return idx, nil
+}
+
+// lookupCommands returns the entries needed to rebuild the changes of path (a stream path, e.g.
+// "dir/file"): the subvolume/snapshot and end commands, and the commands on the path or below it,
+// including the ones on the other names it has had through renames (e.g. btrfs temporary names)
+func (idx *StreamIndex) lookupCommands(path string) []*StreamIndexEntry {
+	names := map[string]bool{path: true}
+	under := func(p string) bool {
+		for name := range names {
+			if isPathUnder(p, name) {
+				return true
+			}
+		}
+		return false
+	}
+
+	// Renames are followed both ways, until no new names are found
+	for changed := true; changed; {
+		changed = false
+		for _, e := range idx.Commands {
+			if e.Type != BTRFS_SEND_C_RENAME {
+				continue
+			}
+			var other []string
+			for name := range names {
+				for _, pair := range [][2]string{{e.Path, e.PathTo}, {e.PathTo, e.Path}} {
+					switch {
+					case isPathUnder(pair[0], name):
+						other = append(other, pair[1])
+					case isPathUnder(name, pair[0]):
+						// An ancestor of a path of interest renamed
+						other = append(other, pair[1]+strings.TrimPrefix(name, pair[0]))
+					}
+				}
+			}
+			for _, name := range other {
+				if !names[name] {
+					names[name] = true
+					changed = true
+				}
+			}
+		}
+	}
+
+	// Renames of ancestors are needed as well, to follow the paths of interest
+	related := func(p string) bool {
+		for name := range names {
+			if isPathUnder(p, name) || isPathUnder(name, p) {
+				return true
+			}
+		}
+		return false
+	}
+
+	var entries []*StreamIndexEntry
+	for i, e := range idx.Commands {
+		switch {
+		case i == 0 || e.Type == BTRFS_SEND_C_END,
+			e.Type == BTRFS_SEND_C_RENAME && (related(e.Path) || related(e.PathTo)),
+			under(e.Path):
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
+
+// LookupAt processes only the commands of the stream touching path, using the index to read them.
+// Inputs which cannot seek (or without an index) are read and indexed in memory first.
+func (p *Processor) LookupAt(r io.Reader, idx *StreamIndex, path string) (*Diff, error) {
+	path = strings.TrimPrefix(path, "/")
+	seeker, ok := r.(io.ReadSeeker)
+	if !ok || idx == nil {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read stream")
+		}
+		if idx, err = p.BuildIndex(bytes.NewReader(data)); err != nil {
+			return nil, err
+		}
+		seeker = bytes.NewReader(data)
+	}
+
+	var stream bytes.Buffer
+	stream.WriteString(BTRFS_SEND_STREAM_MAGIC)
+	stream.WriteByte(0)
+	_ = binary.Write(&stream, binary.LittleEndian, idx.Version)
+	for _, e := range idx.lookupCommands(path) {
+		if _, err := seeker.Seek(e.Offset, io.SeekStart); err != nil {
+			return nil, errors.Wrapf(err, "failed to seek to offset %d", e.Offset)
+		}
+		if _, err := io.CopyN(&stream, seeker, e.Len); err != nil {
+			return nil, errors.Wrapf(err, "failed to read command at offset %d", e.Offset)
+		}
+	}
+
+	diff := newDiff(p)
+	if err := diff.processStream(context.Background(), &stream); err != nil {
+		return nil, errors.Wrap(err, "failed to process indexed commands")
+	}
+	return diff, nil
+}
+
+// LookupFile is like LookupAt, for a stream file and its sidecar index, see IndexFile
+func (p *Processor) LookupFile(fileName string, path string) (*Diff, error) {
+	idx, err := p.IndexFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open file")
+	}
+	defer f.Close()
+	return p.LookupAt(f, idx, path)
+}
+
+// isPathUnder returns whether p is path or one of its descendants, with "" being the root
+func isPathUnder(p string, path string) bool {
+	return path == "" || p == path || strings.HasPrefix(p, path+"/")
+}
+
+// LookupFile returns the changes of a single path of a stream file, see Processor.LookupFile
+func LookupFile(fileName string, path string) (*Diff, error) {
+	return NewProcessor().LookupFile(fileName, path)
+}
@@ -0,0 +1,66 @@
+package pkg_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/cmaster11/btrfs-diff/pkg"
+	"github.com/stretchr/testify/require"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStreamIndex(t *testing.T) {
+	stream := buildStream(t, newTestStream().
+		MkFile("o257-12-0", 257).
+		Write("o257-12-0", 0, []byte("hello")).
+		MkFile("other", 258).
+		Write("other", 0, []byte("world")).
+		Rename("o257-12-0", "dir/file").
+		Write("dir/file", 5, []byte("!")).
+		Unlink("gone").
+		End())
+	fileName := filepath.Join(t.TempDir(), "stream.snap")
+	require.NoError(t, os.WriteFile(fileName, stream, 0644))
+
+	full, err := pkg.ProcessBTRFSStream(bytes.NewReader(stream))
+	require.NoError(t, err)
+	expected, err := json.Marshal(full.FlatMap(nil)["/dir/file"])
+	require.NoError(t, err)
+
+	diff, err := pkg.LookupFile(fileName, "/dir/file")
+	require.NoError(t, err)
+	m := diff.FlatMap(nil)
+	actual, err := json.Marshal(m["/dir/file"])
+	require.NoError(t, err)
+	require.JSONEq(t, string(expected), string(actual))
+	require.Nil(t, m["/other"])
+	require.Nil(t, m["/gone"])
+
+	// The sidecar index is written once, then reused
+	_, err = os.Stat(fileName + ".idx")
+	require.NoError(t, err)
+	diff, err = pkg.LookupFile(fileName, "/dir/file")
+	require.NoError(t, err)
+	require.NotNil(t, diff.FlatMap(nil)["/dir/file"])
+
+	// A stream rewritten with the same size is indexed again
+	rewritten := bytes.Replace(stream, []byte("other"), []byte("otter"), -1)
+	require.Len(t, rewritten, len(stream))
+	require.NoError(t, os.WriteFile(fileName, rewritten, 0644))
+	stat, err := os.Stat(fileName)
+	require.NoError(t, err)
+	require.NoError(t, os.Chtimes(fileName, stat.ModTime(), stat.ModTime().Add(time.Second)))
+	diff, err = pkg.LookupFile(fileName, "/otter")
+	require.NoError(t, err)
+	require.NotNil(t, diff.FlatMap(nil)["/otter"])
+
+	// Inputs which cannot seek are indexed in memory
+	diff, err = (&pkg.Processor{}).LookupAt(io.MultiReader(bytes.NewReader(stream)), nil, "/other")
+	require.NoError(t, err)
+	m = diff.FlatMap(nil)
+	require.NotNil(t, m["/other"])
+	require.Nil(t, m["/dir/file"])
+}
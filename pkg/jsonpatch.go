@@ -0,0 +1,86 @@
+package pkg
+
+import (
+	"encoding/json"
+	"github.com/pkg/errors"
+	"io"
+	"strings"
+)
+
+type JSONPatchOp = string
+
+const (
+	JSONPatchOpAdd     JSONPatchOp = "add"
+	JSONPatchOpRemove  JSONPatchOp = "remove"
+	JSONPatchOpReplace JSONPatchOp = "replace"
+)
+
+// JSONPatchOperation is a single operation of a JSON Patch (RFC 6902) document
+type JSONPatchOperation struct {
+	Op    JSONPatchOp `json:"op"`
+	Path  string      `json:"path"`
+	Value *DiffNode   `json:"value,omitempty"`
+}
+
+var jsonPointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+// jsonPointer renders the path of the node as a JSON Pointer (RFC 6901), one reference token per
+// path segment. Segments cannot contain "/", but "~" has to be escaped as "~0".
+func jsonPointer(n *DiffNode) string {
+	if n.Parent == nil {
+		return ""
+	}
+	return jsonPointer(n.Parent) + "/" + jsonPointerEscaper.Replace(n.Path)
+}
+
+// JSONPatch returns the diff as JSON Patch operations, to be applied to a document where every
+// directory is an object keyed by the names of its children: deleted nodes are removed (children
+// first), then created nodes are added (parents first) and changed ones replaced.
+func (d *Diff) JSONPatch(ignore DiffNodeMatcher) ([]*JSONPatchOperation, error) {
+	s := d.GetDiffStruct(ignore)
+
+	// Nodes deleted and created again are in both lists, and have to be removed first
+	deleted := append([]*DiffNode{}, s.Deleted...)
+	if err := SortDiffNodes(deleted, DiffSortByRestore); err != nil {
+		return nil, err
+	}
+	nodes := append(append([]*DiffNode{}, s.Added...), s.Changed...)
+	if err := SortDiffNodes(nodes, DiffSortByRestore); err != nil {
+		return nil, err
+	}
+
+	ops := make([]*JSONPatchOperation, 0, len(deleted)+len(nodes))
+	for _, n := range deleted {
+		ops = append(ops, &JSONPatchOperation{Op: JSONPatchOpRemove, Path: jsonPointer(n)})
+	}
+	for _, n := range nodes {
+		op := JSONPatchOpReplace
+		if n.State == opCreate {
+			op = JSONPatchOpAdd
+		}
+		ops = append(ops, &JSONPatchOperation{Op: op, Path: jsonPointer(n), Value: n})
+	}
+	return ops, nil
+}
+
+// WriteJSONPatch writes the JSON Patch document of the diff, see JSONPatch
+func (d *Diff) WriteJSONPatch(w io.Writer, ignore DiffNodeMatcher, style JSONStyle) error {
+	ops, err := d.JSONPatch(ignore)
+	if err != nil {
+		return err
+	}
+
+	var out []byte
+	if style == JSONStylePretty {
+		out, err = json.MarshalIndent(ops, "", "  ")
+	} else {
+		out, err = json.Marshal(ops)
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal json patch")
+	}
+	if _, err := w.Write(out); err != nil {
+		return errors.Wrap(err, "failed to write json patch")
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package pkg_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/cmaster11/btrfs-diff/pkg"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestJSONPatch(t *testing.T) {
+	diff, err := pkg.ProcessBTRFSStream(bytes.NewReader(buildStream(t, newTestStream().
+		MkDir("new~dir", 1).
+		MkFile("new~dir/file", 2).
+		Chmod("changed", 0644).
+		Unlink("old/file").
+		Rmdir("old").
+		End())))
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, pkg.WriteDiff(&buf, diff, &pkg.ProcessFileWithOutputArgs{Format: pkg.OutputFormatJSONPatch}))
+	var ops []struct {
+		Op    string          `json:"op"`
+		Path  string          `json:"path"`
+		Value json.RawMessage `json:"value"`
+	}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &ops))
+
+	var got []string
+	for _, op := range ops {
+		got = append(got, op.Op+" "+op.Path)
+		require.Equal(t, op.Op == pkg.JSONPatchOpRemove, op.Value == nil)
+	}
+	require.Equal(t, []string{
+		"remove /old/file",
+		"remove /old",
+		"replace /changed",
+		"add /new~0dir",
+		"add /new~0dir/file",
+	}, got)
+	require.Contains(t, string(ops[2].Value), `"changes":["chmod:mode=644"]`)
+}
@@ -1,6 +1,7 @@
 package pkg
 
 import (
+	"io"
 	"log"
 	"os"
 )
@@ -8,30 +9,34 @@ import (
 var infoLogger = log.New(os.Stderr, "[INFO] ", log.Lmicroseconds)
 var debugLogger = log.New(os.Stderr, "[DEBUG] ", log.Lmicroseconds)
 
-var InfoMode bool = true
-var DebugMode bool = true
+// SetLogOutput redirects the info and debug logs (STDERR by default) of the processors using the
+// default loggers (see NewProcessor). It is safe to call at any time.
+func SetLogOutput(w io.Writer) {
+	infoLogger.SetOutput(w)
+	debugLogger.SetOutput(w)
+}
+
+// info print a message using the processor info logger, if any
+func (p *Processor) info(msg string, params ...interface{}) {
+	if p.InfoLogger != nil {
+		p.InfoLogger.Printf(msg, params...)
+	}
+}
 
-// debug print a message (to STDERR) only if debug mode is enabled
-func debug(msg string, params ...interface{}) {
-	if DebugMode {
-		debugLogger.Printf(msg, params...)
+// debug print a message using the processor debug logger, if any
+func (p *Processor) debug(msg string, params ...interface{}) {
+	if p.DebugLogger != nil {
+		p.DebugLogger.Printf(msg, params...)
 	}
 }
 
 // debugInd is like 'debug()' but can handle indentation as well
-func debugInd(ind int, msg string, params ...interface{}) {
-	if DebugMode {
+func (p *Processor) debugInd(ind int, msg string, params ...interface{}) {
+	if p.DebugLogger != nil {
 		indentation := ""
 		for i := 0; i < ind; i++ {
 			indentation += "    "
 		}
-		debugLogger.Printf(indentation+msg, params...)
-	}
-}
-
-// info print a message (to STDERR) only if info mode is enabled
-func info(msg string, params ...interface{}) {
-	if InfoMode {
-		infoLogger.Printf(msg, params...)
+		p.DebugLogger.Printf(indentation+msg, params...)
 	}
 }
@@ -0,0 +1,21 @@
+package pkg_test
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/cmaster11/btrfs-diff/pkg"
+	"github.com/stretchr/testify/require"
+	"os"
+	"testing"
+)
+
+func TestSetLogOutput(t *testing.T) {
+	var buf bytes.Buffer
+	pkg.SetLogOutput(&buf)
+	defer pkg.SetLogOutput(os.Stderr)
+
+	_, err := pkg.ProcessFile(fmt.Sprintf("%s/inc-001.snap", testDir))
+	require.NoError(t, err)
+	require.Contains(t, buf.String(), "[INFO] ")
+	require.Contains(t, buf.String(), "received snapshot at 001")
+}
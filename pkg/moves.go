@@ -0,0 +1,109 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"sort"
+)
+
+// DiffMoveEntry is a node which has only been renamed, see DiffJSONStruct.PairMoves
+type DiffMoveEntry struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	// Changes done to the node after (or before) moving it
+	Changes []string `json:"changes,omitempty"`
+}
+
+// renameSource returns the original node a renamed node comes from, skipping btrfs temporary nodes
+func (n *DiffNode) renameSource() *DiffNode {
+	for _, rel := range n.Relations {
+		if rel.Reason == DiffNodeReasonRenameSrc && !rel.Node.isBTRFSTemporaryNode() {
+			return rel.Node
+		}
+	}
+	return nil
+}
+
+// PairMoves replaces every added rename destination, and its deleted source, with a single entry
+// in Moved. Nodes created in the snapshot (and then renamed) are kept as added.
+func (s *DiffJSONStruct) PairMoves() {
+	moved := make(map[*DiffNode]bool)
+
+	var added []*DiffNode
+	for _, n := range s.Added {
+		src := n.renameSource()
+		if src == nil || n.CreatedInSnapshot {
+			added = append(added, n)
+			continue
+		}
+		// Intermediate paths of a chain of renames are redundant as well
+		for _, rel := range n.Relations {
+			if rel.Reason == DiffNodeReasonRenameSrc {
+				moved[rel.Node] = true
+			}
+		}
+		s.Moved = append(s.Moved, &DiffMoveEntry{src.DisplayPath(), n.DisplayPath(), n.Changes})
+	}
+	s.Added = added
+
+	var deleted []*DiffNode
+	for _, n := range s.Deleted {
+		if !moved[n] {
+			deleted = append(deleted, n)
+		}
+	}
+	s.Deleted = deleted
+}
+
+// isPureRename returns whether the node has only been moved from an existing path: timestamp changes
+// do not count, as a rename alone updates the ctime
+func (n *DiffNode) isPureRename() bool {
+	if n.State != opCreate || n.CreatedInSnapshot || n.renameSource() == nil {
+		return false
+	}
+	for _, kind := range n.ChangeKinds() {
+		if kind != DiffChangeKindUtime {
+			return false
+		}
+	}
+	return true
+}
+
+// PureRenames returns the reportable nodes which have only been renamed, with no other change, sorted
+// by their new path. Nodes both moved and modified are left out, unlike with PairMoves.
+func (d *Diff) PureRenames(ignore DiffNodeMatcher) []*DiffMoveEntry {
+	var renames []*DiffMoveEntry
+	d.traverseReportable(ignore, func(n *DiffNode) {
+		if n.isPureRename() {
+			renames = append(renames, &DiffMoveEntry{From: n.renameSource().DisplayPath(), To: n.DisplayPath()})
+		}
+	})
+	sort.Slice(renames, func(i, j int) bool {
+		return renames[i].To < renames[j].To
+	})
+	return renames
+}
+
+// WriteRenames writes the pure renames of PureRenames, one "from -> to" line each, or as json
+func (d *Diff) WriteRenames(w io.Writer, ignore DiffNodeMatcher, asJSON bool) error {
+	renames := d.PureRenames(ignore)
+	if asJSON {
+		if renames == nil {
+			renames = []*DiffMoveEntry{}
+		}
+		out, err := json.Marshal(renames)
+		if err != nil {
+			return errors.Wrap(err, "failed to marshal renames")
+		}
+		_, err = fmt.Fprintf(w, "%s\n", out)
+		return err
+	}
+	for _, r := range renames {
+		if _, err := fmt.Fprintf(w, "%s -> %s\n", r.From, r.To); err != nil {
+			return err
+		}
+	}
+	return nil
+}
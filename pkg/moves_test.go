@@ -0,0 +1,30 @@
+package pkg_test
+
+import (
+	"bytes"
+	"github.com/cmaster11/btrfs-diff/pkg"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestPairMoves(t *testing.T) {
+	diff, err := pkg.ProcessBTRFSStream(bytes.NewReader(buildStream(t, newTestStream().
+		Rename("a", "o257-10-0").
+		Rename("o257-10-0", "b").
+		Rename("b", "c").
+		Chmod("c", 0644).
+		MkFile("o258-10-0", 258).
+		Rename("o258-10-0", "new").
+		Unlink("gone").
+		End())))
+	require.NoError(t, err)
+
+	s := diff.GetDiffStruct(nil)
+	require.ElementsMatch(t, []string{"/c", "/new"}, getPaths(s.Added))
+	require.Nil(t, s.Moved)
+
+	s.PairMoves()
+	require.Equal(t, []*pkg.DiffMoveEntry{{From: "/a", To: "/c", Changes: []string{"chmod:mode=644"}}}, s.Moved)
+	require.Equal(t, []string{"/new"}, getPaths(s.Added))
+	require.Equal(t, []string{"/gone"}, getPaths(s.Deleted))
+}
@@ -0,0 +1,37 @@
+package pkg
+
+import (
+	"github.com/pkg/errors"
+	"io"
+)
+
+// OnlyBucket empties all the buckets but the given one, if any
+func (s *DiffJSONStruct) OnlyBucket(bucket DiffBucket) {
+	if bucket != DiffBucketAdded {
+		s.Added = nil
+	}
+	if bucket != DiffBucketChanged {
+		s.Changed = nil
+	}
+	if bucket != DiffBucketDeleted {
+		s.Deleted = nil
+	}
+}
+
+// WriteNames writes only the paths of the reportable nodes, sorted as in EachNode and each one
+// followed by sep (e.g. a newline, or a null byte for paths with special chars). If bucket is
+// defined, only the nodes of that bucket are written. Nothing is written if there are no nodes.
+func (d *Diff) WriteNames(w io.Writer, ignore DiffNodeMatcher, bucket DiffBucket, sep byte) error {
+	seen := make(map[string]bool)
+	return d.EachNode(ignore, func(b DiffBucket, n *DiffNode) error {
+		p := n.DisplayPath()
+		if (bucket != "" && b != bucket) || seen[p] {
+			return nil
+		}
+		seen[p] = true
+		if _, err := io.WriteString(w, p+string(sep)); err != nil {
+			return errors.Wrap(err, "failed to write name")
+		}
+		return nil
+	})
+}
@@ -0,0 +1,40 @@
+package pkg_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/cmaster11/btrfs-diff/pkg"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestWriteNames(t *testing.T) {
+	diff, err := pkg.ProcessBTRFSStream(bytes.NewReader(buildStream(t, newTestStream().
+		MkFile("b", 1).
+		Unlink("a\nb").
+		Unlink("c").
+		Rmdir("d").
+		MkDir("d", 2).
+		End())))
+	require.NoError(t, err)
+
+	write := func(args *pkg.ProcessFileWithOutputArgs) string {
+		args.Format = pkg.OutputFormatNames
+		var buf bytes.Buffer
+		require.NoError(t, pkg.WriteDiff(&buf, diff, args))
+		return buf.String()
+	}
+	require.Equal(t, "/b\n/d\n/a\nb\n/c\n", write(&pkg.ProcessFileWithOutputArgs{}))
+	require.Equal(t, "/a\nb\x00/c\x00/d\x00", write(&pkg.ProcessFileWithOutputArgs{Op: pkg.DiffBucketDeleted, Print0: true}))
+	require.Equal(t, "", write(&pkg.ProcessFileWithOutputArgs{Op: pkg.DiffBucketChanged}))
+
+	var buf bytes.Buffer
+	require.NoError(t, pkg.WriteDiff(&buf, diff, &pkg.ProcessFileWithOutputArgs{Format: pkg.OutputFormatJSON, Op: pkg.DiffBucketAdded}))
+	var parsed map[string]json.RawMessage
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &parsed))
+	require.NotEqual(t, "null", string(parsed["added"]))
+	require.Equal(t, "null", string(parsed["deleted"]))
+
+	require.ErrorContains(t, pkg.WriteDiff(&buf, diff, &pkg.ProcessFileWithOutputArgs{Op: "moved"}), "unsupported operation")
+	require.ErrorContains(t, pkg.WriteDiff(&buf, diff, &pkg.ProcessFileWithOutputArgs{Format: pkg.OutputFormatDOT, Op: pkg.DiffBucketAdded}), "not supported with the dot format")
+}
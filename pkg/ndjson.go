@@ -0,0 +1,97 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"io"
+)
+
+type DiffBucket = string
+
+const (
+	DiffBucketAdded   DiffBucket = "added"
+	DiffBucketChanged DiffBucket = "changed"
+	DiffBucketDeleted DiffBucket = "deleted"
+)
+
+// EachNode calls fn for every reportable node, in a deterministic order: added, changed and then deleted
+// nodes, each sorted by path. It stops at the first error returned by fn.
+func (d *Diff) EachNode(ignore DiffNodeMatcher, fn func(bucket DiffBucket, n *DiffNode) error) error {
+	s := d.GetDiffStruct(ignore)
+	if err := s.Sort(DiffSortByPath); err != nil {
+		return err
+	}
+	for _, b := range []struct {
+		name  DiffBucket
+		nodes []*DiffNode
+	}{
+		{DiffBucketAdded, s.Added},
+		{DiffBucketChanged, s.Changed},
+		{DiffBucketDeleted, s.Deleted},
+	} {
+		for _, n := range b.nodes {
+			if err := fn(b.name, n); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+type DiffNDJSONLine struct {
+	Bucket DiffBucket `json:"bucket"`
+	Node   *DiffNode  `json:"node"`
+}
+
+// NDJSONEncoder writes the nodes of a diff as newline-delimited JSON, one DiffNDJSONLine per node, so
+// that clients can consume them while they are being written
+type NDJSONEncoder struct {
+	w io.Writer
+}
+
+func NewNDJSONEncoder(w io.Writer) *NDJSONEncoder {
+	return &NDJSONEncoder{w}
+}
+
+// Encode writes all the reportable nodes in the order of EachNode, flushing the writer after every line
+// if it supports it (e.g. a bufio.Writer or an http.Flusher). It stops as soon as the context is done.
+func (e *NDJSONEncoder) Encode(ctx context.Context, d *Diff, ignore DiffNodeMatcher) error {
+	return e.EncodeBucket(ctx, d, ignore, "")
+}
+
+// EncodeBucket is like Encode, but only writes the nodes of the given bucket, if defined
+func (e *NDJSONEncoder) EncodeBucket(ctx context.Context, d *Diff, ignore DiffNodeMatcher, only DiffBucket) error {
+	return d.EachNode(ignore, func(bucket DiffBucket, n *DiffNode) error {
+		if only != "" && bucket != only {
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return errors.Wrap(err, "encoding interrupted")
+		}
+
+		line, err := json.Marshal(&DiffNDJSONLine{bucket, n})
+		if err != nil {
+			return errors.Wrapf(err, "failed to marshal node %s", n.GetChainPath())
+		}
+		if _, err := e.w.Write(append(line, '\n')); err != nil {
+			return errors.Wrap(err, "failed to write line")
+		}
+		return e.flush()
+	})
+}
+
+func (e *NDJSONEncoder) flush() error {
+	return flushWriter(e.w)
+}
+
+// flushWriter flushes the writer, if it supports it (e.g. a bufio.Writer or an http.Flusher)
+func flushWriter(w io.Writer) error {
+	switch f := w.(type) {
+	case interface{ Flush() error }:
+		return f.Flush()
+	case interface{ Flush() }:
+		f.Flush()
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package pkg_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/cmaster11/btrfs-diff/pkg"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+func TestNDJSONEncoder(t *testing.T) {
+	diff, err := pkg.ProcessBTRFSStream(bytes.NewReader(buildStream(t, newTestStream().
+		MkFile("b", 1).
+		MkFile("a", 2).
+		Chmod("c", 0644).
+		Unlink("d").
+		End())))
+	require.NoError(t, err)
+
+	var out flushCounter
+	require.NoError(t, pkg.NewNDJSONEncoder(&out).Encode(context.Background(), diff, nil))
+	require.Equal(t, 4, out.flushes)
+
+	var got []string
+	for _, line := range strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n") {
+		var parsed struct {
+			Bucket string `json:"bucket"`
+			Node   struct {
+				Path string `json:"path"`
+			} `json:"node"`
+		}
+		require.NoError(t, json.Unmarshal([]byte(line), &parsed))
+		got = append(got, parsed.Bucket+" "+parsed.Node.Path)
+	}
+	require.Equal(t, []string{"added /a", "added /b", "changed /c", "deleted /d"}, got)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	out.Reset()
+	err = pkg.NewNDJSONEncoder(&out).Encode(ctx, diff, nil)
+	require.ErrorIs(t, err, context.Canceled)
+	require.Empty(t, out.String())
+}
@@ -0,0 +1,38 @@
+package pkg
+
+import (
+	"bytes"
+)
+
+const (
+	modeTypeMask = 0170000
+	modeCharDev  = 0020000
+)
+
+// Overlayfs marks opaque directories with this xattr, in the trusted namespace or (with the
+// userxattr mount option) in the user one
+var overlayOpaqueXattrs = []string{"trusted.overlay.opaque", "user.overlay.opaque"}
+
+// isOverlayWhiteout returns whether the params of a MKNOD command create an overlayfs whiteout, a 0:0
+// character device
+func isOverlayWhiteout(mode uint64, rdev uint64) bool {
+	return mode&modeTypeMask == modeCharDev && rdev == 0
+}
+
+func isOverlayOpaque(name string, data []byte) bool {
+	for _, xattr := range overlayOpaqueXattrs {
+		if name == xattr {
+			return bytes.Equal(bytes.TrimRight(data, "\x00"), []byte("y"))
+		}
+	}
+	return false
+}
+
+// markWhiteout turns the node into the deletion of the node with the same path in the lower layers
+func (n *DiffNode) markWhiteout() {
+	n.Whiteout = true
+	n.NodeType = DiffNodeTypeUnknown
+	n.State = opDelete
+	n.DeleteCause = DiffDeleteCauseWhiteout
+	n.CreatedInSnapshot = false
+}
@@ -0,0 +1,40 @@
+package pkg_test
+
+import (
+	"bytes"
+	"github.com/cmaster11/btrfs-diff/pkg"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestOverlay(t *testing.T) {
+	stream := buildStream(t, newTestStream().
+		MkNod("gone", 1, 0020000, 0).
+		MkNod("o258-7-0", 2, 0020000, 0).
+		Rename("o258-7-0", "moved-gone").
+		MkNod("null", 3, 0020666, 0x103).
+		MkDir("dir", 4).
+		SetXattr("dir", "trusted.overlay.opaque", []byte("y")).
+		SetXattr("other", "user.comment", []byte("y")).
+		End())
+
+	diff, err := (&pkg.Processor{Overlay: true}).Process(bytes.NewReader(stream))
+	require.NoError(t, err)
+	s := diff.GetDiffStruct(nil)
+	require.ElementsMatch(t, []string{"/gone", "/moved-gone"}, getPaths(s.Deleted))
+	require.ElementsMatch(t, []string{"/null", "/dir"}, getPaths(s.Added))
+	require.ElementsMatch(t, []string{"/other"}, getPaths(s.Changed))
+
+	m := diff.FlatMap(nil)
+	require.True(t, m["/gone"].Whiteout)
+	require.Equal(t, pkg.DiffDeleteCauseWhiteout, m["/moved-gone"].DeleteCause)
+	require.True(t, m["/dir"].Opaque)
+	require.Equal(t, []string{"opaque:name=trusted.overlay.opaque"}, m["/dir"].Changes)
+	require.False(t, m["/other"].Opaque)
+
+	// Off by default
+	diff, err = (&pkg.Processor{}).Process(bytes.NewReader(stream))
+	require.NoError(t, err)
+	require.Empty(t, diff.GetDiffStruct(nil).Deleted)
+	require.False(t, diff.FlatMap(nil)["/dir"].Opaque)
+}
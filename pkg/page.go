@@ -0,0 +1,30 @@
+package pkg
+
+// Paginate keeps only the window [offset, offset+count) of the added, changed and deleted nodes,
+// taken in this order, and records the amount of nodes before the pagination in Total.
+// A count of 0 means no limit. Buckets should be sorted first, to get stable pages.
+func (s *DiffJSONStruct) Paginate(offset int, count int) {
+	total := len(s.Added) + len(s.Changed) + len(s.Deleted)
+	s.Total = &total
+
+	start := offset
+	end := total
+	if count > 0 && start+count < end {
+		end = start + count
+	}
+
+	idx := 0
+	window := func(nodes []*DiffNode) []*DiffNode {
+		var res []*DiffNode
+		for _, n := range nodes {
+			if idx >= start && idx < end {
+				res = append(res, n)
+			}
+			idx++
+		}
+		return res
+	}
+	s.Added = window(s.Added)
+	s.Changed = window(s.Changed)
+	s.Deleted = window(s.Deleted)
+}
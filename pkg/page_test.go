@@ -0,0 +1,42 @@
+package pkg_test
+
+import (
+	"bytes"
+	"github.com/cmaster11/btrfs-diff/pkg"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestPaginate(t *testing.T) {
+	stream, err := newTestStream().
+		MkFile("d", 1).
+		MkFile("c", 2).
+		Chmod("b", 0644).
+		Unlink("a").
+		End().
+		Bytes()
+	require.NoError(t, err)
+
+	diff, err := pkg.ProcessBTRFSStream(bytes.NewReader(stream))
+	require.NoError(t, err)
+
+	getPage := func(offset int, count int) []string {
+		s := diff.GetDiffStruct(nil)
+		require.NoError(t, s.Sort(pkg.DiffSortByPath))
+		s.Paginate(offset, count)
+		require.EqualValues(t, 4, *s.Total)
+
+		var paths []string
+		for _, nodes := range [][]*pkg.DiffNode{s.Added, s.Changed, s.Deleted} {
+			for _, n := range nodes {
+				paths = append(paths, n.GetChainPath())
+			}
+		}
+		return paths
+	}
+
+	require.Equal(t, []string{"/c", "/d"}, getPage(0, 2))
+	require.Equal(t, []string{"/b", "/a"}, getPage(2, 2))
+	require.Equal(t, []string{"/d", "/b", "/a"}, getPage(1, 0))
+	require.Empty(t, getPage(10, 2))
+}
@@ -0,0 +1,30 @@
+package pkg
+
+import (
+	"github.com/pkg/errors"
+	"strings"
+)
+
+// normalizeUUID allows comparing uuids as shown by the btrfs tools (with dashes) and as found in the streams
+func normalizeUUID(uuid string) string {
+	return strings.ToLower(strings.ReplaceAll(uuid, "-", ""))
+}
+
+func (m *DiffMeta) validateParent(expectedUUID string) error {
+	if m.kind() == DiffKindFull {
+		return errors.Errorf("stream %s is a full send without a parent, expected parent uuid %s", m.Path, expectedUUID)
+	}
+	if normalizeUUID(m.CloneUUID) != normalizeUUID(expectedUUID) {
+		return errors.Errorf("stream %s has parent uuid %s, expected %s", m.Path, m.CloneUUID, expectedUUID)
+	}
+	return nil
+}
+
+// ValidateParent checks that the diff has been sent from the expected parent snapshot, which has to be
+// the base for applying it. For a chain of streams, the parent of the first one is checked.
+func (d *Diff) ValidateParent(expectedUUID string) error {
+	if d.firstMeta == nil {
+		return errors.New("no stream has been processed")
+	}
+	return d.firstMeta.validateParent(expectedUUID)
+}
@@ -0,0 +1,58 @@
+package pkg_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/cmaster11/btrfs-diff/internal/streamtest"
+	"github.com/cmaster11/btrfs-diff/pkg"
+	"github.com/stretchr/testify/require"
+	"os"
+	"testing"
+)
+
+func TestDiffKind(t *testing.T) {
+	diff, err := pkg.ProcessFile(fmt.Sprintf("%s/inc-001.snap", testDir))
+	require.NoError(t, err)
+	require.Equal(t, pkg.DiffKindIncremental, diff.Kind)
+	jsonBytes, err := json.Marshal(diff.GetDiffStruct(nil))
+	require.NoError(t, err)
+	require.Contains(t, string(jsonBytes), `"kind":"incremental"`)
+
+	diff, err = pkg.ProcessBTRFSStream(bytes.NewReader(buildStream(t, streamtest.NewBuilder().
+		Subvol("000", "db9ab1ea15e70a448ac6a0d61daaf4e6", 6).
+		End())))
+	require.NoError(t, err)
+	require.Equal(t, pkg.DiffKindFull, diff.Kind)
+
+	// A chain is as full as its first stream
+	full := buildStream(t, streamtest.NewBuilder().
+		Subvol("000", "db9ab1ea15e70a448ac6a0d61daaf4e6", 6).
+		End())
+	inc, err := os.ReadFile(fmt.Sprintf("%s/inc-001.snap", testDir))
+	require.NoError(t, err)
+	diff, err = pkg.ProcessStreams(bytes.NewReader(full), bytes.NewReader(inc))
+	require.NoError(t, err)
+	require.Equal(t, pkg.DiffKindFull, diff.Kind)
+}
+
+func TestValidateParent(t *testing.T) {
+	diff, err := pkg.ProcessFiles(fmt.Sprintf("%s/inc-002.snap", testDir), fmt.Sprintf("%s/inc-003.snap", testDir))
+	require.NoError(t, err)
+	require.NoError(t, diff.ValidateParent("b4233aaf045b6a4b89a2c08c8c1b4743"))
+	require.NoError(t, diff.ValidateParent("B4233AAF-045B-6A4B-89A2-C08C8C1B4743"))
+	require.ErrorContains(t, diff.ValidateParent("8ceaf94ac851d346841abc2b82323625"), "stream 002 has parent uuid b4233aaf045b6a4b89a2c08c8c1b4743, expected")
+
+	diff, err = pkg.ProcessBTRFSStream(bytes.NewReader(buildStream(t, streamtest.NewBuilder().
+		Subvol("000", "db9ab1ea15e70a448ac6a0d61daaf4e6", 6).
+		End())))
+	require.NoError(t, err)
+	require.ErrorContains(t, diff.ValidateParent("b4233aaf045b6a4b89a2c08c8c1b4743"), "full send without a parent")
+
+	err = pkg.ProcessFileAndOutput(&pkg.ProcessFileWithOutputArgs{
+		ArgFiles:     []string{fmt.Sprintf("%s/inc-002.snap", testDir)},
+		Format:       pkg.OutputFormatDOT,
+		ExpectParent: "db9ab1ea15e70a448ac6a0d61daaf4e6",
+	})
+	require.ErrorContains(t, err, "expected db9ab1ea15e70a448ac6a0d61daaf4e6")
+}
@@ -0,0 +1,220 @@
+package pkg
+
+import (
+	"bufio"
+	"context"
+	"github.com/pkg/errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+// Processor parses btrfs send streams into diffs. It only holds configuration, so the same
+// Processor can be reused (even concurrently) for any number of streams, as long as its fields
+// are not changed while processing.
+type Processor struct {
+	// InfoLogger and DebugLogger receive the processing logs, nil disables them
+	InfoLogger  *log.Logger
+	DebugLogger *log.Logger
+
+	// CaptureTimestamps enables the processing of UTIMES commands, which are otherwise ignored
+	// because they touch nearly every node in the stream
+	CaptureTimestamps bool
+
+	// IgnoreTimestamps always ignores UTIMES commands, even when capturing timestamps, so that nodes
+	// whose only change is their timestamps are left out of the diff
+	IgnoreTimestamps bool
+
+	// MaxCommandSize rejects any command bigger than this amount of bytes, 0 means no limit
+	MaxCommandSize uint32
+
+	// SkipUnknownTypes skips commands with types above BTRFS_SEND_C_MAX (e.g. vendor-specific ones)
+	// instead of failing
+	SkipUnknownTypes bool
+
+	// Strict fails on commands whose params do not consume all of their data, which means that the
+	// stream has attributes the parser does not know about (or reads in the wrong order)
+	Strict bool
+
+	// MergeConflictingNodes merges a node added on top of an existing live one (e.g. two creates of
+	// the same path in a malformed stream) with a NODE_CONFLICT warning, instead of failing
+	MergeConflictingNodes bool
+
+	// Overlay interprets the stream as an overlayfs upper layer: whiteouts (0:0 character devices)
+	// become deletions, and directories marked as opaque (by the overlay.opaque xattr) replacements
+	Overlay bool
+
+	// Tripwire, if defined, stops the processing with a TripwireError as soon as a node whose path
+	// matches it is deleted
+	Tripwire *regexp.Regexp
+
+	// StopAfter, if positive, stops the processing right after the Nth command (counting from 1, across
+	// all the streams applied to the same diff), leaving the partial tree, e.g. to bisect which command
+	// of a stream breaks it. See Diff.Stopped.
+	StopAfter int
+
+	// OnIgnoredCommand, if defined, is called for every command ignored by the diff (e.g. UTIMES when
+	// not capturing timestamps), with the offset of the command in the stream
+	OnIgnoredCommand func(cmdType uint16, name string, offset int64)
+
+	// OnProgress, if defined, is called every few thousand commands of each stream, and once more at its
+	// END command. When reading a regular file, the progress carries its size as well.
+	OnProgress func(progress Progress)
+
+	// PrefixSubvolume prefixes the paths of the nodes in the outputs with the path of their subvolume
+	// (e.g. /home/user/file for the home subvolume), so that the diffs of multiple subvolumes (e.g. from
+	// ProcessConcatenated) can be combined without collisions. Ignore patterns and the like still match
+	// the paths within the subvolume.
+	PrefixSubvolume bool
+}
+
+// NewProcessor returns a processor with the default settings, logging to the package-level loggers
+// (see SetLogOutput). Set InfoLogger and DebugLogger to nil to silence it.
+func NewProcessor() *Processor {
+	return &Processor{
+		InfoLogger:  infoLogger,
+		DebugLogger: debugLogger,
+	}
+}
+
+// Process parses a single stream
+func (p *Processor) Process(r io.Reader) (*Diff, error) {
+	return p.ProcessContext(context.Background(), r)
+}
+
+// ProcessContext is like Process, but stops as soon as the context is done. If the reader is an io.Closer
+// (e.g. a pipe), it is closed when the context is done during the processing, to interrupt a blocked read.
+func (p *Processor) ProcessContext(ctx context.Context, r io.Reader) (*Diff, error) {
+	diff := newDiff(p)
+	if err := diff.processStream(ctx, r); err != nil {
+		return nil, err
+	}
+	return diff, nil
+}
+
+// ProcessStreams applies all the streams in order on the same tree, see ProcessFiles
+func (p *Processor) ProcessStreams(streams ...io.Reader) (*Diff, error) {
+	diff := newDiff(p)
+	for idx, stream := range streams {
+		if diff.Stopped {
+			break
+		}
+		if err := diff.processStream(context.Background(), stream); err != nil {
+			return nil, errors.Wrapf(err, "failed to process stream %d", idx)
+		}
+	}
+	return diff, nil
+}
+
+func (p *Processor) ProcessFile(fileName string) (*Diff, error) {
+	diff := newDiff(p)
+	if err := diff.processFile(context.Background(), fileName); err != nil {
+		return nil, err
+	}
+	return diff, nil
+}
+
+// ProcessFiles applies all the stream files in order on the same tree, producing the cumulative
+// diff of an incremental chain (e.g. base + inc1 + inc2)
+func (p *Processor) ProcessFiles(fileNames ...string) (*Diff, error) {
+	return p.ProcessFilesContext(context.Background(), fileNames...)
+}
+
+// ProcessFilesContext is like ProcessFiles, but stops as soon as the context is done
+func (p *Processor) ProcessFilesContext(ctx context.Context, fileNames ...string) (*Diff, error) {
+	diff := newDiff(p)
+	for _, fileName := range fileNames {
+		if diff.Stopped {
+			break
+		}
+		if err := diff.processFile(ctx, fileName); err != nil {
+			return nil, errors.Wrapf(err, "failed to process file %s", fileName)
+		}
+	}
+	return diff, nil
+}
+
+// ProcessConcatenated parses a stream made of independent streams one after the other, each with its
+// own header (e.g. as written by some backup tools), returning one diff for each of them.
+// Multiple subvolumes sent at once share one header instead, and are parsed as a single stream.
+func (p *Processor) ProcessConcatenated(r io.Reader) ([]*Diff, error) {
+	return p.ProcessConcatenatedContext(context.Background(), r)
+}
+
+// ProcessConcatenatedContext is like ProcessConcatenated, but stops as soon as the context is done (see
+// ProcessContext)
+func (p *Processor) ProcessConcatenatedContext(ctx context.Context, r io.Reader) ([]*Diff, error) {
+	reader, stop := newContextReader(ctx, r)
+	defer stop()
+	counter := &countingReader{r: reader}
+	input := bufio.NewReader(counter)
+	size := streamSizeHint(r)
+
+	var diffs []*Diff
+	for {
+		diff := newDiff(p)
+		if err := diff.processInput(ctx, input, counter, size); err != nil {
+			return nil, errors.Wrapf(err, "failed to process stream %d", len(diffs))
+		}
+		diffs = append(diffs, diff)
+		if diff.Stopped {
+			return diffs, nil
+		}
+
+		// Any data after the END command has to be a new stream
+		if _, err := input.Peek(1); err == io.EOF {
+			return diffs, nil
+		} else if err != nil {
+			return nil, errors.Wrap(err, "failed to read next stream")
+		}
+	}
+}
+
+func ProcessBTRFSStream(stream io.Reader) (*Diff, error) {
+	return NewProcessor().Process(stream)
+}
+
+func ProcessBTRFSStreamContext(ctx context.Context, stream io.Reader) (*Diff, error) {
+	return NewProcessor().ProcessContext(ctx, stream)
+}
+
+func ProcessConcatenated(stream io.Reader) ([]*Diff, error) {
+	return NewProcessor().ProcessConcatenated(stream)
+}
+
+func ProcessStreams(streams ...io.Reader) (*Diff, error) {
+	return NewProcessor().ProcessStreams(streams...)
+}
+
+func ProcessFile(fileName string) (*Diff, error) {
+	return NewProcessor().ProcessFile(fileName)
+}
+
+func ProcessFiles(fileNames ...string) (*Diff, error) {
+	return NewProcessor().ProcessFiles(fileNames...)
+}
+
+func ProcessFilesContext(ctx context.Context, fileNames ...string) (*Diff, error) {
+	return NewProcessor().ProcessFilesContext(ctx, fileNames...)
+}
+
+func (d *Diff) processFile(ctx context.Context, fileName string) error {
+	fileName, err := filepath.Abs(fileName)
+	if err != nil {
+		return errors.Wrap(err, "bad filename")
+	}
+
+	f, err := os.Open(fileName)
+	if err != nil {
+		return errors.Wrap(err, "failed to open file")
+	}
+	defer f.Close()
+
+	if err := d.processStream(ctx, f); err != nil {
+		return errors.Wrap(err, "failed to process btrfs stream file")
+	}
+
+	return nil
+}
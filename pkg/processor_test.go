@@ -0,0 +1,339 @@
+package pkg_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"github.com/cmaster11/btrfs-diff/internal/streamtest"
+	"github.com/cmaster11/btrfs-diff/pkg"
+	"github.com/stretchr/testify/require"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestDiffChain(t *testing.T) {
+	var files []string
+	for idx := 1; idx <= 3; idx++ {
+		files = append(files, fmt.Sprintf("%s/inc-%03d.snap", testDir, idx))
+	}
+
+	diff, err := pkg.ProcessFiles(files...)
+	require.NoError(t, err)
+	require.Equal(t, "003", diff.Meta.Path)
+	require.EqualValues(t, 1, diff.StreamVersion)
+
+	diffStr := diff.GetDiffStruct(nil)
+
+	var added []string
+	for _, node := range diffStr.Added {
+		added = append(added, node.GetChainPath())
+	}
+	require.ElementsMatch(t, []string{"/bar", "/bar/foo_file"}, added)
+	require.Len(t, diffStr.Deleted, 1)
+	require.Equal(t, "/foo_file", diffStr.Deleted[0].GetChainPath())
+
+	// Skipping a stream breaks the chain
+	_, err = pkg.ProcessFiles(files[0], files[2])
+	require.ErrorContains(t, err, "broken stream chain")
+}
+
+func TestStreamVersion(t *testing.T) {
+	v1 := buildStream(t, newTestStream().MkFile("file", 257).End())
+	v2 := buildStream(t, streamtest.NewBuilderVersion(2).
+		Snapshot("003", "4379e89e4c343e468229796bca6cbb49", 14, "8ceaf94ac851d346841abc2b82323625", 12).
+		Unlink("file").
+		End())
+
+	for _, tc := range []struct {
+		name    string
+		streams [][]byte
+		version uint32
+	}{
+		{"v1", [][]byte{v1}, 1},
+		{"v2", [][]byte{v2}, 2},
+		// The version is the one of the last stream
+		{"v1 then v2", [][]byte{v1, v2}, 2},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var readers []io.Reader
+			for _, stream := range tc.streams {
+				readers = append(readers, bytes.NewReader(stream))
+			}
+			diff, err := pkg.ProcessStreams(readers...)
+			require.NoError(t, err)
+			require.Equal(t, tc.version, diff.StreamVersion)
+
+			jsonBytes, err := json.Marshal(diff.GetDiffStruct(nil))
+			require.NoError(t, err)
+			require.Contains(t, string(jsonBytes), fmt.Sprintf(`"stream_version":%d`, tc.version))
+		})
+	}
+
+	_, err := pkg.ProcessBTRFSStream(bytes.NewReader(buildStream(t, streamtest.NewBuilderVersion(3).End())))
+	require.ErrorContains(t, err, "unexpected stream version 3")
+}
+
+func TestSkipUnknownTypes(t *testing.T) {
+	b := newTestStream().
+		Command(pkg.BTRFS_SEND_C_MAX+10, streamtest.AttrString(pkg.BTRFS_SEND_A_PATH, "vendor"))
+	truncated, err := b.Bytes()
+	require.NoError(t, err)
+	truncated = append([]byte{}, truncated[:len(truncated)-3]...)
+
+	stream, err := b.MkFile("file", 1).End().Bytes()
+	require.NoError(t, err)
+
+	_, err = pkg.ProcessBTRFSStream(bytes.NewReader(stream))
+	require.ErrorContains(t, err, "invalid command type")
+
+	p := &pkg.Processor{SkipUnknownTypes: true}
+	diff, err := p.Process(bytes.NewReader(stream))
+	require.NoError(t, err)
+	require.Len(t, diff.GetDiffStruct(nil).Added, 1)
+
+	// Truncated in the middle of the unknown command
+	_, err = p.Process(bytes.NewReader(truncated))
+	require.ErrorContains(t, err, "short read while skipping unknown command type")
+
+	// Long runs of unknown commands
+	b = newTestStream()
+	for i := 0; i < 100000; i++ {
+		b.Command(pkg.BTRFS_SEND_C_MAX + 10)
+	}
+	diff, err = p.Process(bytes.NewReader(buildStream(t, b.MkFile("file", 1).End())))
+	require.NoError(t, err)
+	require.Len(t, diff.GetDiffStruct(nil).Added, 1)
+}
+
+func TestOnIgnoredCommand(t *testing.T) {
+	stream, err := os.ReadFile(fmt.Sprintf("%s/inc-010.snap", testDir))
+	require.NoError(t, err)
+
+	seen := make(map[string]int)
+	p := &pkg.Processor{
+		OnIgnoredCommand: func(cmdType uint16, name string, offset int64) {
+			seen[name]++
+			// The offset points to the beginning of the command
+			require.Equal(t, cmdType, binary.LittleEndian.Uint16(stream[offset+4:offset+6]))
+		},
+	}
+	_, err = p.Process(bytes.NewReader(stream))
+	require.NoError(t, err)
+	require.Equal(t, map[string]int{"BTRFS_SEND_C_UTIMES": 2}, seen)
+}
+
+func TestProcessContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := pkg.ProcessFilesContext(ctx, fmt.Sprintf("%s/inc-001.snap", testDir))
+	require.ErrorIs(t, err, context.Canceled)
+
+	// Cancelled while reading
+	stream, err := os.ReadFile(fmt.Sprintf("%s/inc-010.snap", testDir))
+	require.NoError(t, err)
+	ctx, cancel = context.WithCancel(context.Background())
+	p := &pkg.Processor{
+		OnIgnoredCommand: func(uint16, string, int64) {
+			cancel()
+		},
+	}
+	_, err = p.ProcessContext(ctx, bytes.NewReader(stream))
+	require.ErrorIs(t, err, context.Canceled)
+
+	_, err = p.ProcessContext(context.Background(), bytes.NewReader(stream))
+	require.NoError(t, err)
+}
+
+func TestProcessConcatenated(t *testing.T) {
+	f, err := os.Open(fmt.Sprintf("%s/concat-full.snap", testDir))
+	require.NoError(t, err)
+	defer f.Close()
+
+	diffs, err := pkg.ProcessConcatenated(f)
+	require.NoError(t, err)
+	require.Len(t, diffs, 2)
+
+	// Full streams of the first two snapshots
+	require.Equal(t, "000", diffs[0].Meta.Path)
+	require.Empty(t, diffs[0].GetDiffStruct(nil).Added)
+	require.Equal(t, "001", diffs[1].Meta.Path)
+	require.Equal(t, []string{"/foo_file"}, getPaths(diffs[1].GetDiffStruct(nil).Added))
+
+	// A single stream is still a valid concatenation
+	diffs, err = pkg.ProcessConcatenated(bytes.NewReader(buildStream(t, streamtest.NewBuilder().End())))
+	require.NoError(t, err)
+	require.Len(t, diffs, 1)
+
+	// Garbage after the END command
+	stream := append(buildStream(t, streamtest.NewBuilder().End()), "garbage"...)
+	_, err = pkg.ProcessConcatenated(bytes.NewReader(stream))
+	require.ErrorIs(t, err, pkg.ErrNotBTRFSStream)
+}
+
+func TestPrefixSubvolume(t *testing.T) {
+	f, err := os.Open(fmt.Sprintf("%s/concat-full.snap", testDir))
+	require.NoError(t, err)
+	defer f.Close()
+
+	diffs, err := (&pkg.Processor{PrefixSubvolume: true}).ProcessConcatenated(f)
+	require.NoError(t, err)
+	require.Len(t, diffs, 2)
+	added := diffs[1].GetDiffStruct(nil).Added
+	require.Equal(t, []string{"/foo_file"}, getPaths(added))
+	require.Equal(t, "/001/foo_file", added[0].DisplayPath())
+
+	diff, err := (&pkg.Processor{PrefixSubvolume: true}).Process(bytes.NewReader(buildStream(t, streamtest.NewBuilder().
+		Snapshot("home", "8ceaf94ac851d346841abc2b82323625", 12, "b4233aaf045b6a4b89a2c08c8c1b4743", 10).
+		Rename("user/a", "user/b").
+		End())))
+	require.NoError(t, err)
+	b := diff.FlatMap(nil)["/user/b"]
+	require.Equal(t, "/home/user/b", b.DisplayPath())
+	require.Equal(t, []string{"/home/user/a"}, b.RenameHistory())
+	require.Equal(t, "/home", b.Parent.Parent.DisplayPath())
+
+	// Ignore patterns match the paths within the subvolume
+	var buf bytes.Buffer
+	args := &pkg.ProcessFileWithOutputArgs{Format: pkg.OutputFormatNames, IgnorePaths: pkg.DiffIgnorePaths{regexp.MustCompile("^/user/a$")}}
+	require.NoError(t, pkg.WriteDiff(&buf, diff, args))
+	require.Equal(t, "/home/user/b\n", buf.String())
+}
+
+func TestProcessor(t *testing.T) {
+	var logs bytes.Buffer
+	p := &pkg.Processor{
+		InfoLogger: log.New(&logs, "", 0),
+	}
+
+	// The same processor can be reused across streams
+	for idx := 1; idx <= 2; idx++ {
+		diff, err := p.ProcessFile(fmt.Sprintf("%s/inc-%03d.snap", testDir, idx))
+		require.NoError(t, err)
+		require.Len(t, diff.GetDiffStruct(nil).Added, 1)
+	}
+	require.Contains(t, logs.String(), "received snapshot at 002")
+
+	p = &pkg.Processor{MaxCommandSize: 16}
+	_, err := p.ProcessFile(fmt.Sprintf("%s/inc-001.snap", testDir))
+	require.ErrorContains(t, err, "exceeds the maximum")
+}
+
+func BenchmarkProcessor(b *testing.B) {
+	data, err := os.ReadFile(fmt.Sprintf("%s/inc-024.snap", testDir))
+	require.NoError(b, err)
+
+	p := &pkg.Processor{}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := p.Process(bytes.NewReader(data)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkProcessorLargeStream processes a generated stream with many commands bigger than the
+// default read buffer, like the writes of real streams
+func BenchmarkProcessorLargeStream(b *testing.B) {
+	builder := newTestStream()
+	chunk := bytes.Repeat([]byte("x"), 48*1024)
+	for i := 0; i < 200; i++ {
+		path := fmt.Sprintf("dir%d/file%d", i%10, i)
+		builder.MkFile(path, uint64(i)).SetXattr(path, "user.test", []byte("value"))
+		for j := 0; j < 4; j++ {
+			builder.Write(path, uint64(j*len(chunk)), chunk)
+		}
+		builder.Chmod(path, 0644)
+	}
+	data, err := builder.End().Bytes()
+	require.NoError(b, err)
+
+	p := &pkg.Processor{}
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := p.Process(bytes.NewReader(data)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestStopAfter(t *testing.T) {
+	stream := buildStream(t, newTestStream().
+		MkFile("first", 257).
+		MkFile("second", 258).
+		Unlink("first").
+		End())
+
+	// Stopping after the 3rd command (the snapshot being the 1st) leaves "first" in place
+	diff, err := (&pkg.Processor{StopAfter: 3}).Process(bytes.NewReader(stream))
+	require.NoError(t, err)
+	require.True(t, diff.Stopped)
+	m := diff.FlatMap(nil)
+	require.NotNil(t, m["/first"])
+	require.NotNil(t, m["/second"])
+	require.Equal(t, 2, len(diff.GetDiffStruct(nil).Added))
+
+	// Later streams are not processed at all
+	diff, err = (&pkg.Processor{StopAfter: 2}).ProcessStreams(bytes.NewReader(stream), bytes.NewReader(stream))
+	require.NoError(t, err)
+	require.True(t, diff.Stopped)
+	require.Len(t, diff.FlatMap(nil), 1)
+
+	// Streams shorter than N are processed fully
+	diff, err = (&pkg.Processor{StopAfter: 100}).Process(bytes.NewReader(stream))
+	require.NoError(t, err)
+	require.False(t, diff.Stopped)
+	require.Equal(t, 1, len(diff.GetDiffStruct(nil).Added))
+}
+
+type closeTrackingReader struct {
+	io.Reader
+	closed bool
+}
+
+func (r *closeTrackingReader) Close() error {
+	r.closed = true
+	return nil
+}
+
+func TestProcessContextBlockedRead(t *testing.T) {
+	stream := buildStream(t, newTestStream().
+		MkFile("a", 1).
+		End())
+
+	// The writer sends only part of the stream, then stalls without closing the pipe
+	pr, pw := io.Pipe()
+	go func() { _, _ = pw.Write(stream[:len(stream)/2]) }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() {
+		_, err := (&pkg.Processor{}).ProcessContext(ctx, pr)
+		errs <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-errs:
+		require.ErrorIs(t, err, context.Canceled)
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "the blocked read has not been interrupted")
+	}
+
+	// Once processed, the reader is left open even if the context is done later
+	ctx, cancel = context.WithCancel(context.Background())
+	r := &closeTrackingReader{Reader: bytes.NewReader(stream)}
+	_, err := (&pkg.Processor{}).ProcessContext(ctx, r)
+	require.NoError(t, err)
+	cancel()
+	require.False(t, r.closed)
+}
@@ -0,0 +1,51 @@
+package pkg
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+// progressInterval is the amount of commands processed between two calls of Processor.OnProgress
+const progressInterval = 4096
+
+// Progress reports how far the processing of a stream is, see Processor.OnProgress
+type Progress struct {
+	// Bytes of the input read so far
+	Bytes int64
+	// Size of the whole input, 0 if unknown (e.g. when reading from a pipe)
+	TotalBytes int64
+	// Commands of the stream processed so far
+	Commands int
+	// Whether the stream has been processed up to its END command
+	Done bool
+}
+
+// Percent returns how much of the input has been read, only if its size is known
+func (p Progress) Percent() (float64, bool) {
+	if p.TotalBytes <= 0 {
+		return 0, false
+	}
+	return math.Min(100, float64(p.Bytes)*100/float64(p.TotalBytes)), true
+}
+
+func (p Progress) String() string {
+	if pct, ok := p.Percent(); ok {
+		return fmt.Sprintf("%.1f%% (%d/%d bytes, %d commands)", pct, p.Bytes, p.TotalBytes, p.Commands)
+	}
+	return fmt.Sprintf("%d bytes, %d commands", p.Bytes, p.Commands)
+}
+
+// streamSizeHint returns the size of the input if it is a regular file, or 0 otherwise
+func streamSizeHint(r io.Reader) int64 {
+	f, ok := r.(*os.File)
+	if !ok {
+		return 0
+	}
+	stat, err := f.Stat()
+	if err != nil || !stat.Mode().IsRegular() {
+		return 0
+	}
+	return stat.Size()
+}
@@ -0,0 +1,45 @@
+package pkg_test
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/cmaster11/btrfs-diff/pkg"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestProgress(t *testing.T) {
+	builder := newTestStream()
+	for i := 0; i < 5000; i++ {
+		builder.Chmod("file", 0644)
+	}
+	stream := buildStream(t, builder.End())
+	fileName := filepath.Join(t.TempDir(), "stream.snap")
+	require.NoError(t, os.WriteFile(fileName, stream, 0644))
+
+	var reports []pkg.Progress
+	p := &pkg.Processor{OnProgress: func(progress pkg.Progress) {
+		reports = append(reports, progress)
+	}}
+	_, err := p.ProcessFile(fileName)
+	require.NoError(t, err)
+	require.Len(t, reports, 2)
+	require.False(t, reports[0].Done)
+	require.Equal(t, 4096, reports[0].Commands)
+	require.Equal(t, int64(len(stream)), reports[0].TotalBytes)
+	require.Equal(t, pkg.Progress{Bytes: int64(len(stream)), TotalBytes: int64(len(stream)), Commands: 5002, Done: true}, reports[1])
+	pct, ok := reports[1].Percent()
+	require.True(t, ok)
+	require.Equal(t, 100.0, pct)
+
+	// The size of other inputs is unknown
+	reports = nil
+	_, err = p.Process(bytes.NewReader(stream))
+	require.NoError(t, err)
+	require.Len(t, reports, 2)
+	_, ok = reports[1].Percent()
+	require.False(t, ok)
+	require.Equal(t, fmt.Sprintf("%d bytes, 5002 commands", len(stream)), reports[1].String())
+}
@@ -0,0 +1,143 @@
+package pkg
+
+import (
+	"github.com/cmaster11/btrfs-diff/pkg/diffpb"
+	"github.com/pkg/errors"
+	"google.golang.org/protobuf/encoding/protodelim"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"io"
+)
+
+// The bindings of diff.proto are generated with protoc, protoc-gen-go (v1.31.0) and protoc-gen-go-grpc (v1.3.0)
+//go:generate protoc --proto_path=.. --go_out=.. --go_opt=module=github.com/cmaster11/btrfs-diff --go-grpc_out=.. --go-grpc_opt=module=github.com/cmaster11/btrfs-diff pkg/diff.proto
+
+// ToProto returns the diff as a Diff message of diff.proto
+func (s *DiffJSONStruct) ToProto() *diffpb.Diff {
+	msg := &diffpb.Diff{}
+	if s.Meta != nil {
+		msg.Meta = s.Meta.toProto()
+	}
+	for _, n := range s.Added {
+		msg.Added = append(msg.Added, n.toProto())
+	}
+	for _, n := range s.Changed {
+		msg.Changed = append(msg.Changed, n.toProto())
+	}
+	for _, n := range s.Deleted {
+		msg.Deleted = append(msg.Deleted, n.toProto())
+	}
+	for _, w := range s.Warnings {
+		msg.Warnings = append(msg.Warnings, &diffpb.Warning{Code: w.Code, Path: w.Path, Message: w.Message})
+	}
+	for _, mv := range s.Moved {
+		msg.Moved = append(msg.Moved, &diffpb.Move{From: mv.From, To: mv.To, Changes: mv.Changes})
+	}
+	if s.Total != nil {
+		total := int64(*s.Total)
+		msg.Total = &total
+	}
+	return msg
+}
+
+func (m *DiffMetaJSON) toProto() *diffpb.Meta {
+	msg := &diffpb.Meta{
+		StreamVersion: m.StreamVersion,
+		Kind:          m.Kind,
+		CommandCount:  int64(m.CommandCount),
+	}
+	if m.DiffMeta != nil {
+		msg.Path = m.Path
+		msg.Uuid = m.UUID
+		msg.Ctransid = m.CTransID
+		msg.CloneUuid = m.CloneUUID
+		msg.CloneCtransid = m.CloneCTransID
+		if m.OTime != nil {
+			msg.Otime = timestamppb.New(*m.OTime)
+		}
+	}
+	return msg
+}
+
+func (n *DiffNode) toProto() *diffpb.Node {
+	msg := &diffpb.Node{
+		NodeType:           n.NodeType,
+		Path:               n.DisplayPath(),
+		State:              int32(n.State),
+		Changes:            n.Changes,
+		DeleteCause:        n.DeleteCause,
+		RenameHistory:      n.RenameHistory(),
+		GainedExecutable:   n.GainedExecutable,
+		LostExecutable:     n.LostExecutable,
+		GainedSetuid:       n.GainedSetuid,
+		GainedSetgid:       n.GainedSetgid,
+		GainedSticky:       n.GainedSticky,
+		ChangedFraction:    n.ChangedFraction(),
+		Depth:              int32(n.Depth()),
+		Whiteout:           n.Whiteout,
+		Opaque:             n.Opaque,
+		FinalSize:          n.FinalSize(),
+		RenamedAncestor:    n.HasRenamedAncestor(),
+		Empty:              n.IsEmpty(),
+		CompressionTypes:   n.CompressionTypes(),
+		Id:                 n.ID(),
+		ChangedViaHardlink: n.ChangedViaHardlink,
+	}
+	for _, r := range n.Relations {
+		msg.Relations = append(msg.Relations, &diffpb.Relation{
+			Path:     r.Node.DisplayPath(),
+			Reason:   r.Reason,
+			NodeType: r.Node.NodeType,
+			State:    int32(r.Node.State),
+		})
+	}
+	if n.Times != nil {
+		msg.Times = &diffpb.Times{
+			Atime: timestamppb.New(n.Times.ATime),
+			Mtime: timestamppb.New(n.Times.MTime),
+			Ctime: timestamppb.New(n.Times.CTime),
+		}
+	}
+	if len(n.Extents) > 0 {
+		msg.Stats = &diffpb.Stats{
+			TotalBytesWritten: n.TotalBytesWritten(),
+			WrittenBytes:      n.WrittenBytes(),
+			ClonedBytes:       n.ClonedBytes(),
+		}
+	}
+	for _, x := range n.Xattrs {
+		msg.Xattrs = append(msg.Xattrs, &diffpb.XattrChange{Op: x.Op, Name: x.Name, Value: x.Value, Encoding: x.Encoding})
+	}
+	for _, e := range n.Extents {
+		extent := &diffpb.Extent{Kind: e.Kind, Offset: e.Offset, Len: e.Len, Compression: e.Compression}
+		if e.Kind == DiffExtentKindClone {
+			offset, aligned := e.CloneOffset, e.CloneAligned()
+			extent.CloneSourcePath = e.CloneSourcePath
+			extent.CloneOffset = &offset
+			extent.CloneAligned = &aligned
+		}
+		msg.Extents = append(msg.Extents, extent)
+	}
+	if tc := n.TypeChange(); tc != nil {
+		msg.TypeChanged = &diffpb.TypeChange{From: tc.From, To: tc.To}
+	}
+	if index, uuid := n.sourceStream(); index != nil {
+		i := int32(*index)
+		msg.SourceStreamIndex = &i
+		msg.SourceUuid = uuid
+	}
+	return msg
+}
+
+// ToProto returns the diff as a Diff message of diff.proto
+func (d *Diff) ToProto(ignore DiffNodeMatcher) *diffpb.Diff {
+	return d.GetDiffStruct(ignore).ToProto()
+}
+
+// writeProtoDelimited writes a message prefixed by its length as a varint, so that multiple messages
+// can be read from the same stream
+func writeProtoDelimited(w io.Writer, msg *diffpb.Diff) error {
+	if _, err := protodelim.MarshalTo(w, msg); err != nil {
+		return errors.Wrap(err, "failed to write proto message")
+	}
+	return nil
+}
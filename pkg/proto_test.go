@@ -0,0 +1,66 @@
+package pkg_test
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/cmaster11/btrfs-diff/pkg"
+	"github.com/cmaster11/btrfs-diff/pkg/diffpb"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protodelim"
+	"google.golang.org/protobuf/proto"
+	"testing"
+)
+
+func TestProto(t *testing.T) {
+	diff, err := pkg.ProcessBTRFSStream(bytes.NewReader(buildStream(t, newTestStream().
+		MkFile("o257-12-0", 257).
+		Rename("o257-12-0", "dir/new").
+		Write("dir/new", 0, make([]byte, 100)).
+		Truncate("changed", 400).
+		Write("changed", 0, make([]byte, 100)).
+		Rename("old", "moved").
+		Unlink("gone").
+		End())))
+	require.NoError(t, err)
+
+	var out bytes.Buffer
+	require.NoError(t, pkg.WriteDiff(&out, diff, &pkg.ProcessFileWithOutputArgs{Format: pkg.OutputFormatProto, SortBy: pkg.DiffSortByPath}))
+
+	// The message is prefixed by its length
+	msg := &diffpb.Diff{}
+	require.NoError(t, protodelim.UnmarshalFrom(bufio.NewReader(&out), msg))
+	require.Zero(t, out.Len())
+
+	require.Equal(t, "8ceaf94ac851d346841abc2b82323625", msg.Meta.GetUuid())
+	require.EqualValues(t, 12, msg.Meta.GetCtransid())
+	require.Equal(t, pkg.DiffKindIncremental, msg.Meta.GetKind())
+
+	s := diff.GetDiffStruct(nil)
+	require.NoError(t, s.Sort(pkg.DiffSortByPath))
+	for _, bucket := range []struct {
+		nodes    []*pkg.DiffNode
+		msgNodes []*diffpb.Node
+	}{{s.Added, msg.Added}, {s.Changed, msg.Changed}, {s.Deleted, msg.Deleted}} {
+		require.Len(t, bucket.msgNodes, len(bucket.nodes))
+		for i, n := range bucket.nodes {
+			require.Equal(t, n.DisplayPath(), bucket.msgNodes[i].GetPath())
+			require.Equal(t, n.NodeType, bucket.msgNodes[i].GetNodeType())
+			require.EqualValues(t, n.Depth(), bucket.msgNodes[i].GetDepth())
+		}
+	}
+
+	changed := msg.Changed[0]
+	require.Equal(t, "/changed", changed.GetPath())
+	require.EqualValues(t, 400, changed.GetFinalSize())
+	require.Equal(t, 0.25, changed.GetChangedFraction())
+	require.EqualValues(t, 100, changed.GetStats().GetTotalBytesWritten())
+
+	moved := msg.Added[1]
+	require.Equal(t, "/moved", moved.GetPath())
+	require.Equal(t, "/old", moved.Relations[0].GetPath())
+	require.Equal(t, pkg.DiffNodeReasonRenameSrc, moved.Relations[0].GetReason())
+
+	// The decoded message is the same as the one the diff is converted to
+	require.True(t, proto.Equal(s.ToProto(), msg), "decoded message differs")
+	require.Len(t, diff.ToProto(nil).Added, len(s.Added))
+}
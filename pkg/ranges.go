@@ -0,0 +1,77 @@
+package pkg
+
+import "sort"
+
+// byteRange is a half-open [Start, End) range of bytes in a file
+type byteRange struct {
+	Start uint64
+	End   uint64
+}
+
+func (r byteRange) Len() uint64 {
+	return r.End - r.Start
+}
+
+// byteRanges is a set of sorted, non-overlapping and non-adjacent ranges
+type byteRanges []byteRange
+
+// add inserts the range in the set, merging it with any overlapping or adjacent range. The set is
+// updated in place when possible, so its backing array must not be shared.
+func (rs byteRanges) add(offset uint64, length uint64) byteRanges {
+	if length == 0 {
+		return rs
+	}
+	r := byteRange{offset, offset + length}
+
+	// First range which ends at or after the start of the new one, so it may touch it
+	i := sort.Search(len(rs), func(i int) bool {
+		return rs[i].End >= r.Start
+	})
+	j := i
+	for ; j < len(rs) && rs[j].Start <= r.End; j++ {
+		if rs[j].Start < r.Start {
+			r.Start = rs[j].Start
+		}
+		if rs[j].End > r.End {
+			r.End = rs[j].End
+		}
+	}
+
+	if i == j {
+		// Nothing to merge, e.g. a sparse write past the end
+		rs = append(rs, byteRange{})
+		copy(rs[i+1:], rs[i:])
+		rs[i] = r
+		return rs
+	}
+	rs[i] = r
+	return append(rs[:i+1], rs[j:]...)
+}
+
+// remove cuts the range out of the set
+func (rs byteRanges) remove(offset uint64, length uint64) byteRanges {
+	end := offset + length
+	var res byteRanges
+	for _, r := range rs {
+		if r.End <= offset || r.Start >= end {
+			res = append(res, r)
+			continue
+		}
+		if r.Start < offset {
+			res = append(res, byteRange{r.Start, offset})
+		}
+		if r.End > end {
+			res = append(res, byteRange{end, r.End})
+		}
+	}
+	return res
+}
+
+// total returns the amount of distinct bytes in the set
+func (rs byteRanges) total() uint64 {
+	var t uint64
+	for _, r := range rs {
+		t += r.Len()
+	}
+	return t
+}
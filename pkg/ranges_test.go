@@ -0,0 +1,200 @@
+package pkg_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/cmaster11/btrfs-diff/internal/streamtest"
+	"github.com/cmaster11/btrfs-diff/pkg"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestWriteRanges(t *testing.T) {
+	stream, err := newTestStream().
+		// Out of order
+		Write("file", 10, make([]byte, 10)).
+		Chmod("file", 0644).
+		Write("file", 0, make([]byte, 10)).
+		// Overlapping
+		Write("file", 15, make([]byte, 10)).
+		Write("file", 0, make([]byte, 5)).
+		// Disjoint
+		Write("file", 100, make([]byte, 10)).
+		End().
+		Bytes()
+	require.NoError(t, err)
+
+	diff, err := pkg.ProcessBTRFSStream(bytes.NewReader(stream))
+	require.NoError(t, err)
+
+	diffStr := diff.GetDiffStruct(nil)
+	require.Len(t, diffStr.Changed, 1)
+	node := diffStr.Changed[0]
+	require.EqualValues(t, 35, node.TotalBytesWritten())
+	require.Equal(t, []string{
+		"write:offset=0:data_len=25",
+		"write:offset=100:data_len=10",
+		"chmod:mode=644",
+	}, node.Changes)
+
+	// Writes of a later stream are merged with the earlier ones
+	next := buildStream(t, streamtest.NewBuilder().
+		Snapshot("003", "4379e89e4c343e468229796bca6cbb49", 14, "8ceaf94ac851d346841abc2b82323625", 12).
+		Write("file", 25, make([]byte, 5)).
+		Write("file", 50, make([]byte, 5)).
+		End())
+	diff, err = pkg.ProcessStreams(bytes.NewReader(stream), bytes.NewReader(next))
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"write:offset=0:data_len=30",
+		"write:offset=50:data_len=5",
+		"write:offset=100:data_len=10",
+		"chmod:mode=644",
+	}, diff.FlatMap(nil)["/file"].Changes)
+}
+
+// BenchmarkSparseWrites processes a file written in many separate ranges, in reverse order
+func BenchmarkSparseWrites(b *testing.B) {
+	builder := newTestStream().
+		MkFile("file", 257)
+	for i := 20000; i > 0; i-- {
+		builder.Write("file", uint64(i*8), []byte("x"))
+	}
+	data, err := builder.End().Bytes()
+	require.NoError(b, err)
+
+	p := &pkg.Processor{}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := p.Process(bytes.NewReader(data)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestCloneOverlap(t *testing.T) {
+	stream, err := newTestStream().
+		Clone("file", 0, 100, "b4233aaf045b6a4b89a2c08c8c1b4743", 10, "src", 0).
+		// Overrides part of the cloned range
+		Write("file", 50, make([]byte, 10)).
+		// Overrides part of the written range
+		Clone("file", 55, 10, "b4233aaf045b6a4b89a2c08c8c1b4743", 10, "src", 200).
+		End().
+		Bytes()
+	require.NoError(t, err)
+
+	diff, err := pkg.ProcessBTRFSStream(bytes.NewReader(stream))
+	require.NoError(t, err)
+
+	diffStr := diff.GetDiffStruct(nil)
+	require.Len(t, diffStr.Changed, 1)
+	node := diffStr.Changed[0]
+	require.EqualValues(t, 5, node.WrittenBytes())
+	require.EqualValues(t, 95, node.ClonedBytes())
+	require.True(t, node.HasContentChanges())
+
+	jsonBytes, err := json.Marshal(node)
+	require.NoError(t, err)
+	require.Contains(t, string(jsonBytes), `"stats":{"total_bytes_written":10,"written_bytes":5,"cloned_bytes":95}`)
+	require.Contains(t, string(jsonBytes), `"extents":[`+
+		`{"kind":"clone","offset":0,"len":100,"clone_source_path":"src","clone_offset":0,"clone_len":100,"clone_aligned":true},`+
+		`{"kind":"write","offset":50,"len":10},`+
+		`{"kind":"clone","offset":55,"len":10,"clone_source_path":"src","clone_offset":200,"clone_len":10,"clone_aligned":false}]`)
+}
+
+func TestCloneOnly(t *testing.T) {
+	// E.g. a file replaced with `cp --reflink` of another file of the parent snapshot
+	diff, err := pkg.ProcessBTRFSStream(bytes.NewReader(buildStream(t, newTestStream().
+		Clone("file", 0, 4096, "b4233aaf045b6a4b89a2c08c8c1b4743", 10, "src", 0).
+		Clone("file", 4096, 4096, "b4233aaf045b6a4b89a2c08c8c1b4743", 10, "src", 4096).
+		Truncate("file", 8000).
+		End())))
+	require.NoError(t, err)
+
+	s := diff.GetDiffStruct(nil)
+	require.Equal(t, []string{"/file"}, getPaths(s.Changed))
+	node := s.Changed[0]
+	require.Equal(t, pkg.DiffNodeTypeFile, node.NodeType)
+	require.True(t, node.HasContentChanges())
+	require.EqualValues(t, 0, node.TotalBytesWritten())
+	require.EqualValues(t, 8000, node.ClonedBytes())
+	require.InDelta(t, 1, *node.ChangedFraction(), 0.0001)
+
+	// Not skipped for having no bytes written
+	args := &pkg.ProcessFileWithOutputArgs{MinChangePct: 90}
+	require.Contains(t, diff.FlatMap(args.IgnoreMatcher()), "/file")
+	require.Equal(t, &pkg.DiffStats{Changed: 1, BytesCloned: 8000, DeepestPath: "/file", LongestPath: "/file"}, diff.Stats(nil))
+
+	jsonBytes, err := json.Marshal(node)
+	require.NoError(t, err)
+	require.Contains(t, string(jsonBytes), `"stats":{"total_bytes_written":0,"written_bytes":0,"cloned_bytes":8000}`)
+
+	var buf bytes.Buffer
+	require.NoError(t, diff.WriteScript(&buf, nil))
+	require.Contains(t, buf.String(), "# contents not available: 8000 bytes cloned\n")
+}
+
+func TestTruncateExtents(t *testing.T) {
+	diff, err := pkg.ProcessBTRFSStream(bytes.NewReader(buildStream(t, newTestStream().
+		// Truncate then extend
+		Write("extended", 0, make([]byte, 10)).
+		Truncate("extended", 5).
+		Write("extended", 20, make([]byte, 10)).
+		// Write then truncate smaller
+		Write("shrunk", 0, make([]byte, 120)).
+		Truncate("shrunk", 100).
+		// The data dropped by a truncate is not restored by growing the file again
+		Write("regrown", 0, make([]byte, 120)).
+		Truncate("regrown", 10).
+		Truncate("regrown", 100).
+		Clone("cloned", 0, 4096, "b4233aaf045b6a4b89a2c08c8c1b4743", 10, "src", 0).
+		Write("cloned", 100, make([]byte, 10)).
+		Truncate("cloned", 50).
+		End())))
+	require.NoError(t, err)
+
+	m := diff.FlatMap(nil)
+	for p, expected := range map[string][3]uint64{
+		// Final size, written and cloned bytes
+		"/extended": {30, 15, 0},
+		"/shrunk":   {100, 100, 0},
+		"/regrown":  {100, 10, 0},
+		"/cloned":   {50, 0, 50},
+	} {
+		require.NotNil(t, m[p].FinalSize(), p)
+		require.Equal(t, expected, [3]uint64{*m[p].FinalSize(), m[p].WrittenBytes(), m[p].ClonedBytes()}, p)
+	}
+}
+
+func TestMergeAdjacentExtents(t *testing.T) {
+	diff, err := pkg.ProcessBTRFSStream(bytes.NewReader(buildStream(t, newTestStream().
+		Write("sequential", 0, make([]byte, 10)).
+		Write("sequential", 10, make([]byte, 10)).
+		Write("sequential", 20, make([]byte, 10)).
+		// Not contiguous
+		Write("sparse", 0, make([]byte, 10)).
+		Write("sparse", 20, make([]byte, 10)).
+		// The truncate in between has to be applied after the first write only
+		Write("truncated", 0, make([]byte, 10)).
+		Truncate("truncated", 5).
+		Write("truncated", 10, make([]byte, 10)).
+		Clone("cloned", 0, 10, "b4233aaf045b6a4b89a2c08c8c1b4743", 10, "src", 0).
+		Clone("cloned", 10, 10, "b4233aaf045b6a4b89a2c08c8c1b4743", 10, "src", 10).
+		// Contiguous in the file, but not in the source
+		Clone("cloned", 20, 10, "b4233aaf045b6a4b89a2c08c8c1b4743", 10, "src", 100).
+		Write("cloned", 30, make([]byte, 10)).
+		End())))
+	require.NoError(t, err)
+
+	m := diff.FlatMap(nil)
+	require.Equal(t, []*pkg.DiffExtent{{Kind: pkg.DiffExtentKindWrite, Offset: 0, Len: 30}}, m["/sequential"].Extents)
+	require.Len(t, m["/sparse"].Extents, 2)
+	require.Len(t, m["/truncated"].Extents, 2)
+	require.Equal(t, uint64(15), m["/truncated"].WrittenBytes())
+	require.Equal(t, []*pkg.DiffExtent{
+		{Kind: pkg.DiffExtentKindClone, Offset: 0, Len: 20, CloneSourcePath: "src", CloneOffset: 0},
+		{Kind: pkg.DiffExtentKindClone, Offset: 20, Len: 10, CloneSourcePath: "src", CloneOffset: 100},
+		{Kind: pkg.DiffExtentKindWrite, Offset: 30, Len: 10},
+	}, m["/cloned"].Extents)
+}
@@ -0,0 +1,38 @@
+package pkg
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"sort"
+	"strings"
+)
+
+// WriteRecoveryManifest writes one `NODE_TYPE<TAB>PATH` line for every node deleted in the
+// snapshot, ready to be used in restore scripts. Lines are sorted by path, so that parent
+// directories always come before their contents and can be recreated first.
+func (d *Diff) WriteRecoveryManifest(w io.Writer, ignore DiffNodeMatcher) error {
+	s := d.GetDiffStruct(ignore)
+
+	deleted := make(map[string]*DiffNode)
+	var paths []string
+	for _, node := range s.Deleted {
+		p := node.DisplayPath()
+		if _, ok := deleted[p]; ok {
+			continue
+		}
+		deleted[p] = node
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	var sb strings.Builder
+	for _, p := range paths {
+		sb.WriteString(fmt.Sprintf("%s\t%s\n", deleted[p].NodeType, p))
+	}
+
+	if _, err := io.WriteString(w, sb.String()); err != nil {
+		return errors.Wrap(err, "failed to write recovery manifest")
+	}
+	return nil
+}
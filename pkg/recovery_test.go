@@ -0,0 +1,28 @@
+package pkg_test
+
+import (
+	"bytes"
+	"github.com/cmaster11/btrfs-diff/pkg"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestWriteRecoveryManifest(t *testing.T) {
+	diff, err := pkg.ProcessBTRFSStream(bytes.NewReader(buildStream(t, newTestStream().
+		Unlink("dir/sub/file").
+		Rmdir("dir/sub").
+		Unlink("dir-file").
+		Rmdir("dir").
+		// Deleted and created again
+		Unlink("replaced").
+		MkFile("replaced", 257).
+		Rename("old", "new").
+		MkFile("added", 258).
+		End())))
+	require.NoError(t, err)
+
+	// Parents come before their contents, even if other paths sort between them
+	var buf bytes.Buffer
+	require.NoError(t, pkg.WriteDiff(&buf, diff, &pkg.ProcessFileWithOutputArgs{Format: pkg.OutputFormatRecoveryManifest}))
+	require.Equal(t, "DIR\t/dir\nUNKNOWN\t/dir-file\nDIR\t/dir/sub\nUNKNOWN\t/dir/sub/file\nUNKNOWN\t/old\nFILE\t/replaced\n", buf.String())
+}
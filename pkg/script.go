@@ -0,0 +1,213 @@
+package pkg
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"sort"
+	"strings"
+)
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// scriptPath renders the path of the node inside the target directory of the script
+func scriptPath(n *DiffNode) string {
+	return `"$TARGET"` + shellQuote(n.GetChainPath())
+}
+
+// symlinkTarget returns where a symlink points to. Targets found in the tree have been resolved,
+// so they can only be rendered inside the target directory.
+func symlinkTarget(n *DiffNode) string {
+	for _, r := range n.Relations {
+		if r.Reason != DiffNodeReasonLinkDest {
+			continue
+		}
+		if r.Node.Parent == nil {
+			return shellQuote(r.Node.Path)
+		}
+		return scriptPath(r.Node)
+	}
+	return ""
+}
+
+// scriptMove is a renamed node, moved aside to the $MOVES directory of the script before the
+// deletions, and moved to its destination once its parent exists
+type scriptMove struct {
+	idx  int
+	from string
+	to   string
+}
+
+// waitsFor returns whether the source of the move is inside the destination of another one which
+// has not been set aside yet
+func (m *scriptMove) waitsFor(moves []*scriptMove, done map[*scriptMove]bool) bool {
+	for _, other := range moves {
+		if other != m && !done[other] && isPathWithin(m.from, other.to) {
+			return true
+		}
+	}
+	return false
+}
+
+func isPathWithin(p string, dir string) bool {
+	return strings.HasPrefix(p, dir+"/")
+}
+
+// scriptMovedPath renders a path recorded while processing the stream, which can be inside the
+// source or the destination of a rename, as found once the moves have been set aside. A path equal
+// to a source or a destination is the node which was there before the rename.
+func scriptMovedPath(p string, moves []*scriptMove) string {
+	var best *scriptMove
+	var bestLen int
+	for _, m := range moves {
+		for _, dir := range []string{m.from, m.to} {
+			if isPathWithin(p, dir) && len(dir) > bestLen {
+				best, bestLen = m, len(dir)
+			}
+		}
+	}
+	if best == nil {
+		return `"$TARGET"` + shellQuote(p)
+	}
+	return fmt.Sprintf(`"$MOVES"/%d`, best.idx) + shellQuote(p[bestLen:])
+}
+
+// WriteScript writes a bash script replaying the diff on any filesystem, in restore order (see
+// DiffSortByRestore), taking the target directory as its first argument. File contents are not
+// part of the diff, so written files are only truncated to their final size, when known.
+//
+// Renamed nodes (paired with their sources as by DiffJSONStruct.PairMoves) are moved with their
+// contents. They are set aside first, as the stream can delete their old parents or put something
+// else in their place, and are moved to their destination after the deletions.
+func (d *Diff) WriteScript(w io.Writer, ignore DiffNodeMatcher) error {
+	s := d.GetDiffStruct(ignore)
+
+	var sb strings.Builder
+	sb.WriteString("#!/usr/bin/env bash\n")
+	if d.Meta != nil {
+		sb.WriteString(fmt.Sprintf("# Replays the changes of %s, generated by btrfs-diff\n", d.Meta.Path))
+	}
+	sb.WriteString("set -euo pipefail\n")
+	sb.WriteString("TARGET=\"${1:-.}\"\n\n")
+
+	var moves []*scriptMove
+	movedTo := make(map[*DiffNode]*scriptMove)
+	movedSources := make(map[*DiffNode]bool)
+	added := append([]*DiffNode{}, s.Added...)
+	if err := SortDiffNodes(added, DiffSortByPath); err != nil {
+		return err
+	}
+	for _, n := range added {
+		src := n.renameSource()
+		if src == nil || n.CreatedInSnapshot {
+			continue
+		}
+		for _, rel := range n.Relations {
+			if rel.Reason == DiffNodeReasonRenameSrc {
+				movedSources[rel.Node] = true
+			}
+		}
+		m := &scriptMove{len(moves), src.GetChainPath(), n.GetChainPath()}
+		moves = append(moves, m)
+		movedTo[n] = m
+	}
+
+	if len(moves) > 0 {
+		sb.WriteString("MOVES=\"$(mktemp -d \"$TARGET/.btrfs-diff-moves.XXXXXX\")\"\n")
+
+		// Nested sources are set aside before their parents, unless renamed after them (and so
+		// recorded inside the destination of their parent)
+		pending := append([]*scriptMove{}, moves...)
+		sort.SliceStable(pending, func(i, j int) bool {
+			return strings.Count(pending[i].from, "/") > strings.Count(pending[j].from, "/")
+		})
+		var setAside []*scriptMove
+		done := make(map[*scriptMove]bool)
+		for len(pending) > 0 {
+			var next []*scriptMove
+			for _, m := range pending {
+				if m.waitsFor(moves, done) {
+					next = append(next, m)
+					continue
+				}
+				sb.WriteString(fmt.Sprintf("mv -- %s \"$MOVES\"/%d\n", scriptMovedPath(m.from, setAside), m.idx))
+				setAside = append(setAside, m)
+				done[m] = true
+			}
+			if len(next) == len(pending) {
+				return errors.Errorf("cannot order the move of %s", next[0].from)
+			}
+			pending = next
+		}
+	}
+
+	// Nodes deleted and created again are in both lists, and have to be removed first
+	deleted := append([]*DiffNode{}, s.Deleted...)
+	if err := SortDiffNodes(deleted, DiffSortByRestore); err != nil {
+		return err
+	}
+	for _, n := range deleted {
+		if movedSources[n] {
+			continue
+		}
+		p := scriptMovedPath(n.GetChainPath(), moves)
+		if n.NodeType == DiffNodeTypeDir || n.NodeType == DiffNodeTypeUnknown && n.DeleteCause != DiffDeleteCauseUnlink {
+			sb.WriteString(fmt.Sprintf("rm -rf -- %s\n", p))
+		} else {
+			sb.WriteString(fmt.Sprintf("rm -f -- %s\n", p))
+		}
+	}
+
+	nodes := append(append([]*DiffNode{}, s.Added...), s.Changed...)
+	if err := SortDiffNodes(nodes, DiffSortByRestore); err != nil {
+		return err
+	}
+	for _, n := range nodes {
+		p := scriptPath(n)
+
+		if m := movedTo[n]; m != nil {
+			sb.WriteString(fmt.Sprintf("mv -- \"$MOVES\"/%d %s\n", m.idx, p))
+		} else if n.State == opCreate {
+			switch n.NodeType {
+			case DiffNodeTypeDir:
+				sb.WriteString(fmt.Sprintf("mkdir -p -- %s\n", p))
+			case DiffNodeTypeFile:
+				sb.WriteString(fmt.Sprintf("touch -- %s\n", p))
+			case DiffNodeTypeFIFO:
+				sb.WriteString(fmt.Sprintf("mkfifo -- %s\n", p))
+			case DiffNodeTypeSymLink:
+				if target := symlinkTarget(n); target != "" {
+					sb.WriteString(fmt.Sprintf("ln -sfn -- %s %s\n", target, p))
+				} else {
+					sb.WriteString(fmt.Sprintf("# cannot recreate symlink with unknown target: %s\n", p))
+				}
+			default:
+				sb.WriteString(fmt.Sprintf("# cannot recreate node of type %s: %s\n", n.NodeType, p))
+			}
+		}
+
+		if written := n.TotalBytesWritten(); written > 0 {
+			sb.WriteString(fmt.Sprintf("# contents not available: %d bytes written\n", written))
+		}
+		if cloned := n.ClonedBytes(); cloned > 0 {
+			sb.WriteString(fmt.Sprintf("# contents not available: %d bytes cloned\n", cloned))
+		}
+		if n.Size != nil {
+			sb.WriteString(fmt.Sprintf("truncate -s %d -- %s\n", *n.Size, p))
+		}
+		if n.mode != nil && n.NodeType != DiffNodeTypeSymLink {
+			sb.WriteString(fmt.Sprintf("chmod %o -- %s\n", *n.mode&07777, p))
+		}
+	}
+
+	if len(moves) > 0 {
+		sb.WriteString("rmdir -- \"$MOVES\"\n")
+	}
+
+	if _, err := io.WriteString(w, sb.String()); err != nil {
+		return errors.Wrap(err, "failed to write script")
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package pkg_test
+
+import (
+	"bytes"
+	"github.com/cmaster11/btrfs-diff/pkg"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestWriteScript(t *testing.T) {
+	diff, err := pkg.ProcessBTRFSStream(bytes.NewReader(buildStream(t, newTestStream().
+		Unlink("old/file").
+		Rmdir("old").
+		MkDir("o257-7-0", 257).
+		Rename("o257-7-0", "new dir").
+		MkFile("new dir/it's", 258).
+		Write("new dir/it's", 0, make([]byte, 100)).
+		Truncate("new dir/it's", 100).
+		Chmod("new dir/it's", 0755).
+		Chmod("existing", 0600).
+		End())))
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, diff.WriteScript(&buf, nil))
+	require.Equal(t, `#!/usr/bin/env bash
+# Replays the changes of 002, generated by btrfs-diff
+set -euo pipefail
+TARGET="${1:-.}"
+
+rm -f -- "$TARGET"'/old/file'
+rm -rf -- "$TARGET"'/old'
+chmod 600 -- "$TARGET"'/existing'
+mkdir -p -- "$TARGET"'/new dir'
+touch -- "$TARGET"'/new dir/it'\''s'
+# contents not available: 100 bytes written
+truncate -s 100 -- "$TARGET"'/new dir/it'\''s'
+chmod 755 -- "$TARGET"'/new dir/it'\''s'
+`, buf.String())
+}
+
+func TestWriteScriptRenames(t *testing.T) {
+	diff, err := pkg.ProcessBTRFSStream(bytes.NewReader(buildStream(t, newTestStream().
+		Rename("file", "renamed file").
+		Rename("dir", "other/dir").
+		Chmod("other/dir", 0700).
+		Rename("other/dir/sub/child", "child").
+		Unlink("other/dir/sub/gone").
+		Rmdir("old").
+		End())))
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, diff.WriteScript(&buf, nil))
+	require.Equal(t, `#!/usr/bin/env bash
+# Replays the changes of 002, generated by btrfs-diff
+set -euo pipefail
+TARGET="${1:-.}"
+
+MOVES="$(mktemp -d "$TARGET/.btrfs-diff-moves.XXXXXX")"
+mv -- "$TARGET"'/dir' "$MOVES"/1
+mv -- "$TARGET"'/file' "$MOVES"/2
+mv -- "$MOVES"/1'/sub/child' "$MOVES"/0
+rm -f -- "$MOVES"/1'/sub/gone'
+rm -rf -- "$TARGET"'/old'
+mv -- "$MOVES"/0 "$TARGET"'/child'
+mv -- "$MOVES"/2 "$TARGET"'/renamed file'
+mv -- "$MOVES"/1 "$TARGET"'/other/dir'
+chmod 700 -- "$TARGET"'/other/dir'
+rmdir -- "$MOVES"
+`, buf.String())
+}
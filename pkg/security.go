@@ -0,0 +1,101 @@
+package pkg
+
+import (
+	"fmt"
+	"strings"
+)
+
+type DiffModeBitChange = string
+
+const (
+	DiffModeBitChangeGained DiffModeBitChange = "gained"
+	DiffModeBitChangeLost   DiffModeBitChange = "lost"
+	// The bit is set, but the mode before the stream is unknown, so the node may have had it already
+	DiffModeBitChangeUnknown DiffModeBitChange = "unknown"
+)
+
+type modeBit struct {
+	kind DiffChangeKind
+	mask uint64
+	// The executable bit of directories only allows to traverse them
+	skipDirs bool
+}
+
+var modeBits = []modeBit{
+	{DiffChangeKindExec, 0111, true},
+	{DiffChangeKindSetuid, 04000, false},
+	{DiffChangeKindSetgid, 02000, false},
+	{DiffChangeKindSticky, 01000, false},
+}
+
+// applyMode records a new mode of the node, deriving the security relevant changes by comparing it
+// with the previous mode. The mode before the stream is never known: it is only known for the nodes
+// created in the stream (without any of these bits), or after an earlier mode change in the stream.
+// Otherwise, the bits of the new mode are reported as unknown changes.
+func (n *DiffNode) applyMode(mode uint64) {
+	prevKnown := n.mode != nil || n.CreatedInSnapshot
+	var prevMode uint64
+	if n.mode != nil {
+		prevMode = *n.mode
+	}
+	n.mode = &mode
+
+	for _, bit := range modeBits {
+		if bit.skipDirs && n.NodeType == DiffNodeTypeDir {
+			continue
+		}
+
+		change := ""
+		if !prevKnown {
+			if mode&bit.mask != 0 {
+				change = DiffModeBitChangeUnknown
+			}
+		} else if mode&bit.mask != 0 && prevMode&bit.mask == 0 {
+			change = DiffModeBitChangeGained
+		} else if mode&bit.mask == 0 && prevMode&bit.mask != 0 {
+			change = DiffModeBitChangeLost
+		}
+		if change == "" {
+			continue
+		}
+
+		if change == DiffModeBitChangeUnknown {
+			n.unknownModeBits |= bit.mask
+		} else {
+			n.unknownModeBits &^= bit.mask
+		}
+		gained := change == DiffModeBitChangeGained
+		switch bit.kind {
+		case DiffChangeKindExec:
+			n.GainedExecutable = gained
+			n.LostExecutable = change == DiffModeBitChangeLost
+		case DiffChangeKindSetuid:
+			n.GainedSetuid = gained
+		case DiffChangeKindSetgid:
+			n.GainedSetgid = gained
+		case DiffChangeKindSticky:
+			n.GainedSticky = gained
+		}
+
+		// Only the latest change of each bit is relevant
+		var changes []string
+		for _, c := range n.Changes {
+			if kind, _, _ := strings.Cut(c, ":"); kind != bit.kind {
+				changes = append(changes, c)
+			}
+		}
+		n.Changes = append(changes, fmt.Sprintf("%s:%s", bit.kind, change))
+	}
+}
+
+// HasSecurityChanges returns true if the node gained or lost the executable bit, or gained the
+// setuid/setgid bits, including the ones it may have gained (see DiffModeBitChangeUnknown)
+func (n *DiffNode) HasSecurityChanges() bool {
+	return n.GainedExecutable || n.LostExecutable || n.GainedSetuid || n.GainedSetgid ||
+		n.unknownModeBits&(0111|04000|02000) != 0
+}
+
+// IgnoreNonSecurityChanges matches all the nodes without security relevant changes, see HasSecurityChanges
+func IgnoreNonSecurityChanges(f *DiffNode) bool {
+	return !f.HasSecurityChanges()
+}
@@ -0,0 +1,61 @@
+package pkg_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/cmaster11/btrfs-diff/pkg"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestSecurityChanges(t *testing.T) {
+	stream, err := newTestStream().
+		MkFile("script", 1).
+		Chmod("script", 0755).
+		Chmod("other", 0644).
+		Chmod("was-exec", 0755).
+		Chmod("was-exec", 0644).
+		MkDir("dir", 2).
+		Chmod("dir", 0755).
+		Chmod("suid", 04755).
+		MkFile("new-suid", 4).
+		Chmod("new-suid", 04755).
+		Chmod("sgid", 0644).
+		Chmod("sgid", 02644).
+		MkDir("tmp", 3).
+		Chmod("tmp", 01777).
+		End().
+		Bytes()
+	require.NoError(t, err)
+
+	diff, err := pkg.ProcessBTRFSStream(bytes.NewReader(stream))
+	require.NoError(t, err)
+
+	m := diff.FlatMap(nil)
+	require.True(t, m["/script"].GainedExecutable)
+	require.Contains(t, m["/script"].Changes, "exec:gained")
+	require.False(t, m["/other"].HasSecurityChanges())
+	require.True(t, m["/was-exec"].LostExecutable)
+	require.False(t, m["/was-exec"].GainedExecutable)
+	require.Equal(t, []string{"chmod:mode=755", "chmod:mode=644", "exec:lost"}, m["/was-exec"].Changes)
+	require.False(t, m["/dir"].HasSecurityChanges())
+
+	// The mode of /suid before the stream is unknown, so it may have had the bits already
+	require.False(t, m["/suid"].GainedSetuid)
+	require.False(t, m["/suid"].GainedExecutable)
+	require.Equal(t, []string{"chmod:mode=4755", "exec:unknown", "setuid:unknown"}, m["/suid"].Changes)
+	require.True(t, m["/suid"].HasSecurityChanges())
+	require.True(t, m["/new-suid"].GainedSetuid)
+	require.True(t, m["/new-suid"].GainedExecutable)
+	require.True(t, m["/sgid"].GainedSetgid)
+	require.False(t, m["/sgid"].GainedExecutable)
+	require.True(t, m["/tmp"].GainedSticky)
+	require.False(t, m["/tmp"].HasSecurityChanges())
+
+	jsonBytes, err := json.Marshal(m["/new-suid"])
+	require.NoError(t, err)
+	require.Contains(t, string(jsonBytes), `"gained_executable":true,"gained_setuid":true,"depth":1,`)
+
+	args := &pkg.ProcessFileWithOutputArgs{Security: true}
+	require.Len(t, diff.FlatMap(args.IgnoreMatcher()), 5)
+}
@@ -0,0 +1,62 @@
+package pkg
+
+import (
+	"github.com/cmaster11/btrfs-diff/pkg/diffpb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io"
+)
+
+// DiffServer implements the DiffService of diff.proto
+type DiffServer struct {
+	diffpb.UnimplementedDiffServiceServer
+	// Processor parses the streams, one with the default settings (see NewProcessor) if nil
+	Processor *Processor
+	// Ignore hides the matching nodes from the responses
+	Ignore DiffNodeMatcher
+}
+
+// Diff processes the uploaded stream while it is being received, then sends back its nodes in the order
+// of EachNode
+func (s *DiffServer) Diff(stream diffpb.DiffService_DiffServer) error {
+	p := s.Processor
+	if p == nil {
+		p = NewProcessor()
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		for {
+			req, err := stream.Recv()
+			if err == io.EOF {
+				_ = pw.Close()
+				return
+			}
+			if err != nil {
+				_ = pw.CloseWithError(err)
+				return
+			}
+			// Fails once the processing is over, e.g. if the stream has trailing data
+			if _, err := pw.Write(req.GetData()); err != nil {
+				return
+			}
+		}
+	}()
+
+	d, err := p.ProcessContext(stream.Context(), pr)
+	_ = pr.Close()
+	if err != nil {
+		if ctxErr := stream.Context().Err(); ctxErr != nil {
+			return status.FromContextError(ctxErr).Err()
+		}
+		// e.g. the upload failing
+		if e, ok := status.FromError(err); ok {
+			return e.Err()
+		}
+		return status.Errorf(codes.InvalidArgument, "failed to process stream: %v", err)
+	}
+
+	return d.EachNode(s.Ignore, func(bucket DiffBucket, n *DiffNode) error {
+		return stream.Send(&diffpb.DiffNode{Bucket: bucket, Node: n.toProto()})
+	})
+}
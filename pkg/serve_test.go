@@ -0,0 +1,87 @@
+package pkg_test
+
+import (
+	"bytes"
+	"context"
+	"github.com/cmaster11/btrfs-diff/pkg"
+	"github.com/cmaster11/btrfs-diff/pkg/diffpb"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"io"
+	"net"
+	"testing"
+)
+
+func TestDiffServer(t *testing.T) {
+	lis := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	diffpb.RegisterDiffServiceServer(server, &pkg.DiffServer{})
+	go func() { _ = server.Serve(lis) }()
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	client := diffpb.NewDiffServiceClient(conn)
+
+	upload := func(data []byte) ([]*diffpb.DiffNode, error) {
+		stream, err := client.Diff(context.Background())
+		require.NoError(t, err)
+		// Small chunks, so that the stream is processed while being uploaded
+		for len(data) > 0 {
+			chunk := data[:min(len(data), 7)]
+			data = data[len(chunk):]
+			if err := stream.Send(&diffpb.UploadRequest{Data: chunk}); err != nil {
+				// The server stopped receiving, its error is returned by Recv
+				break
+			}
+		}
+		require.NoError(t, stream.CloseSend())
+
+		var nodes []*diffpb.DiffNode
+		for {
+			node, err := stream.Recv()
+			if err == io.EOF {
+				return nodes, nil
+			}
+			if err != nil {
+				return nodes, err
+			}
+			nodes = append(nodes, node)
+		}
+	}
+
+	data := buildStream(t, newTestStream().
+		MkFile("o257-12-0", 257).
+		Rename("o257-12-0", "new").
+		Write("changed", 0, []byte("data")).
+		Unlink("gone").
+		End())
+	nodes, err := upload(data)
+	require.NoError(t, err)
+
+	diff, err := pkg.ProcessBTRFSStream(bytes.NewReader(data))
+	require.NoError(t, err)
+	var expected []string
+	require.NoError(t, diff.EachNode(nil, func(bucket pkg.DiffBucket, n *pkg.DiffNode) error {
+		expected = append(expected, bucket+" "+n.DisplayPath())
+		return nil
+	}))
+	var got []string
+	for _, n := range nodes {
+		got = append(got, n.GetBucket()+" "+n.GetNode().GetPath())
+	}
+	require.Equal(t, []string{"added /new", "changed /changed", "deleted /gone"}, expected)
+	require.Equal(t, expected, got)
+
+	// Invalid streams fail the call
+	_, err = upload([]byte("not a btrfs stream"))
+	require.Error(t, err)
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
@@ -0,0 +1,78 @@
+package pkg
+
+import (
+	"github.com/pkg/errors"
+	"sort"
+)
+
+type DiffSortBy = string
+
+const (
+	DiffSortByNone    DiffSortBy = ""
+	DiffSortByPath    DiffSortBy = "path"
+	DiffSortByChanges DiffSortBy = "changes"
+	DiffSortByBytes   DiffSortBy = "bytes"
+	// Safe order for restore scripts: deletions from deep to shallow (children before their parents),
+	// then everything else from shallow to deep (parents before their children)
+	DiffSortByRestore DiffSortBy = "restore"
+)
+
+// SortDiffNodes sorts the nodes in place. Changes and bytes sort the most touched nodes first,
+// falling back to the path to keep the order deterministic.
+func SortDiffNodes(nodes []*DiffNode, by DiffSortBy) error {
+	var less func(a, b *DiffNode) bool
+
+	switch by {
+	case DiffSortByNone:
+		return nil
+	case DiffSortByPath:
+		less = func(a, b *DiffNode) bool {
+			return a.GetChainPath() < b.GetChainPath()
+		}
+	case DiffSortByChanges:
+		less = func(a, b *DiffNode) bool {
+			if a.ChangeCount() != b.ChangeCount() {
+				return a.ChangeCount() > b.ChangeCount()
+			}
+			return a.GetChainPath() < b.GetChainPath()
+		}
+	case DiffSortByBytes:
+		less = func(a, b *DiffNode) bool {
+			if a.TotalBytesWritten() != b.TotalBytesWritten() {
+				return a.TotalBytesWritten() > b.TotalBytesWritten()
+			}
+			return a.GetChainPath() < b.GetChainPath()
+		}
+	case DiffSortByRestore:
+		less = func(a, b *DiffNode) bool {
+			aDeleted, bDeleted := a.State == opDelete, b.State == opDelete
+			if aDeleted != bDeleted {
+				return aDeleted
+			}
+			if a.Depth() != b.Depth() {
+				if aDeleted {
+					return a.Depth() > b.Depth()
+				}
+				return a.Depth() < b.Depth()
+			}
+			return a.GetChainPath() < b.GetChainPath()
+		}
+	default:
+		return errors.Errorf("unsupported sort order %q", by)
+	}
+
+	sort.SliceStable(nodes, func(i, j int) bool {
+		return less(nodes[i], nodes[j])
+	})
+	return nil
+}
+
+// Sort sorts all the buckets of the diff, see SortDiffNodes
+func (s *DiffJSONStruct) Sort(by DiffSortBy) error {
+	for _, nodes := range [][]*DiffNode{s.Added, s.Changed, s.Deleted} {
+		if err := SortDiffNodes(nodes, by); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package pkg_test
+
+import (
+	"bytes"
+	"github.com/cmaster11/btrfs-diff/pkg"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestSortRestore(t *testing.T) {
+	stream := buildStream(t, newTestStream().
+		MkDir("new", 1).
+		MkDir("new/sub", 2).
+		MkFile("new/sub/file", 3).
+		MkFile("new/file", 4).
+		Unlink("old/sub/file").
+		Rmdir("old/sub").
+		Unlink("old/file").
+		Rmdir("old").
+		End())
+
+	diff, err := pkg.ProcessBTRFSStream(bytes.NewReader(stream))
+	require.NoError(t, err)
+
+	s := diff.GetDiffStruct(nil)
+	require.NoError(t, s.Sort(pkg.DiffSortByRestore))
+	require.Equal(t, []string{"/new", "/new/file", "/new/sub", "/new/sub/file"}, getPaths(s.Added))
+	require.Equal(t, []string{"/old/sub/file", "/old/file", "/old/sub", "/old"}, getPaths(s.Deleted))
+
+	var nodes []*pkg.DiffNode
+	for _, n := range diff.FlatMap(nil) {
+		nodes = append(nodes, n)
+	}
+	require.NoError(t, pkg.SortDiffNodes(nodes, pkg.DiffSortByRestore))
+	require.Equal(t, []string{"/old/sub/file", "/old/file", "/old/sub", "/old", "/new", "/new/file", "/new/sub", "/new/sub/file"}, getPaths(nodes))
+}
+
+func TestSortByChanges(t *testing.T) {
+	diff, err := pkg.ProcessBTRFSStream(bytes.NewReader(buildStream(t, newTestStream().
+		Chmod("b", 0644).
+		Write("busy", 0, []byte("abc")).
+		Write("busy", 3, []byte("def")).
+		Write("busy", 100, []byte("ghi")).
+		Chown("busy", 0, 0).
+		Chmod("a", 0644).
+		Chown("twice", 0, 0).
+		Chmod("twice", 0600).
+		End())))
+	require.NoError(t, err)
+
+	m := diff.FlatMap(nil)
+	// Contiguous writes count as one change
+	require.Equal(t, 3, m["/busy"].ChangeCount())
+	require.Equal(t, 2, m["/twice"].ChangeCount())
+	require.Equal(t, 1, m["/a"].ChangeCount())
+
+	// Ties are sorted by path
+	nodes := diff.GetDiffStruct(nil).Changed
+	require.NoError(t, pkg.SortDiffNodes(nodes, pkg.DiffSortByChanges))
+	require.Equal(t, []string{"/busy", "/twice", "/a", "/b"}, getPaths(nodes))
+
+	require.ErrorContains(t, pkg.SortDiffNodes(nodes, "size"), "unsupported")
+}
@@ -0,0 +1,191 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"path"
+	"sort"
+	"strings"
+)
+
+// DiffStats aggregates a set of reportable nodes
+type DiffStats struct {
+	Added        int    `json:"added"`
+	Changed      int    `json:"changed"`
+	Deleted      int    `json:"deleted"`
+	BytesWritten uint64 `json:"bytes_written"`
+	// Files built from reflinks have no bytes written, but are still changed
+	BytesCloned uint64 `json:"bytes_cloned,omitempty"`
+
+	// The path with the most segments, and the longest one, e.g. to spot pathological structures.
+	// Ties go to the first path in lexical order.
+	DeepestPath string `json:"deepest_path,omitempty"`
+	LongestPath string `json:"longest_path,omitempty"`
+}
+
+// Total returns the amount of added, changed and deleted nodes
+func (s *DiffStats) Total() int {
+	return s.Added + s.Changed + s.Deleted
+}
+
+// topLevelGroup returns the first path segment of the node (e.g. "/etc" for "/etc/passwd"), or "/" for
+// files directly in the root
+func topLevelGroup(n *DiffNode) string {
+	p := n.GetChainPath()
+	first, _, nested := strings.Cut(strings.TrimPrefix(p, "/"), "/")
+	if !nested && n.NodeType != DiffNodeTypeDir {
+		return "/"
+	}
+	return "/" + first
+}
+
+// add counts a reportable node, the same way GetDiffStruct classifies it
+func (s *DiffStats) add(n *DiffNode) {
+	switch n.State {
+	case opCreate:
+		s.Added++
+	case opDelete:
+		s.Deleted++
+	default:
+		s.Changed++
+	}
+	if n.DeletedInSnapshot && n.State != opDelete {
+		s.Deleted++
+	}
+	if n.State != opDelete {
+		s.BytesWritten += n.TotalBytesWritten()
+		s.BytesCloned += n.ClonedBytes()
+	}
+
+	p := n.GetChainPath()
+	if depth, deepest := strings.Count(p, "/"), strings.Count(s.DeepestPath, "/"); s.DeepestPath == "" || depth > deepest || depth == deepest && p < s.DeepestPath {
+		s.DeepestPath = p
+	}
+	if s.LongestPath == "" || len(p) > len(s.LongestPath) || len(p) == len(s.LongestPath) && p < s.LongestPath {
+		s.LongestPath = p
+	}
+}
+
+// traverseReportable calls fn for all the nodes which would be part of the output
+func (d *Diff) traverseReportable(ignore DiffNodeMatcher, fn func(n *DiffNode)) {
+	d.root.traverse(func(n *DiffNode) {
+		if isIgnored(ignore, n) || !shouldPrintNode(n) {
+			return
+		}
+		fn(n)
+	})
+}
+
+// Stats returns the stats of all the reportable nodes, without collecting them
+func (d *Diff) Stats(ignore DiffNodeMatcher) *DiffStats {
+	s := &DiffStats{}
+	d.traverseReportable(ignore, s.add)
+	return s
+}
+
+// GroupByTopLevel returns the stats of the reportable nodes grouped by their top level directory,
+// see topLevelGroup
+func (d *Diff) GroupByTopLevel(ignore DiffNodeMatcher) map[string]*DiffStats {
+	groups := make(map[string]*DiffStats)
+	d.traverseReportable(ignore, func(n *DiffNode) {
+		key := topLevelGroup(n)
+		if _, ok := groups[key]; !ok {
+			groups[key] = &DiffStats{}
+		}
+		groups[key].add(n)
+	})
+	return groups
+}
+
+// WriteCounts writes only the amount of added, changed and deleted nodes, as a text line
+// (e.g. "added=1 changed=2 deleted=0 total=3") or as json, which also has the deepest and the
+// longest paths
+func (d *Diff) WriteCounts(w io.Writer, ignore DiffNodeMatcher, asJSON bool) error {
+	s := d.Stats(ignore)
+	if asJSON {
+		out, err := json.Marshal(struct {
+			Added       int    `json:"added"`
+			Changed     int    `json:"changed"`
+			Deleted     int    `json:"deleted"`
+			DeepestPath string `json:"deepest_path,omitempty"`
+			LongestPath string `json:"longest_path,omitempty"`
+		}{s.Added, s.Changed, s.Deleted, s.DeepestPath, s.LongestPath})
+		if err != nil {
+			return errors.Wrap(err, "failed to marshal counts")
+		}
+		_, err = fmt.Fprintf(w, "%s\n", out)
+		return err
+	}
+	_, err := fmt.Fprintf(w, "added=%d changed=%d deleted=%d total=%d\n", s.Added, s.Changed, s.Deleted, s.Total())
+	return err
+}
+
+// DiffExtensionNone is the extension of the files without one, see GroupByExtension
+const DiffExtensionNone = "(none)"
+
+// DiffExtensionStats aggregates the reportable files with the same extension
+type DiffExtensionStats struct {
+	Extension    string `json:"extension"`
+	Count        int    `json:"count"`
+	BytesWritten uint64 `json:"bytes_written"`
+}
+
+// GroupByExtension returns the stats of the reportable nodes which are not directories (deleted files
+// often have an unknown type), grouped by the extension of their name (e.g. ".log", or
+// DiffExtensionNone), the most common first
+func (d *Diff) GroupByExtension(ignore DiffNodeMatcher) []*DiffExtensionStats {
+	groups := make(map[string]*DiffExtensionStats)
+	d.traverseReportable(ignore, func(n *DiffNode) {
+		if n.NodeType == DiffNodeTypeDir {
+			return
+		}
+		ext := path.Ext(n.Path)
+		if ext == "" {
+			ext = DiffExtensionNone
+		}
+		if _, ok := groups[ext]; !ok {
+			groups[ext] = &DiffExtensionStats{Extension: ext}
+		}
+		groups[ext].Count++
+		if n.State != opDelete {
+			groups[ext].BytesWritten += n.TotalBytesWritten()
+		}
+	})
+
+	var stats []*DiffExtensionStats
+	for _, s := range groups {
+		stats = append(stats, s)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Count != stats[j].Count {
+			return stats[i].Count > stats[j].Count
+		}
+		return stats[i].Extension < stats[j].Extension
+	})
+	return stats
+}
+
+// WriteExtensions writes the stats of GroupByExtension, one line per extension with the amount of files
+// and the bytes written to them (e.g. "     500    1.2 MiB .log"), or as json
+func (d *Diff) WriteExtensions(w io.Writer, ignore DiffNodeMatcher, asJSON bool, bytesFormat BytesFormat) error {
+	stats := d.GroupByExtension(ignore)
+	if asJSON {
+		if stats == nil {
+			stats = []*DiffExtensionStats{}
+		}
+		out, err := json.Marshal(stats)
+		if err != nil {
+			return errors.Wrap(err, "failed to marshal extensions")
+		}
+		_, err = fmt.Fprintf(w, "%s\n", out)
+		return err
+	}
+	for _, s := range stats {
+		if _, err := fmt.Fprintf(w, "%8d %10s %s\n", s.Count, formatBytes(s.BytesWritten, bytesFormat), s.Extension); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package pkg_test
+
+import (
+	"bytes"
+	"github.com/cmaster11/btrfs-diff/pkg"
+	"github.com/stretchr/testify/require"
+	"regexp"
+	"testing"
+)
+
+func TestTopWritten(t *testing.T) {
+	stream, err := newTestStream().
+		Write("small", 0, make([]byte, 10)).
+		Write("big", 0, make([]byte, 3000)).
+		UpdateExtent("big", 3000, 2*1024*1024).
+		Write("medium", 0, make([]byte, 2000)).
+		Chmod("untouched", 0644).
+		End().
+		Bytes()
+	require.NoError(t, err)
+
+	diff, err := pkg.ProcessBTRFSStream(bytes.NewReader(stream))
+	require.NoError(t, err)
+
+	var paths []string
+	for _, node := range diff.TopWritten(nil, 2) {
+		paths = append(paths, node.GetChainPath())
+	}
+	require.Equal(t, []string{"/big", "/medium"}, paths)
+	require.Len(t, diff.TopWritten(nil, 10), 3)
+
+	require.Equal(t, "512 B", pkg.HumanizeBytes(512))
+	require.Equal(t, "1.5 KiB", pkg.HumanizeBytes(1536))
+	require.Equal(t, "2.0 MiB", pkg.HumanizeBytes(2*1024*1024+3000))
+	require.Equal(t, "3.0 GiB", pkg.HumanizeBytes(3*1024*1024*1024))
+}
+
+func TestGroupByTopLevel(t *testing.T) {
+	stream, err := newTestStream().
+		MkDir("etc", 1).
+		MkFile("etc/passwd", 2).
+		Write("etc/passwd", 0, make([]byte, 100)).
+		Write("var/log/syslog", 0, make([]byte, 1000)).
+		Unlink("var/tmp").
+		MkFile("file", 3).
+		Write("file", 0, make([]byte, 10)).
+		End().
+		Bytes()
+	require.NoError(t, err)
+
+	diff, err := pkg.ProcessBTRFSStream(bytes.NewReader(stream))
+	require.NoError(t, err)
+
+	groups := diff.GroupByTopLevel(nil)
+	require.Equal(t, &pkg.DiffStats{Added: 2, BytesWritten: 100, DeepestPath: "/etc/passwd", LongestPath: "/etc/passwd"}, groups["/etc"])
+	require.Equal(t, &pkg.DiffStats{Changed: 1, Deleted: 1, BytesWritten: 1000, DeepestPath: "/var/log/syslog", LongestPath: "/var/log/syslog"}, groups["/var"])
+	require.Equal(t, &pkg.DiffStats{Added: 1, BytesWritten: 10, DeepestPath: "/file", LongestPath: "/file"}, groups["/"])
+	require.Len(t, groups, 3)
+}
+
+func TestGroupByExtension(t *testing.T) {
+	diff, err := pkg.ProcessBTRFSStream(bytes.NewReader(buildStream(t, newTestStream().
+		MkDir("logs.d", 1).
+		Write("logs.d/a.log", 0, make([]byte, 100)).
+		Write("logs.d/b.log", 0, make([]byte, 20)).
+		Unlink("old.log").
+		Write("app.conf", 0, make([]byte, 5)).
+		Write("Makefile", 0, make([]byte, 1)).
+		End())))
+	require.NoError(t, err)
+
+	require.Equal(t, []*pkg.DiffExtensionStats{
+		{Extension: ".log", Count: 3, BytesWritten: 120},
+		{Extension: "(none)", Count: 1, BytesWritten: 1},
+		{Extension: ".conf", Count: 1, BytesWritten: 5},
+	}, diff.GroupByExtension(nil))
+
+	var buf bytes.Buffer
+	require.NoError(t, pkg.WriteDiff(&buf, diff, &pkg.ProcessFileWithOutputArgs{ByExtension: true, Bytes: pkg.BytesFormatRaw}))
+	require.Equal(t, "       3        120 .log\n       1          1 (none)\n       1          5 .conf\n", buf.String())
+
+	buf.Reset()
+	args := &pkg.ProcessFileWithOutputArgs{ByExtension: true, Format: pkg.OutputFormatJSON, IgnorePaths: pkg.DiffIgnorePaths{regexp.MustCompile(`\.log$`)}}
+	require.NoError(t, pkg.WriteDiff(&buf, diff, args))
+	require.JSONEq(t, `[{"extension":"(none)","count":1,"bytes_written":1},{"extension":".conf","count":1,"bytes_written":5}]`, buf.String())
+
+	require.Error(t, pkg.WriteDiff(&buf, diff, &pkg.ProcessFileWithOutputArgs{ByExtension: true, Format: pkg.OutputFormatNames}))
+	require.Error(t, pkg.WriteDiff(&buf, diff, &pkg.ProcessFileWithOutputArgs{ByExtension: true, CountOnly: true}))
+}
+
+func TestWriteCounts(t *testing.T) {
+	diff, err := pkg.ProcessBTRFSStream(bytes.NewReader(buildStream(t, newTestStream().
+		MkFile("a", 1).
+		MkFile("b", 2).
+		Write("c", 0, make([]byte, 10)).
+		Unlink("d").
+		End())))
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, diff.WriteCounts(&buf, nil, false))
+	require.Equal(t, "added=2 changed=1 deleted=1 total=4\n", buf.String())
+
+	buf.Reset()
+	ignore := pkg.DiffIgnorePaths{regexp.MustCompile("^/a$")}
+	require.NoError(t, diff.WriteCounts(&buf, ignore, true))
+	require.JSONEq(t, `{"added":1,"changed":1,"deleted":1,"deepest_path":"/b","longest_path":"/b"}`, buf.String())
+
+	require.Equal(t, &pkg.DiffStats{Added: 2, Changed: 1, Deleted: 1, BytesWritten: 10, DeepestPath: "/a", LongestPath: "/a"}, diff.Stats(nil))
+}
+
+func TestStatsPaths(t *testing.T) {
+	diff, err := pkg.ProcessBTRFSStream(bytes.NewReader(buildStream(t, newTestStream().
+		MkDir("a", 1).
+		MkDir("a/b", 2).
+		MkFile("a/b/c", 3).
+		MkFile("a/b/d", 4).
+		MkFile("a_very_long_file_name", 5).
+		End())))
+	require.NoError(t, err)
+
+	s := diff.Stats(nil)
+	require.Equal(t, "/a/b/c", s.DeepestPath)
+	require.Equal(t, "/a_very_long_file_name", s.LongestPath)
+
+	// Only reportable nodes are considered
+	s = diff.Stats(pkg.DiffIgnorePaths{regexp.MustCompile("^/a_very"), regexp.MustCompile("^/a/b/c$")})
+	require.Equal(t, "/a/b/d", s.DeepestPath)
+	require.Equal(t, "/a/b/d", s.LongestPath)
+
+	var buf bytes.Buffer
+	require.NoError(t, diff.WriteCounts(&buf, nil, true))
+	require.JSONEq(t, `{"added":5,"changed":0,"deleted":0,"deepest_path":"/a/b/c","longest_path":"/a_very_long_file_name"}`, buf.String())
+
+	// Nothing to report
+	require.Equal(t, &pkg.DiffStats{}, diff.Stats(pkg.DiffIgnorePaths{regexp.MustCompile(".")}))
+}
@@ -2,130 +2,404 @@ package pkg
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"github.com/pkg/errors"
+	"io"
 	"os"
-	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
+	"time"
 )
 
 type ProcessFileWithOutputArgs struct {
-	ArgFile     string
+	ArgFiles []string
+	// The processor parsing the files, one with the default settings (see NewProcessor) if nil
+	Processor   *Processor
 	IgnorePaths DiffIgnorePaths
-	JSON        bool
+	// Output format, see FormatNames
+	Format OutputFormat
+	// Deprecated: use Format
+	JSON bool
+	// Deprecated: use Format
+	DOT        bool
+	SortBy     DiffSortBy
+	NoDirMTime bool
+	// If true, only output nodes with security relevant changes, see DiffNode.HasSecurityChanges
+	Security bool
+	// If defined, only output nodes which had an xattr starting with this prefix set or removed
+	XattrPrefix string
+	// If true, only output nodes which have been both created and deleted, see DiffNode.IsChurn
+	ChurnOnly bool
+	// If true, hide created directories containing created nodes, see IgnoreImpliedDirs
+	LeavesOnly bool
+	// If true, fail if any reportable node has an unknown type
+	StrictTypes bool
+	// If defined, only output the nodes not covered by this manifest of expected changes
+	Expected *DiffExpected
+	// If Offset or Count are defined, only output a page of the JSON nodes
+	Offset int
+	Count  int
+
+	// If defined, only output files whose changed fraction (see DiffNode.ChangedFraction) is at least
+	// this percentage. Nodes with an unknown fraction are left out.
+	MinChangePct float64
+
+	// If defined, fail before processing if the first stream has not been sent from this parent uuid
+	ExpectParent string
+
+	// If true, renamed nodes are output as a single moved entry, instead of an added and a deleted node
+	Moves bool
+
+	// If true, files replaced by the atomic save of an editor are output as modified, see
+	// Diff.CoalesceAtomicSaves
+	CoalesceAtomicSaves bool
+	// The names of the temporary and backup files of atomic saves, DefaultAtomicSavePattern if nil
+	AtomicSavePattern *regexp.Regexp
+
+	// If true, report the content changes of files on all their links, see Diff.FollowHardlinks
+	FollowHardlinks bool
+
+	// How to format the JSON output, compact by default
+	JSONStyle JSONStyle
+	// If true, the JSON output (json, ndjson and json-patch formats) is colorized for terminals, see
+	// HighlightJSON
+	ColorJSON bool
+	// If true, the output does not end with a newline
+	NoNewline bool
+	// If defined, only output the nodes of this bucket (json, ndjson and names formats)
+	Op DiffBucket
+	// If true, the names format separates paths with null bytes instead of newlines
+	Print0 bool
+	// If defined, how the root node is shown in the outputs, see Diff.RootLabel
+	RootLabel DiffRootLabel
+
+	// If true, only output the amount of added, changed and deleted nodes, see Diff.WriteCounts
+	CountOnly bool
+	// If true, only output the amount of files and bytes written by extension, see Diff.WriteExtensions
+	ByExtension bool
+	// If true, only output the nodes which have only been renamed, see Diff.WriteRenames
+	RenamesOnly bool
+	// If true, only output the findings of the audit rules, see Diff.WriteAudit
+	Audit bool
+	// The audit rules, DefaultAuditRules if nil
+	AuditRules []*AuditRule
+	// If defined, fail with an AlertThresholdError after the output if there are more reportable nodes
+	AlertThreshold int
+	// If defined, the fingerprint of a known-good diff (see Diff.FilteredFingerprint): nothing is output
+	// if the diff has the same one, otherwise it fails with a BaselineError after the output
+	Baseline string
+
+	// If defined, text output ends with the Top files with the most bytes written
+	Top int
+	// How the text and diff-stat formats render amounts of bytes, human by default
+	Bytes BytesFormat
+
+	// If defined, text output shows captured timestamps relative to this time
+	RelativeTimeRef *time.Time
+	// If defined, text output shows the inode numbers of the nodes, when known
+	ShowInode bool
+	// If defined, text output shows the POSIX ACLs set on the nodes, decoded
+	DecodeACLs bool
+
+	// Deprecated: use Format
+	RecoveryManifest bool
 }
 
-func ProcessFile(fileName string) (*Diff, error) {
-	fileName, err := filepath.Abs(fileName)
-	if err != nil {
-		return nil, errors.Wrap(err, "bad filename")
+func ProcessFileAndOutput(args *ProcessFileWithOutputArgs) error {
+	return ProcessFileAndOutputContext(context.Background(), args)
+}
+
+// ProcessFileAndOutputContext is like ProcessFileAndOutput, but stops processing as soon as the context is done
+func ProcessFileAndOutputContext(ctx context.Context, args *ProcessFileWithOutputArgs) error {
+	// Bad output args fail before processing the files
+	if err := args.validateOutput(); err != nil {
+		return err
 	}
 
-	f, err := os.Open(fileName)
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to open file")
+	p := args.Processor
+	if p == nil {
+		p = NewProcessor()
+	}
+
+	if args.ExpectParent != "" && len(args.ArgFiles) > 0 {
+		meta, err := readFileMeta(p, args.ArgFiles[0])
+		if err != nil {
+			return errors.Wrapf(err, "failed to read meta of file %s", args.ArgFiles[0])
+		}
+		if err := meta.validateParent(args.ExpectParent); err != nil {
+			return err
+		}
 	}
-	defer f.Close()
 
-	diff, err := ProcessBTRFSStream(f)
+	diff, err := p.ProcessFilesContext(ctx, args.ArgFiles...)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to process btrfs stream file")
+		return errors.Wrap(err, "failed to process files")
 	}
 
-	return diff, nil
-}
+	if args.CoalesceAtomicSaves {
+		diff.CoalesceAtomicSaves(args.AtomicSavePattern)
+	}
 
-func ProcessFileAndOutput(args *ProcessFileWithOutputArgs) error {
-	diff, err := ProcessFile(args.ArgFile)
-	if err != nil {
-		return errors.Wrap(err, "failed to process file")
+	if args.FollowHardlinks {
+		diff.FollowHardlinks()
 	}
 
-	if args.JSON {
-		str, err := diff.printJSON(args.IgnorePaths)
-		if err != nil {
-			return errors.Wrapf(err, "failed to marshal json")
+	if args.StrictTypes {
+		if err := diff.CheckTypes(args.IgnoreMatcher()); err != nil {
+			return err
 		}
-		fmt.Printf("%s", str)
-	} else {
-		diff.print(args.IgnorePaths)
 	}
 
-	return nil
+	var baselineErr error
+	if args.Baseline != "" {
+		fingerprint := diff.FilteredFingerprint(args.IgnoreMatcher())
+		if fingerprint == args.Baseline {
+			return nil
+		}
+		baselineErr = &BaselineError{fingerprint, args.Baseline}
+	}
+
+	if err := WriteDiff(os.Stdout, diff, args); err != nil {
+		return err
+	}
+
+	if args.AlertThreshold > 0 {
+		if err := diff.CheckAlertThreshold(args.IgnoreMatcher(), args.AlertThreshold); err != nil {
+			return err
+		}
+	}
+	return baselineErr
 }
 
-func validateBTRFSStream(input *bufio.Reader) error {
-	btrfsStreamHeader, err := input.ReadString('\x00')
-	if err != nil {
-		return errors.Wrap(err, "failed to read stream header")
+var (
+	// ErrEmptyStream is returned when processing a stream which contains no data at all
+	ErrEmptyStream = errors.New("empty stream")
+	// ErrNotBTRFSStream is returned when the stream does not start with the btrfs stream magic
+	ErrNotBTRFSStream = errors.New("not a btrfs stream")
+)
+
+// maxStreamVersion is the latest protocol version of the streams which can be processed
+const maxStreamVersion = 2
+
+// validateBTRFSStream checks the stream header, returning the stream version
+func validateBTRFSStream(input *bufio.Reader) (uint32, error) {
+	magicLen := len(BTRFS_SEND_STREAM_MAGIC) + 1
+	magic, err := input.Peek(magicLen)
+	if err != nil && err != io.EOF {
+		return 0, errors.Wrap(err, "failed to read stream header")
+	}
+	if len(magic) == 0 {
+		return 0, ErrEmptyStream
 	}
-	if btrfsStreamHeader[:len(btrfsStreamHeader)-1] != BTRFS_SEND_STREAM_MAGIC {
-		return errors.Wrapf(err, "bad stream magic data, expected %v got %v", BTRFS_SEND_STREAM_MAGIC, btrfsStreamHeader)
+	if string(magic) != BTRFS_SEND_STREAM_MAGIC+"\x00" {
+		return 0, errors.Wrapf(ErrNotBTRFSStream, "bad stream magic data, expected %q got %q", BTRFS_SEND_STREAM_MAGIC, magic)
+	}
+	if _, err := input.Discard(magicLen); err != nil {
+		return 0, errors.Wrap(err, "failed to discard stream header")
+	}
+	// Some tools re-muxing streams pad the header with extra nulls. The version is never 0 and
+	// little endian, so its first byte cannot be a null.
+	for {
+		b, err := input.Peek(1)
+		if err != nil || b[0] != 0 {
+			break
+		}
+		_, _ = input.Discard(1)
 	}
 	verB, err := peekAndDiscard(input, 4)
 	if err != nil {
-		return errors.Wrap(err, "failed to read version bytes")
+		return 0, errors.Wrap(err, "failed to read version bytes")
 	}
 	ver := binary.LittleEndian.Uint32(verB)
-	if ver != 1 {
-		return errors.Wrapf(err, "unexpected stream version %v", ver)
+	if ver < BTRFS_SEND_STREAM_VERSION || ver > maxStreamVersion {
+		return 0, errors.Errorf("unexpected stream version %v", ver)
 	}
 
-	return nil
+	return ver, nil
 }
 
 func errUnsupported(command *commandInst) error {
-	return errors.Errorf("unsupported command %d %s", command.OriginalType, command.Type.Name)
+	return decodeErrorf("unsupported command %d %s", command.OriginalType, command.Type.Name)
 }
 
-func ProcessBTRFSStream(stream *os.File) (*Diff, error) {
-	input := bufio.NewReader(stream)
+func newDiff(p *Processor) *Diff {
+	d := &Diff{
+		root: &DiffNode{
+			NodeType:  DiffNodeTypeDir,
+			Path:      "",
+			Children:  make(map[string]*DiffNode),
+			processor: p,
+		},
+	}
+	d.root.diff = d
+	return d
+}
 
-	if err := validateBTRFSStream(input); err != nil {
-		return nil, errors.Wrap(err, "failed to validate btrfs stream")
+// touch records that the node has been changed by the stream being processed
+func (d *Diff) touch(node *DiffNode) {
+	node.SourceStreamIndex = d.StreamCount - 1
+	if d.Meta != nil {
+		node.SourceUUID = d.Meta.UUID
 	}
+}
 
-	diff := &Diff{
-		root: &DiffNode{
-			NodeType: DiffNodeTypeDir,
-			Path:     "",
-			Children: make(map[string]*DiffNode),
-		},
+func (d *Diff) proc() *Processor {
+	return d.root.proc()
+}
+
+// processStream applies all the commands of a stream to the diff tree
+func (d *Diff) processStream(ctx context.Context, stream io.Reader) error {
+	reader, stop := newContextReader(ctx, stream)
+	defer stop()
+	counter := &countingReader{r: reader}
+	return d.processInput(ctx, bufio.NewReader(counter), counter, streamSizeHint(stream))
+}
+
+// StreamError is returned when processing a stream fails, with the byte offset (from the start of the
+// input, concatenated streams included) of the command or the stream header which failed
+type StreamError struct {
+	Offset int64
+	Err    error
+}
+
+func (e *StreamError) Error() string {
+	return fmt.Sprintf("at offset %d: %s", e.Offset, e.Err)
+}
+
+func (e *StreamError) Unwrap() error {
+	return e.Err
+}
+
+// decodeError marks the failures of reading and decoding a stream, which are returned as a StreamError
+type decodeError struct {
+	err error
+}
+
+func (e *decodeError) Error() string {
+	return e.err.Error()
+}
+
+func (e *decodeError) Unwrap() error {
+	return e.err
+}
+
+func decodeErrorf(format string, params ...interface{}) error {
+	return &decodeError{fmt.Errorf(format, params...)}
+}
+
+// WriteErrorJSON writes a fatal error as a json object, for automated consumers (e.g.
+// {"error":"...","offset":30}), where the offset is only defined for a StreamError
+func WriteErrorJSON(w io.Writer, err error) error {
+	out := struct {
+		Error  string `json:"error"`
+		Offset *int64 `json:"offset,omitempty"`
+	}{Error: err.Error()}
+	var streamErr *StreamError
+	if errors.As(err, &streamErr) {
+		out.Offset = &streamErr.Offset
+	}
+	if err := json.NewEncoder(w).Encode(out); err != nil {
+		return errors.Wrap(err, "failed to write error")
 	}
+	return nil
+}
+
+// processInput applies all the commands of a stream to the diff tree, stopping after its END command.
+// The counter is the reader below input, used to track the offset of the commands. The size of the
+// whole input, if known (0 otherwise), is only used to report the progress.
+func (d *Diff) processInput(ctx context.Context, input *bufio.Reader, counter *countingReader, size int64) (err error) {
+	p := d.proc()
+
+	offset := counter.n - int64(input.Buffered())
+	defer func() {
+		d.renderWrites()
+		// Only the failures of the stream itself have an offset, not e.g. the tripwire or a conflicting node
+		var decodeErr *decodeError
+		if errors.As(err, &decodeErr) {
+			err = &StreamError{offset, err}
+		}
+	}()
 
-	var err error
+	ver, err := validateBTRFSStream(input)
+	if err != nil {
+		return &decodeError{errors.Wrap(err, "failed to validate btrfs stream")}
+	}
+	d.StreamVersion = ver
+	d.StreamCount++
+
+	var command *commandInst
+	var op operation
 	stop := false
+	commands := 0
+	progress := func(done bool) {
+		if p.OnProgress != nil {
+			p.OnProgress(Progress{counter.n - int64(input.Buffered()), size, commands, done})
+		}
+	}
 	for {
+		if command != nil {
+			if p.Strict && op != opIgnore && len(command.data) > 0 {
+				return errors.Errorf("%d leftover bytes after the params of command %s at offset %d", len(command.data), command.Type.Name, offset)
+			}
+			// Nothing refers to the data of the previous command anymore, so its buffer can be reused
+			command.release()
+		}
 		if stop {
 			break
 		}
+		if p.StopAfter > 0 && d.CommandCount >= p.StopAfter {
+			p.info("stopped after command %d at offset %d", d.CommandCount, offset)
+			d.Stopped = true
+			break
+		}
+
+		if err := ctx.Err(); err != nil {
+			return errors.Wrap(err, "processing interrupted")
+		}
+
+		offset = counter.n - int64(input.Buffered())
 
-		var command *commandInst
-		command, err = readCommand(input)
+		command, err = p.readCommand(input, ver)
 		if err != nil {
-			return nil, errors.Wrap(err, "failed to read command")
+			return errors.Wrap(err, "failed to read command")
+		}
+		d.CommandCount++
+		commands++
+		if commands%progressInterval == 0 {
+			progress(false)
+		}
+
+		op = command.Type.Op
+		if p.CaptureTimestamps && !p.IgnoreTimestamps && command.OriginalType == BTRFS_SEND_C_UTIMES {
+			op = opModify
 		}
 
-		if command.Type.Op != opIgnore {
-			info("cmd: %s, mapped: %s", command.Type.Name, command.Type.Op)
+		if op != opIgnore {
+			p.info("cmd: %s, mapped: %s", command.Type.Name, op)
 		}
 
-		switch command.Type.Op {
+		switch op {
 		case opUnspec:
-			return nil, errUnsupported(command)
+			return errUnsupported(command)
 		case opIgnore:
+			if p.OnIgnoredCommand != nil {
+				p.OnIgnoredCommand(command.OriginalType, command.Type.Name, offset)
+			}
 			continue
 		case opEnd:
 			stop = true
+			progress(true)
 			continue
 
 		case opRename:
 			path, err := command.ReadParam(BTRFS_SEND_A_PATH)
 			if err != nil {
-				return nil, errors.Wrap(err, "failed to read from-path param")
+				return errors.Wrap(err, "failed to read from-path param")
 			}
 
 			var fromPath string
@@ -134,34 +408,34 @@ func ProcessBTRFSStream(stream *os.File) (*Diff, error) {
 			if command.OriginalType == BTRFS_SEND_C_RENAME {
 				_toPath, err := command.ReadParam(BTRFS_SEND_A_PATH_TO)
 				if err != nil {
-					return nil, errors.Wrap(err, "failed to read to-path param")
+					return errors.Wrap(err, "failed to read to-path param")
 				}
 				toPath = _toPath.(string)
 				fromPath = path.(string)
 			} else if command.OriginalType == BTRFS_SEND_C_LINK {
 				_fromPath, err := command.ReadParam(BTRFS_SEND_A_PATH_LINK)
 				if err != nil {
-					return nil, errors.Wrap(err, "failed to read to-path param")
+					return errors.Wrap(err, "failed to read to-path param")
 				}
 				fromPath = _fromPath.(string)
 				toPath = path.(string)
 			} else {
-				return nil, errors.Wrapf(err, "invalid command for rename: %s", command.Type.Name)
+				return errors.Wrapf(err, "invalid command for rename: %s", command.Type.Name)
 			}
 
-			if err := diff.processRenameOrLink(fromPath, toPath, command); err != nil {
-				return nil, errors.Wrap(err, "failed to process rename")
+			if err := d.processRenameOrLink(fromPath, toPath, command); err != nil {
+				return errors.Wrap(err, "failed to process rename")
 			}
 			continue
 
 		case opDelete:
 			path, err := command.ReadParam(BTRFS_SEND_A_PATH)
 			if err != nil {
-				return nil, errors.Wrap(err, "failed to read path param")
+				return errors.Wrap(err, "failed to read path param")
 			}
 
-			if err := diff.processDelete(path.(string), command); err != nil {
-				return nil, errors.Wrap(err, "failed to process delete")
+			if err := d.processDelete(path.(string), command); err != nil {
+				return errors.Wrap(err, "failed to process delete")
 			}
 			continue
 
@@ -170,40 +444,47 @@ func ProcessBTRFSStream(stream *os.File) (*Diff, error) {
 			case BTRFS_SEND_C_SNAPSHOT:
 				fallthrough
 			case BTRFS_SEND_C_SUBVOL:
-				path, err := command.ReadParam(BTRFS_SEND_A_PATH)
-				if err != nil {
-					return nil, errors.Wrap(err, "failed to read path param")
-				}
-				uuid, err := command.ReadParam(BTRFS_SEND_A_UUID)
-				if err != nil {
-					return nil, errors.Wrap(err, "failed to read uuid param")
-				}
-				ctransid, err := command.ReadParam(BTRFS_SEND_A_CTRANSID)
+				meta, err := readMeta(command)
 				if err != nil {
-					return nil, errors.Wrap(err, "failed to read ctransid param")
+					return err
 				}
 				if command.OriginalType == BTRFS_SEND_C_SNAPSHOT {
-					cloneUUID, err := command.ReadParam(BTRFS_SEND_A_CLONE_UUID)
-					if err != nil {
-						return nil, errors.Wrap(err, "failed to read clone uuid param")
-					}
-					cloneCTransid, err := command.ReadParam(BTRFS_SEND_A_CLONE_CTRANSID)
-					if err != nil {
-						return nil, errors.Wrap(err, "failed to read clone ctransid param")
-					}
-					info("received snapshot at %s [uuid=%s,ctransid=%d,clone_uuid=%s,clone_ctransid=%d]", path, uuid, ctransid, cloneUUID, cloneCTransid)
+					p.info("received snapshot at %s [uuid=%s,ctransid=%d,clone_uuid=%s,clone_ctransid=%d]", meta.Path, meta.UUID, meta.CTransID, meta.CloneUUID, meta.CloneCTransID)
 				} else {
-					info("received subvol at %s [uuid=%s,ctransid=%d]", path, uuid, ctransid)
+					p.info("received subvol at %s [uuid=%s,ctransid=%d]", meta.Path, meta.UUID, meta.CTransID)
 				}
+
+				// When applying multiple streams, each one has to be an incremental on top of the previous one
+				if d.Meta != nil && meta.CloneUUID != d.Meta.UUID {
+					return errors.Errorf("broken stream chain: %s has parent uuid %q, but the previous snapshot %s has uuid %q", meta.Path, meta.CloneUUID, d.Meta.Path, d.Meta.UUID)
+				}
+				if d.Meta == nil {
+					d.Kind = meta.kind()
+					d.firstMeta = meta
+				}
+				d.Meta = meta
+				d.root.subvolume = meta.Path
 				continue
 
 			case BTRFS_SEND_C_CLONE:
-				return nil, errUnsupported(command)
+				// The kernel emits the path of a clone after its offset and length, so we cannot read
+				// its params in order
+				if err := command.ReadAllParams(); err != nil {
+					return errors.Wrap(err, "failed to read clone params")
+				}
+				path, err := command.Param(BTRFS_SEND_A_PATH)
+				if err != nil {
+					return errors.Wrap(err, "failed to read path param")
+				}
+				if err := d.processModify(path.(string), command); err != nil {
+					return errors.Wrap(err, "failed to process clone")
+				}
+				continue
 			}
 
 			path, err := command.ReadParam(BTRFS_SEND_A_PATH)
 			if err != nil {
-				return nil, errors.Wrap(err, "failed to read path param")
+				return errors.Wrap(err, "failed to read path param")
 			}
 
 			// https://docs.huihoo.com/doxygen/linux/kernel/3.7/fs_2btrfs_2send_8c_source.html :3365
@@ -219,13 +500,15 @@ func ProcessBTRFSStream(stream *os.File) (*Diff, error) {
 			case BTRFS_SEND_C_MKFIFO:
 				fallthrough
 			case BTRFS_SEND_C_MKSOCK:
-				if err := diff.processCreate(path.(string), command); err != nil {
-					return nil, errors.Wrap(err, "failed to process create")
+				if err := d.processCreate(path.(string), command); err != nil {
+					return errors.Wrap(err, "failed to process create")
 				}
 				continue
 
 			case BTRFS_SEND_C_WRITE:
 				fallthrough
+			case BTRFS_SEND_C_ENCODED_WRITE:
+				fallthrough
 			case BTRFS_SEND_C_UPDATE_EXTENT:
 				fallthrough
 			case BTRFS_SEND_C_TRUNCATE:
@@ -239,21 +522,148 @@ func ProcessBTRFSStream(stream *os.File) (*Diff, error) {
 			case BTRFS_SEND_C_REMOVE_XATTR:
 				fallthrough
 			case BTRFS_SEND_C_UTIMES:
-				if err := diff.processModify(path.(string), command); err != nil {
-					return nil, errors.Wrap(err, "failed to process create")
+				if err := d.processModify(path.(string), command); err != nil {
+					return errors.Wrap(err, "failed to process create")
 				}
 				continue
 			}
 
-			return nil, errors.Errorf("unhandled command %s", command.Type.Name)
+			return errors.Errorf("unhandled command %s", command.Type.Name)
 		}
 	}
 
-	return diff, nil
+	return nil
 }
 
 type Diff struct {
 	root *DiffNode
+
+	// Meta contains the info of the subvolume/snapshot received in the last processed stream
+	Meta *DiffMeta
+	// Kind tells whether the (first) stream has been sent with a parent or not
+	Kind DiffKind
+	// StreamVersion is the send stream protocol version of the last processed stream
+	StreamVersion uint32
+	// Warnings collects the soft failures which did not stop the processing, but may make
+	// parts of the diff ambiguous
+	Warnings []*DiffWarning
+	// Stopped tells whether the processing has been stopped early by Processor.StopAfter, in which
+	// case the diff only reflects the commands processed until then
+	Stopped bool
+	// CommandCount is the amount of commands processed, across all the streams (ignored ones included)
+	CommandCount int
+	// StreamCount is the amount of streams processed, the last one included even if stopped early
+	StreamCount int
+	// RootLabel is how the root node is shown in all the outputs (see DiffNode.DisplayPath), "/" if empty
+	RootLabel DiffRootLabel
+	// The info of the first processed stream, whose parent is the base of the whole diff
+	firstMeta *DiffMeta
+	// The nodes written by the stream being processed, see renderWrites
+	pendingWrites []*DiffNode
+}
+
+type DiffWarningCode = string
+
+const (
+	DiffWarningCodeLinkDestNotFound  DiffWarningCode = "LINK_DEST_NOT_FOUND"
+	DiffWarningCodeRenameSrcNotFound DiffWarningCode = "RENAME_SRC_NOT_FOUND"
+	// A node has been added on top of an existing live one, see Processor.MergeConflictingNodes
+	DiffWarningCodeNodeConflict DiffWarningCode = "NODE_CONFLICT"
+	// A deletion targets a node without a parent (e.g. the root), which is ignored
+	DiffWarningCodeDeleteWithoutParent DiffWarningCode = "DELETE_WITHOUT_PARENT"
+)
+
+type DiffWarning struct {
+	Code    DiffWarningCode `json:"code"`
+	Path    string          `json:"path"`
+	Message string          `json:"message"`
+}
+
+// warn records a warning, also logging it
+func (d *Diff) warn(code DiffWarningCode, path string, msg string, params ...interface{}) {
+	w := &DiffWarning{
+		Code:    code,
+		Path:    path,
+		Message: fmt.Sprintf(msg, params...),
+	}
+	d.Warnings = append(d.Warnings, w)
+	d.proc().info("warning: %s", w.Message)
+}
+
+type DiffMeta struct {
+	Path          string `json:"path"`
+	UUID          string `json:"uuid"`
+	CTransID      uint64 `json:"ctransid"`
+	CloneUUID     string `json:"clone_uuid,omitempty"`
+	CloneCTransID uint64 `json:"clone_ctransid,omitempty"`
+	// Creation time of the subvolume, only if the stream has it
+	OTime *time.Time `json:"otime,omitempty"`
+}
+
+// readMeta reads the params of a SUBVOL or SNAPSHOT command
+func readMeta(command *commandInst) (*DiffMeta, error) {
+	// The kernel does not send the creation time of the subvolume, but other tools could, in any order
+	if err := command.ReadAllParams(); err != nil {
+		return nil, errors.Wrap(err, "failed to read params")
+	}
+	path, err := command.Param(BTRFS_SEND_A_PATH)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read path param")
+	}
+	uuid, err := command.Param(BTRFS_SEND_A_UUID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read uuid param")
+	}
+	ctransid, err := command.Param(BTRFS_SEND_A_CTRANSID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read ctransid param")
+	}
+	meta := &DiffMeta{
+		Path:     path.(string),
+		UUID:     uuid.(string),
+		CTransID: ctransid.(uint64),
+	}
+	if otime, err := command.Param(BTRFS_SEND_A_OTIME); err == nil {
+		t := otime.(time.Time)
+		meta.OTime = &t
+	}
+	if command.OriginalType == BTRFS_SEND_C_SNAPSHOT {
+		cloneUUID, err := command.Param(BTRFS_SEND_A_CLONE_UUID)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read clone uuid param")
+		}
+		cloneCTransid, err := command.Param(BTRFS_SEND_A_CLONE_CTRANSID)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read clone ctransid param")
+		}
+		meta.CloneUUID = cloneUUID.(string)
+		meta.CloneCTransID = cloneCTransid.(uint64)
+	}
+	return meta, nil
+}
+
+type DiffKind = string
+
+const (
+	// A full send of a subvolume
+	DiffKindFull DiffKind = "full"
+	// An incremental send, which has to be applied on top of its parent
+	DiffKindIncremental DiffKind = "incremental"
+)
+
+// kind guesses the kind of a stream from its first command: only incremental streams have a parent
+func (m *DiffMeta) kind() DiffKind {
+	if strings.Trim(m.CloneUUID, "0") == "" {
+		return DiffKindFull
+	}
+	return DiffKindIncremental
+}
+
+type DiffMetaJSON struct {
+	*DiffMeta
+	StreamVersion uint32   `json:"stream_version"`
+	Kind          DiffKind `json:"kind,omitempty"`
+	CommandCount  int      `json:"command_count"`
 }
 
 type DiffIgnorePaths []*regexp.Regexp
@@ -269,9 +679,15 @@ func (p DiffIgnorePaths) Matches(f *DiffNode) bool {
 }
 
 type DiffJSONStruct struct {
-	Added   []*DiffNode `json:"added"`
-	Changed []*DiffNode `json:"changed"`
-	Deleted []*DiffNode `json:"deleted"`
+	Meta     *DiffMetaJSON  `json:"meta"`
+	Added    []*DiffNode    `json:"added"`
+	Changed  []*DiffNode    `json:"changed"`
+	Deleted  []*DiffNode    `json:"deleted"`
+	Warnings []*DiffWarning `json:"warnings,omitempty"`
+	// Only defined when pairing moves, see PairMoves
+	Moved []*DiffMoveEntry `json:"moved,omitempty"`
+	// Only defined when paginating
+	Total *int `json:"total,omitempty"`
 }
 
 func shouldPrintNode(n *DiffNode) bool {
@@ -284,28 +700,63 @@ func shouldPrintNode(n *DiffNode) bool {
 	return false
 }
 
-func (d *Diff) print(ignorePaths DiffIgnorePaths) {
-	info("=== Tree ===")
+func (d *Diff) print(w io.Writer, ignore DiffNodeMatcher, args *ProcessFileWithOutputArgs) error {
+	var nodes []*DiffNode
 	d.root.traverse(func(f *DiffNode) {
-		if ignorePaths.Matches(f) {
+		if isIgnored(ignore, f) {
 			return
 		}
 
+		if shouldPrintNode(f) || (f.DeletedInSnapshot && f.State != opDelete) {
+			nodes = append(nodes, f)
+		}
+	})
+	if err := SortDiffNodes(nodes, args.SortBy); err != nil {
+		return errors.Wrap(err, "failed to sort nodes")
+	}
+	d.proc().debug("printing %d nodes", len(nodes))
+
+	var sb strings.Builder
+	sb.WriteString("=== Tree ===\n")
+	for _, f := range nodes {
 		if shouldPrintNode(f) {
-			info(f.String())
+			sb.WriteString(f.string(args.RelativeTimeRef, args.ShowInode, args.DecodeACLs) + "\n")
 		}
 
 		if f.DeletedInSnapshot && f.State != opDelete {
-			info(f.StringForDeleted())
+			sb.WriteString(f.StringForDeleted() + "\n")
 		}
-	})
+	}
+
+	if args.Top > 0 {
+		sb.WriteString(fmt.Sprintf("=== Top %d by bytes written ===\n", args.Top))
+		for _, f := range d.TopWritten(ignore, args.Top) {
+			sb.WriteString(fmt.Sprintf("%10s %s\n", formatBytes(f.TotalBytesWritten(), args.Bytes), f.GetChainPath()))
+		}
+	}
+
+	if len(d.Warnings) > 0 {
+		sb.WriteString("=== Warnings ===\n")
+		for _, w := range d.Warnings {
+			sb.WriteString(fmt.Sprintf("[%s] %s\n", w.Code, w.Message))
+		}
+	}
+
+	_, err := io.WriteString(w, sb.String())
+	return err
 }
 
-func (d *Diff) GetDiffStruct(ignorePaths DiffIgnorePaths) *DiffJSONStruct {
-	s := &DiffJSONStruct{}
+// GetDiffStruct splits the reportable nodes by bucket. Each path is in at most one bucket, except for
+// the paths deleted and created again, whose new node is in both the added and the deleted ones (see
+// DiffNode.DeletedInSnapshot), so that consumers see both the removal and the creation.
+func (d *Diff) GetDiffStruct(ignore DiffNodeMatcher) *DiffJSONStruct {
+	s := &DiffJSONStruct{
+		Meta:     &DiffMetaJSON{d.Meta, d.StreamVersion, d.Kind, d.CommandCount},
+		Warnings: d.Warnings,
+	}
 
 	d.root.traverse(func(f *DiffNode) {
-		if ignorePaths.Matches(f) {
+		if isIgnored(ignore, f) {
 			return
 		}
 
@@ -329,16 +780,101 @@ func (d *Diff) GetDiffStruct(ignorePaths DiffIgnorePaths) *DiffJSONStruct {
 	return s
 }
 
-func (d *Diff) printJSON(ignorePaths DiffIgnorePaths) (string, error) {
-	s := d.GetDiffStruct(ignorePaths)
+// FlatMap returns all the reportable nodes of the diff, keyed by their full chain path.
+// Chain paths are unique in the tree, so no collisions can happen: a node which has been
+// both deleted and re-created in the snapshot is returned only once.
+func (d *Diff) FlatMap(ignore DiffNodeMatcher) map[string]*DiffNode {
+	m := make(map[string]*DiffNode)
+
+	d.root.traverse(func(f *DiffNode) {
+		if isIgnored(ignore, f) {
+			return
+		}
+
+		if shouldPrintNode(f) {
+			m[f.GetChainPath()] = f
+		}
+	})
+
+	return m
+}
 
-	b, err := json.Marshal(s)
+// TopWritten returns up to n reportable files with the most bytes written, most written first
+func (d *Diff) TopWritten(ignore DiffNodeMatcher, n int) []*DiffNode {
+	var nodes []*DiffNode
+	for _, f := range d.FlatMap(ignore) {
+		if f.NodeType == DiffNodeTypeFile && f.TotalBytesWritten() > 0 {
+			nodes = append(nodes, f)
+		}
+	}
+	_ = SortDiffNodes(nodes, DiffSortByBytes)
+	if len(nodes) > n {
+		nodes = nodes[:n]
+	}
+	return nodes
+}
+
+// CheckTypes returns an error listing all the reportable nodes whose type could not be resolved
+func (d *Diff) CheckTypes(ignore DiffNodeMatcher) error {
+	var unknown []string
+	for p, f := range d.FlatMap(ignore) {
+		if f.NodeType == DiffNodeTypeUnknown {
+			unknown = append(unknown, p)
+		}
+	}
+	if len(unknown) == 0 {
+		return nil
+	}
+	sort.Strings(unknown)
+	return errors.Errorf("found %d nodes with unknown type: %s", len(unknown), strings.Join(unknown, ", "))
+}
+
+func (d *Diff) printJSON(ignore DiffNodeMatcher, args *ProcessFileWithOutputArgs) (string, error) {
+	s, err := d.outputStruct(ignore, args)
+	if err != nil {
+		return "", err
+	}
+
+	var b []byte
+	if args.JSONStyle == JSONStylePretty {
+		b, err = json.MarshalIndent(s, "", "  ")
+	} else {
+		b, err = json.Marshal(s)
+	}
 	if err != nil {
 		return "", errors.Wrap(err, "failed to marshal diff")
 	}
 	return string(b), nil
 }
 
+// outputStruct returns the diff struct of the structured outputs (e.g. json), with moves, sorting,
+// filtering by operation and pagination applied as requested by args
+func (d *Diff) outputStruct(ignore DiffNodeMatcher, args *ProcessFileWithOutputArgs) (*DiffJSONStruct, error) {
+	s := d.GetDiffStruct(ignore)
+	if args.Moves {
+		s.PairMoves()
+	}
+	if args.Op != "" {
+		s.OnlyBucket(args.Op)
+	}
+
+	paginate := args.Offset > 0 || args.Count > 0
+	sortBy := args.SortBy
+	if paginate && sortBy == DiffSortByNone {
+		// Pages need a stable order
+		sortBy = DiffSortByPath
+	}
+	if err := s.Sort(sortBy); err != nil {
+		return nil, errors.Wrap(err, "failed to sort diff")
+	}
+	if paginate {
+		s.Paginate(args.Offset, args.Count)
+	}
+	return s, nil
+}
+
+// Stream paths are always separated by "/", regardless of the host OS, so they are never handled with
+// path/filepath, which is only meant for the names of the local stream files
 func (d *Diff) getNodeByPath(path string) *DiffNode {
 	entries := strings.Split(path, "/")
 	if entries[0] == "" {
@@ -372,8 +908,17 @@ func (d *Diff) getNodeParentOrMkdir(path string) *DiffNode {
 
 func (d *Diff) processCreate(path string, command *commandInst) error {
 	node := d.getNodeByPath(path)
-	if node != nil {
-		return errors.Errorf("found existing node in tree while processing create operation")
+	// A node can be touched (e.g. by UTIMES, or as the parent of another node) before being created,
+	// in which case it is only a placeholder, and is promoted to the created node
+	placeholder := node != nil && !node.CreatedInSnapshot && (node.State == opModify || node.State == opUnspec)
+	// A deleted node is replaced by the new one, see addNode
+	replace := node != nil && node.State == opDelete
+	if node != nil && !placeholder && !replace {
+		if !d.proc().MergeConflictingNodes {
+			return errors.Errorf("found existing node in tree while processing create operation")
+		}
+		d.warn(DiffWarningCodeNodeConflict, path, "found existing node %s while processing create operation, merging them", path)
+		placeholder = true
 	}
 
 	var nodeType DiffNodeType
@@ -394,7 +939,10 @@ func (d *Diff) processCreate(path string, command *commandInst) error {
 		return errors.Errorf("unsupported command for create operation: %s", command.Type.Name)
 	}
 
-	if nodeType == DiffNodeTypeDir {
+	if placeholder {
+		node.NodeType = nodeType
+		node.State = opCreate
+	} else if nodeType == DiffNodeTypeDir && !replace {
 		node = d.root.mkdirp(path, false, true)
 	} else {
 		parent := d.getNodeParentOrMkdir(path)
@@ -402,20 +950,43 @@ func (d *Diff) processCreate(path string, command *commandInst) error {
 			Path:     getLastPathPart(path),
 			NodeType: nodeType,
 			State:    opCreate,
+			Children: make(map[string]*DiffNode),
 		}
 		if err := parent.addNode(node); err != nil {
 			return errors.Wrapf(err, "failed to add node %s to parent %s", node.Path, parent.GetChainPath())
 		}
 	}
 
-	if command.OriginalType == BTRFS_SEND_C_SYMLINK {
-		{
-			_, err := command.ReadParam(BTRFS_SEND_A_INO)
-			if err != nil {
-				return errors.Wrap(err, "failed to read ino link param")
-			}
+	node.CreatedInSnapshot = true
+	d.touch(node)
+
+	ino, err := command.ReadParam(BTRFS_SEND_A_INO)
+	if err != nil {
+		return errors.Wrap(err, "failed to read ino param")
+	}
+	node.Ino = ino.(uint64)
+
+	switch command.OriginalType {
+	case BTRFS_SEND_C_MKNOD, BTRFS_SEND_C_MKFIFO, BTRFS_SEND_C_MKSOCK:
+		// The kernel emits the rdev before the mode, but the order is not part of the protocol
+		if err := command.ReadAllParams(); err != nil {
+			return errors.Wrap(err, "failed to read device params")
+		}
+		mode, err := command.Param(BTRFS_SEND_A_MODE)
+		if err != nil {
+			return errors.Wrap(err, "failed to read mode param")
+		}
+		rdev, err := command.Param(BTRFS_SEND_A_RDEV)
+		if err != nil {
+			return errors.Wrap(err, "failed to read rdev param")
+		}
+		if command.OriginalType == BTRFS_SEND_C_MKNOD && d.proc().Overlay && isOverlayWhiteout(mode.(uint64), rdev.(uint64)) {
+			node.markWhiteout()
+			d.proc().info("whiteout at %s", path)
+			return nil
 		}
 
+	case BTRFS_SEND_C_SYMLINK:
 		pathLink, err := command.ReadParam(BTRFS_SEND_A_PATH_LINK)
 		if err != nil {
 			return errors.Wrap(err, "failed to read path link param")
@@ -425,7 +996,7 @@ func (d *Diff) processCreate(path string, command *commandInst) error {
 
 		linkDestination := d.getNodeByPath(pathLink.(string))
 		if linkDestination == nil {
-			info("link %s destination not found", pathLink.(string))
+			d.warn(DiffWarningCodeLinkDestNotFound, path, "link %s destination %s not found", path, pathLink.(string))
 			// NOTE: we CANNOT add this to the tree as of now, relative paths and so on to deal with
 			linkDestination = &DiffNode{
 				NodeType: DiffNodeTypeUnknown,
@@ -437,7 +1008,7 @@ func (d *Diff) processCreate(path string, command *commandInst) error {
 		node.Relations = append(node.Relations, &DiffNodeRelation{linkDestination, DiffNodeReasonLinkDest})
 	}
 
-	info("created %s [type=%s]", path, node.NodeType)
+	d.proc().info("created %s [type=%s]", path, node.NodeType)
 	return nil
 }
 
@@ -458,10 +1029,13 @@ func (d *Diff) processModify(path string, command *commandInst) error {
 	if node.State != opCreate {
 		node.State = opModify
 	}
+	d.touch(node)
 
 	switch command.OriginalType {
 	case BTRFS_SEND_C_WRITE:
 		fallthrough
+	case BTRFS_SEND_C_ENCODED_WRITE:
+		fallthrough
 	case BTRFS_SEND_C_UPDATE_EXTENT:
 		offset, err := command.ReadParam(BTRFS_SEND_A_FILE_OFFSET)
 		if err != nil {
@@ -470,6 +1044,7 @@ func (d *Diff) processModify(path string, command *commandInst) error {
 
 		var dataLen uint64
 		var logSuffix string
+		var compression DiffCompression
 
 		if command.OriginalType == BTRFS_SEND_C_WRITE {
 			sentData, err := command.ReadParam(BTRFS_SEND_A_DATA)
@@ -478,33 +1053,72 @@ func (d *Diff) processModify(path string, command *commandInst) error {
 			}
 			dataLen = uint64(len(sentData.(*bytesData).bytes))
 			logSuffix = fmt.Sprintf(": %s", sentData)
+			if offset.(uint64) == 0 {
+				node.shebang = bytes.HasPrefix(sentData.(*bytesData).bytes, []byte("#!"))
+			}
 		} else if command.OriginalType == BTRFS_SEND_C_UPDATE_EXTENT {
 			size, err := command.ReadParam(BTRFS_SEND_A_SIZE)
 			if err != nil {
 				return errors.Wrap(err, "failed to read written size param")
 			}
 			dataLen = size.(uint64)
+		} else if command.OriginalType == BTRFS_SEND_C_ENCODED_WRITE {
+			// The compression and encryption are optional, defaulting to none
+			if err := command.ReadAllParams(); err != nil {
+				return errors.Wrap(err, "failed to read encoded write params")
+			}
+			fileLen, err := command.Param(BTRFS_SEND_A_UNENCODED_FILE_LEN)
+			if err != nil {
+				return errors.Wrap(err, "failed to read encoded write file length param")
+			}
+			// The data is compressed, so only the length of the file range it fills matters
+			dataLen = fileLen.(uint64)
+			compression = compressionName(BTRFS_ENCODED_IO_COMPRESSION_NONE)
+			if value, err := command.Param(BTRFS_SEND_A_COMPRESSION); err == nil {
+				compression = compressionName(value.(uint32))
+			}
+			logSuffix = fmt.Sprintf(" [compression=%s]", compression)
 		} else {
 			return errors.Errorf("unhandled write command %s", command.Type.Name)
 		}
+		node.written = node.written.add(offset.(uint64), dataLen)
+		node.addExtent(&DiffExtent{Kind: DiffExtentKindWrite, Offset: offset.(uint64), Len: dataLen, Compression: compression})
 
-		if len(node.Changes) > 0 {
-			lastChange := node.Changes[len(node.Changes)-1]
-			// Concat multiple writes
-			if strings.HasPrefix(lastChange, "write:") && node.lastDataWrittenOffset+node.lastDataWrittenLen == offset {
-				node.Changes = node.Changes[:len(node.Changes)-1]
-				offset = node.lastDataWrittenOffset
-				dataLen = dataLen + node.lastDataWrittenLen
-			}
+		if node.NodeType == DiffNodeTypeUnknown {
+			node.NodeType = DiffNodeTypeFile
+		}
+		d.markWritten(node)
+		d.proc().info("modified: write at %s at %v%s", path, offset, logSuffix)
+	case BTRFS_SEND_C_CLONE:
+		offset, err := command.Param(BTRFS_SEND_A_FILE_OFFSET)
+		if err != nil {
+			return errors.Wrap(err, "failed to read clone offset param")
+		}
+		cloneLen, err := command.Param(BTRFS_SEND_A_CLONE_LEN)
+		if err != nil {
+			return errors.Wrap(err, "failed to read clone len param")
+		}
+		clonePath, err := command.Param(BTRFS_SEND_A_CLONE_PATH)
+		if err != nil {
+			return errors.Wrap(err, "failed to read clone path param")
+		}
+		cloneOffset, err := command.Param(BTRFS_SEND_A_CLONE_OFFSET)
+		if err != nil {
+			return errors.Wrap(err, "failed to read clone source offset param")
 		}
 
 		if node.NodeType == DiffNodeTypeUnknown {
 			node.NodeType = DiffNodeTypeFile
 		}
-		node.Changes = append(node.Changes, fmt.Sprintf("write:offset=%d:data_len=%d", offset, dataLen))
-		node.lastDataWrittenOffset = offset.(uint64)
-		node.lastDataWrittenLen = dataLen
-		info("modified: write at %s at %v%s", path, offset, logSuffix)
+		node.addExtent(&DiffExtent{
+			Kind:            DiffExtentKindClone,
+			Offset:          offset.(uint64),
+			Len:             cloneLen.(uint64),
+			CloneSourcePath: clonePath.(string),
+			CloneOffset:     cloneOffset.(uint64),
+		})
+		node.Changes = append(node.Changes, fmt.Sprintf("clone:offset=%d:len=%d:src=%s:src_offset=%d", offset, cloneLen, clonePath, cloneOffset))
+		d.proc().info("modified: clone at %s at %d [len=%d,src=%s,src_offset=%d]", path, offset, cloneLen, clonePath, cloneOffset)
 	case BTRFS_SEND_C_TRUNCATE:
 		size, err := command.ReadParam(BTRFS_SEND_A_SIZE)
 		if err != nil {
@@ -515,7 +1129,10 @@ func (d *Diff) processModify(path string, command *commandInst) error {
 			node.NodeType = DiffNodeTypeFile
 		}
 		node.Changes = append(node.Changes, fmt.Sprintf("truncate:size=%d", size))
-		info("modified: trucate at %s [size=%d]", path, size)
+		finalSize := size.(uint64)
+		node.Size = &finalSize
+		node.truncates = append(node.truncates, extentTruncate{len(node.Extents), finalSize})
+		d.proc().info("modified: trucate at %s [size=%d]", path, size)
 	case BTRFS_SEND_C_UTIMES:
 		atime, err := command.ReadParam(BTRFS_SEND_A_ATIME)
 		if err != nil {
@@ -530,15 +1147,28 @@ func (d *Diff) processModify(path string, command *commandInst) error {
 			return errors.Wrap(err, "failed to read ctime param")
 		}
 
-		node.Changes = append(node.Changes, fmt.Sprintf("utime:atime=%s,mtime=%s,ctime=%s", atime, mtime, ctime))
-		info("modified: utimes at %s [atime=%s,mtime=%s,ctime=%s]", path, atime, mtime, ctime)
+		// Only the latest timestamps matter, so we replace any previous utime change
+		for idx, change := range node.Changes {
+			if strings.HasPrefix(change, "utime:") {
+				node.Changes = append(node.Changes[:idx], node.Changes[idx+1:]...)
+				break
+			}
+		}
+		node.Times = &DiffNodeTimes{
+			ATime: atime.(time.Time),
+			MTime: mtime.(time.Time),
+			CTime: ctime.(time.Time),
+		}
+		node.Changes = append(node.Changes, node.Times.change(nil))
+		d.proc().info("modified: utimes at %s [atime=%s,mtime=%s,ctime=%s]", path, atime, mtime, ctime)
 	case BTRFS_SEND_C_CHMOD:
 		mode, err := command.ReadParam(BTRFS_SEND_A_MODE)
 		if err != nil {
 			return errors.Wrap(err, "failed to read mode param")
 		}
 		node.Changes = append(node.Changes, fmt.Sprintf("chmod:mode=%o", mode))
-		info("modified: chmod at %s [chmod=%o]", path, mode)
+		node.applyMode(mode.(uint64))
+		d.proc().info("modified: chmod at %s [chmod=%o]", path, mode)
 	case BTRFS_SEND_C_CHOWN:
 		uid, err := command.ReadParam(BTRFS_SEND_A_UID)
 		if err != nil {
@@ -549,7 +1179,9 @@ func (d *Diff) processModify(path string, command *commandInst) error {
 			return errors.Wrap(err, "failed to read gid param")
 		}
 		node.Changes = append(node.Changes, fmt.Sprintf("chown:uid=%d,gid=%d", uid, gid))
-		info("modified: chown at %s [uid=%d,gid=%d]", path, uid, gid)
+		owner := uid.(uint64)
+		node.uid = &owner
+		d.proc().info("modified: chown at %s [uid=%d,gid=%d]", path, uid, gid)
 	case BTRFS_SEND_C_SET_XATTR:
 		xattrName, err := command.ReadParam(BTRFS_SEND_A_XATTR_NAME)
 		if err != nil {
@@ -559,30 +1191,97 @@ func (d *Diff) processModify(path string, command *commandInst) error {
 		if err != nil {
 			return errors.Wrap(err, "failed to read xattrData param")
 		}
+		if d.proc().Overlay && isOverlayOpaque(xattrName.(string), xattrData.(*bytesData).bytes) {
+			node.Opaque = true
+			node.Changes = append(node.Changes, fmt.Sprintf("opaque:name=%s", xattrName))
+			d.proc().info("modified: opaque dir at %s", path)
+			break
+		}
 		node.Changes = append(node.Changes, fmt.Sprintf("set_xattr:name=%s,data=%v", xattrName, xattrData))
-		info("modified: set xattr at %s [name=%s,data=%v]", path, xattrName, xattrData)
+		node.Xattrs = append(node.Xattrs, newDiffXattrSet(xattrName.(string), xattrData.(*bytesData).bytes))
+		d.proc().info("modified: set xattr at %s [name=%s,data=%v]", path, xattrName, xattrData)
 	case BTRFS_SEND_C_REMOVE_XATTR:
 		xattrName, err := command.ReadParam(BTRFS_SEND_A_XATTR_NAME)
 		if err != nil {
 			return errors.Wrap(err, "failed to read xattrName param")
 		}
 		node.Changes = append(node.Changes, fmt.Sprintf("remove_xattr:name=%s", xattrName))
-		info("modified: remove xattr at %s [name=%s]", path, xattrName)
+		node.Xattrs = append(node.Xattrs, &DiffXattrChange{Op: DiffXattrOpRemove, Name: xattrName.(string)})
+		d.proc().info("modified: remove xattr at %s [name=%s]", path, xattrName)
 	default:
 		return errors.Errorf("unhandled modify command %s", command.Type.Name)
 	}
 	return nil
 }
 
+// markWritten records that the node has been written by the stream being processed, adding a write
+// change (at the position of the first write) which is rendered by renderWrites
+func (d *Diff) markWritten(n *DiffNode) {
+	if n.writesPending {
+		return
+	}
+	n.writesPending = true
+	d.pendingWrites = append(d.pendingWrites, n)
+	for _, change := range n.Changes {
+		if strings.HasPrefix(change, DiffChangeKindWrite+":") {
+			return
+		}
+	}
+	n.Changes = append(n.Changes, DiffChangeKindWrite+":")
+}
+
+// renderWrites replaces the write changes of the nodes written by the stream with their merged written
+// ranges, at the position of the first write. Writes can be out of order or overlapping, so they are
+// only rendered once the whole stream has been processed.
+func (d *Diff) renderWrites() {
+	for _, n := range d.pendingWrites {
+		var changes []string
+		writesAdded := false
+		for _, change := range n.Changes {
+			if !strings.HasPrefix(change, DiffChangeKindWrite+":") {
+				changes = append(changes, change)
+				continue
+			}
+			if !writesAdded {
+				for _, r := range n.written {
+					changes = append(changes, fmt.Sprintf("write:offset=%d:data_len=%d", r.Start, r.Len()))
+				}
+				writesAdded = true
+			}
+		}
+		n.Changes = changes
+		n.writesPending = false
+	}
+	d.pendingWrites = nil
+}
+
 // These are the tmp nodes generated before actual inode linking
 // They only create noise
 var regexNewNode = regexp.MustCompile(`o\d+-\d+-\d+`)
 
 func (d *Diff) processRenameOrLink(from, to string, command *commandInst) error {
+	if from == to {
+		// Nothing changes, while processing it would delete the node and replace it with itself
+		d.proc().info("ignoring %s of %s onto itself", command.Type.Name, from)
+		return nil
+	}
+
 	pathFromIsNewNode := regexNewNode.MatchString(from)
 
 	nodeSrc := d.getNodeByPath(from)
-	if nodeSrc == nil && !pathFromIsNewNode {
+
+	// The children of a deleted directory renamed to a btrfs temporary name (orphanized) can be
+	// orphanized too before being deleted (e.g. by `rm -rf`), and are then tracked like the children
+	// of the original directory
+	fromOrphanizedDir := false
+	if nodeSrc == nil && pathFromIsNewNode && command.OriginalType == BTRFS_SEND_C_RENAME {
+		if i := strings.LastIndex(from, "/"); i >= 0 && !regexNewNode.MatchString(from[i+1:]) {
+			parent := d.getNodeByPath(from[:i])
+			fromOrphanizedDir = parent != nil && parent.findRelation(DiffNodeReasonRenameSrc) != nil
+		}
+	}
+
+	if nodeSrc == nil && (!pathFromIsNewNode || fromOrphanizedDir) {
 		// Create a fake node as source
 		nodeSrc = &DiffNode{
 			NodeType: DiffNodeTypeUnknown,
@@ -591,7 +1290,10 @@ func (d *Diff) processRenameOrLink(from, to string, command *commandInst) error
 		}
 
 		if command.OriginalType == BTRFS_SEND_C_RENAME {
-			parent := d.getNodeParentOrMkdir(to)
+			// Under the parent of the source path: under the destination parent, a source of the same name
+			// would collide with the destination, and one of a different name would report a path which
+			// never existed
+			parent := d.getNodeParentOrMkdir(from)
 			if err := parent.addNode(nodeSrc); err != nil {
 				return errors.Wrapf(err, "failed to add fake node %s to parent %s", nodeSrc.GetChainPath(), parent.GetChainPath())
 			}
@@ -599,7 +1301,7 @@ func (d *Diff) processRenameOrLink(from, to string, command *commandInst) error
 	}
 
 	if nodeSrc == nil {
-		debug("could not found source node %s for %s command", from, command.Type.Name)
+		d.warn(DiffWarningCodeRenameSrcNotFound, from, "could not find source node %s for %s command", from, command.Type.Name)
 	}
 
 	nodeType := DiffNodeTypeUnknown
@@ -609,8 +1311,15 @@ func (d *Diff) processRenameOrLink(from, to string, command *commandInst) error
 	if nodeSrc != nil {
 		nodeType = nodeSrc.NodeType
 		relations = nodeSrc.Relations
-		for key, val := range nodeSrc.Children {
-			children[key] = val
+		// The children move along with a renamed node, except for the deleted ones, which are still
+		// reported at its previous path. Links cannot have any (only files can be linked).
+		if command.OriginalType == BTRFS_SEND_C_RENAME {
+			for key, val := range nodeSrc.Children {
+				if val.State != opDelete {
+					children[key] = val
+					delete(nodeSrc.Children, key)
+				}
+			}
 		}
 
 		// Only mark the source node as deleted if there is a rename.
@@ -621,7 +1330,7 @@ func (d *Diff) processRenameOrLink(from, to string, command *commandInst) error
 			}
 		}
 
-		if !pathFromIsNewNode {
+		if !pathFromIsNewNode || fromOrphanizedDir {
 			if command.OriginalType == BTRFS_SEND_C_RENAME {
 				relations = append(relations, &DiffNodeRelation{nodeSrc, DiffNodeReasonRenameSrc})
 			} else if command.OriginalType == BTRFS_SEND_C_LINK {
@@ -638,16 +1347,30 @@ func (d *Diff) processRenameOrLink(from, to string, command *commandInst) error
 		Children:  children,
 		State:     opCreate,
 	}
+	if nodeSrc != nil {
+		// Both renames and links keep the inode
+		nodeTo.Ino = nodeSrc.Ino
+	}
+	if nodeSrc != nil && command.OriginalType == BTRFS_SEND_C_RENAME {
+		nodeTo.CreatedInSnapshot = nodeSrc.CreatedInSnapshot
+		if nodeSrc.Whiteout {
+			nodeTo.markWhiteout()
+		}
+	}
 	if err := parent.addNode(nodeTo); err != nil {
-		return errors.Wrapf(err, "failed to add node %s to renamed node destination parent %s", nodeSrc.GetChainPath(), parent.GetChainPath())
+		return errors.Wrapf(err, "failed to add node %s to renamed node destination parent %s", to, parent.GetChainPath())
 	}
+	for _, child := range nodeTo.Children {
+		child.Parent = nodeTo
+	}
+	d.touch(nodeTo)
 	if nodeSrc != nil {
 		if command.OriginalType == BTRFS_SEND_C_RENAME {
 			nodeSrc.Relations = append(nodeSrc.Relations, &DiffNodeRelation{nodeTo, DiffNodeReasonRenameDest})
 		}
 	}
 
-	info("rename from %s to %s", from, to)
+	d.proc().info("rename from %s to %s", from, to)
 	return nil
 }
 
@@ -666,6 +1389,10 @@ func (d *Diff) processDelete(path string, command *commandInst) error {
 			return errors.Wrapf(err, "failed to add deleted node %s to parent %s", node.GetChainPath(), parent)
 		}
 	}
+	if node.Parent == nil {
+		d.warn(DiffWarningCodeDeleteWithoutParent, path, "could not find the parent of node %s for %s command", path, command.Type.Name)
+		return nil
+	}
 
 	if node.NodeType == DiffNodeTypeUnknown {
 		if command.OriginalType == BTRFS_SEND_C_RMDIR {
@@ -675,14 +1402,36 @@ func (d *Diff) processDelete(path string, command *commandInst) error {
 
 	node.State = opDelete
 	node.DeletedInSnapshot = true
+	node.DeleteCause = deleteCauses[command.OriginalType]
+	d.touch(node)
+
+	// Deleted nodes are usually first renamed to a btrfs temporary name (orphanized), so the original
+	// nodes have been really deleted, and not just renamed
+	var renamedFrom []*DiffNode
+	if regexNewNode.MatchString(node.Path) {
+		for rel := node.findRelation(DiffNodeReasonRenameSrc); rel != nil; rel = rel.Node.findRelation(DiffNodeReasonRenameSrc) {
+			renamedFrom = append(renamedFrom, rel.Node)
+			d.touch(rel.Node)
+			if rel.Node.DeleteCause == DiffDeleteCauseRename {
+				rel.Node.DeleteCause = node.DeleteCause
+			}
+			if rel.Node.NodeType == DiffNodeTypeUnknown {
+				rel.Node.NodeType = node.NodeType
+			}
+		}
+	}
 
 	// If the node parent is a btrfs temporary folder, then move this file under the rightful owner
 	if regexNewNode.MatchString(node.Parent.Path) {
 		renameSrc := node.Parent.followRenameChainSrc()
-		if renameSrc != nil {
+		// The rename source may have been removed from the tree already, e.g. when its own parent has
+		// been deleted first, in which case the node is left under the temporary folder
+		if renameSrc != nil && renameSrc.isAttached() {
 			if nodeInSrc, ok := renameSrc.Children[node.Path]; ok {
 				// We just mark that node as deleted in this snapshot and treat the current node as never existed
 				nodeInSrc.DeletedInSnapshot = true
+				nodeInSrc.DeleteCause = deleteCauses[command.OriginalType]
+				d.touch(nodeInSrc)
 
 				if command.OriginalType == BTRFS_SEND_C_RMDIR {
 					nodeInSrc.NodeType = DiffNodeTypeDir
@@ -691,7 +1440,7 @@ func (d *Diff) processDelete(path string, command *commandInst) error {
 				if err := node.removeFromParent(); err != nil {
 					return errors.Wrapf(err, "failed to remove deleted node (ignored) %s from parent", node)
 				}
-				return nil
+				return d.checkTripwire(command, nodeInSrc)
 			}
 
 			if err := renameSrc.addNode(node); err != nil {
@@ -700,6 +1449,6 @@ func (d *Diff) processDelete(path string, command *commandInst) error {
 		}
 	}
 
-	info("deleted %s", node)
-	return nil
+	d.proc().info("deleted %s", node)
+	return d.checkTripwire(command, append(renamedFrom, node)...)
 }
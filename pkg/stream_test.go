@@ -0,0 +1,777 @@
+package pkg_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/cmaster11/btrfs-diff/internal/streamtest"
+	"github.com/cmaster11/btrfs-diff/pkg"
+	"github.com/stretchr/testify/require"
+	"io"
+	"log"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStreamBuilder(t *testing.T) {
+	// Renaming a new file onto an existing one: btrfs first moves the existing file
+	// out of the way, and deletes it only after the new one has taken its place
+	stream, err := newTestStream().
+		Rename("foo", "o259-7-0").
+		MkFile("o260-7-0", 260).
+		Rename("o260-7-0", "foo").
+		Unlink("o259-7-0").
+		Write("foo", 0, []byte("hello")).
+		Write("foo", 5, []byte(" world")).
+		End().
+		Bytes()
+	require.NoError(t, err)
+
+	diff, err := pkg.ProcessBTRFSStream(bytes.NewReader(stream))
+	require.NoError(t, err)
+	require.Equal(t, "002", diff.Meta.Path)
+
+	diffStr := diff.GetDiffStruct(nil)
+
+	require.Len(t, diffStr.Added, 1)
+	require.Equal(t, "/foo", diffStr.Added[0].GetChainPath())
+	require.EqualValues(t, 11, diffStr.Added[0].TotalBytesWritten())
+	require.Len(t, diffStr.Deleted, 1)
+	require.Equal(t, "/foo", diffStr.Deleted[0].GetChainPath())
+	require.Empty(t, diffStr.Changed)
+}
+
+func TestIgnoreDirMetadataChanges(t *testing.T) {
+	stream, err := newTestStream().
+		Chmod("dir", 0755).
+		Write("dir/file", 0, []byte("foo")).
+		MkDir("o261-7-0", 261).
+		Rename("o261-7-0", "newdir").
+		Chown("newdir", 0, 0).
+		End().
+		Bytes()
+	require.NoError(t, err)
+
+	diff, err := pkg.ProcessBTRFSStream(bytes.NewReader(stream))
+	require.NoError(t, err)
+
+	require.Len(t, diff.GetDiffStruct(nil).Changed, 2)
+
+	diffStr := diff.GetDiffStruct(pkg.DiffIgnoreFunc(pkg.IgnoreDirMetadataChanges))
+	require.Len(t, diffStr.Changed, 1)
+	require.Equal(t, "/dir/file", diffStr.Changed[0].GetChainPath())
+	require.Len(t, diffStr.Added, 1)
+	require.Equal(t, "/newdir", diffStr.Added[0].GetChainPath())
+}
+
+func TestCaptureTimestamps(t *testing.T) {
+	ref := time.Date(2023, 9, 1, 12, 0, 0, 0, time.UTC)
+	stream, err := newTestStream().
+		Utimes("file", ref.Add(-72*time.Hour), ref.Add(-48*time.Hour), ref.Add(-48*time.Hour)).
+		Utimes("file", ref.Add(-3*time.Hour), ref.Add(-2*time.Hour), ref.Add(-2*time.Hour)).
+		End().
+		Bytes()
+	require.NoError(t, err)
+
+	diff, err := (&pkg.Processor{CaptureTimestamps: true}).Process(bytes.NewReader(stream))
+	require.NoError(t, err)
+
+	diffStr := diff.GetDiffStruct(nil)
+	require.Len(t, diffStr.Changed, 1)
+	node := diffStr.Changed[0]
+	require.Len(t, node.Changes, 1)
+	require.True(t, node.Times.MTime.Equal(ref.Add(-2*time.Hour)))
+	require.Equal(t, "[UNKNOWN][changed] /file [change=utime:atime=3h ago,mtime=2h ago,ctime=2h ago]", node.StringRelative(ref))
+}
+
+func TestShowInode(t *testing.T) {
+	diff, err := pkg.ProcessBTRFSStream(bytes.NewReader(buildStream(t, newTestStream().
+		MkFile("o257-7-0", 257).
+		Rename("o257-7-0", "file").
+		Link("hard", "file").
+		Unlink("old").
+		End())))
+	require.NoError(t, err)
+
+	strs := make(map[string]string)
+	diffStr := diff.GetDiffStruct(nil)
+	for _, n := range append(diffStr.Added, diffStr.Deleted...) {
+		require.NotContains(t, n.String(), "ino=")
+		strs[n.GetChainPath()] = n.StringWithInode()
+	}
+	require.Equal(t, "[FILE][added] /file [ino=257]", strs["/file"])
+	require.Contains(t, strs["/hard"], "[ino=257]")
+	require.Equal(t, "[UNKNOWN][deleted] /old [cause=unlink]", strs["/old"])
+}
+
+func TestCheckTypes(t *testing.T) {
+	stream, err := newTestStream().
+		// Nothing tells us whether these are files or dirs
+		Chmod("b", 0644).
+		Chown("a", 1000, 1000).
+		Write("file", 0, make([]byte, 10)).
+		End().
+		Bytes()
+	require.NoError(t, err)
+
+	diff, err := pkg.ProcessBTRFSStream(bytes.NewReader(stream))
+	require.NoError(t, err)
+
+	require.EqualError(t, diff.CheckTypes(nil), "found 2 nodes with unknown type: /a, /b")
+	require.NoError(t, diff.CheckTypes(pkg.DiffIgnoreFunc(func(f *pkg.DiffNode) bool {
+		return f.Path != "file"
+	})))
+}
+
+func TestSameDirRename(t *testing.T) {
+	relations := func(n *pkg.DiffNode) []string {
+		var rels []string
+		for _, rel := range n.Relations {
+			rels = append(rels, fmt.Sprintf("%s:%s", rel.Node.GetChainPath(), rel.Reason))
+		}
+		return rels
+	}
+
+	// mv dir/a dir/b
+	diff, err := pkg.ProcessBTRFSStream(bytes.NewReader(buildStream(t, newTestStream().
+		Rename("dir/a", "dir/b").
+		End())))
+	require.NoError(t, err)
+	diffStr := diff.GetDiffStruct(nil)
+	require.Len(t, diffStr.Added, 1)
+	require.Len(t, diffStr.Deleted, 1)
+	require.Equal(t, "/dir/b", diffStr.Added[0].GetChainPath())
+	require.Equal(t, []string{"/dir/a:RENAME_SRC"}, relations(diffStr.Added[0]))
+	require.Equal(t, "/dir/a", diffStr.Deleted[0].GetChainPath())
+	require.Equal(t, []string{"/dir/b:RENAME_DEST"}, relations(diffStr.Deleted[0]))
+
+	// Swapping two files reuses both paths in the same directory, which must not hide where the
+	// renamed nodes come from
+	diff, err = pkg.ProcessBTRFSStream(bytes.NewReader(buildStream(t, newTestStream().
+		Rename("dir/a", "dir/o258-7-0").
+		Rename("dir/b", "dir/a").
+		Rename("dir/o258-7-0", "dir/b").
+		End())))
+	require.NoError(t, err)
+	diffStr = diff.GetDiffStruct(nil)
+	require.Len(t, diffStr.Added, 2)
+	for _, n := range diffStr.Added {
+		other := "/dir/a"
+		if n.GetChainPath() == "/dir/a" {
+			other = "/dir/b"
+		}
+		require.Equal(t, []string{other}, n.RenameHistory(), n.GetChainPath())
+	}
+
+	// A rename onto itself changes nothing
+	diff, err = pkg.ProcessBTRFSStream(bytes.NewReader(buildStream(t, newTestStream().
+		Rename("dir/a", "dir/a").
+		End())))
+	require.NoError(t, err)
+	require.Zero(t, diff.Stats(nil).Total())
+}
+
+func TestFakeRenameSourceParent(t *testing.T) {
+	// Sources not seen before in the stream are tracked under the parent of their own path, not of the
+	// destination one
+	diff, err := pkg.ProcessBTRFSStream(bytes.NewReader(buildStream(t, newTestStream().
+		Rename("a.txt", "archive/a.txt").
+		Rename("a/x", "b/y").
+		End())))
+	require.NoError(t, err)
+
+	s := diff.GetDiffStruct(nil)
+	require.ElementsMatch(t, []string{"/archive/a.txt", "/b/y"}, getPaths(s.Added))
+	require.ElementsMatch(t, []string{"/a.txt", "/a/x"}, getPaths(s.Deleted))
+	require.Empty(t, s.Warnings)
+}
+
+func TestInvalidStream(t *testing.T) {
+	_, err := pkg.ProcessBTRFSStream(bytes.NewReader(nil))
+	require.ErrorIs(t, err, pkg.ErrEmptyStream)
+
+	for _, data := range []string{"btrfs-str", "not a stream at all", "btrfs-streamX\x01\x00\x00\x00"} {
+		_, err = pkg.ProcessBTRFSStream(strings.NewReader(data))
+		require.ErrorIs(t, err, pkg.ErrNotBTRFSStream, data)
+	}
+
+	// Padded header
+	stream, err := streamtest.NewBuilder().End().Bytes()
+	require.NoError(t, err)
+	padded := append([]byte("btrfs-stream\x00\x00\x00"), stream[len("btrfs-stream\x00"):]...)
+	_, err = pkg.ProcessBTRFSStream(bytes.NewReader(padded))
+	require.NoError(t, err)
+
+	// Truncated after the header
+	_, err = pkg.ProcessBTRFSStream(strings.NewReader("btrfs-stream\x00\x01"))
+	require.Error(t, err)
+	require.NotErrorIs(t, err, pkg.ErrNotBTRFSStream)
+}
+
+func TestPathSeparator(t *testing.T) {
+	// Backslashes (the Windows separator) are valid in btrfs names, and never split paths
+	diff, err := pkg.ProcessBTRFSStream(bytes.NewReader(buildStream(t, newTestStream().
+		MkDir(`dir\sub`, 1).
+		MkFile(`dir\sub/file`, 2).
+		MkFile(`C:\file`, 3).
+		End())))
+	require.NoError(t, err)
+
+	nodes := diff.FlatMap(nil)
+	require.Contains(t, nodes, `/dir\sub/file`)
+	require.Equal(t, `dir\sub`, nodes[`/dir\sub/file`].Parent.Path)
+	require.Equal(t, `C:\file`, nodes[`/C:\file`].Path)
+	require.NotContains(t, nodes, "/dir")
+
+	// Local file names use the host separator instead
+	diff, err = pkg.ProcessFile(filepath.Join(testDir, "inc-001.snap"))
+	require.NoError(t, err)
+	require.Equal(t, "001", diff.Meta.Path)
+}
+
+// Chain paths are unique in the tree, and a node is only listed in the deleted bucket once, either
+// because it has been deleted or because it has been deleted and created again
+func TestNoDuplicatePaths(t *testing.T) {
+	check := func(name string, diff *pkg.Diff) {
+		s := diff.GetDiffStruct(nil)
+		buckets := make(map[string][]pkg.DiffBucket)
+		for bucket, nodes := range map[pkg.DiffBucket][]*pkg.DiffNode{pkg.DiffBucketAdded: s.Added, pkg.DiffBucketChanged: s.Changed, pkg.DiffBucketDeleted: s.Deleted} {
+			for _, n := range nodes {
+				p := n.GetChainPath()
+				require.NotContains(t, buckets[p], bucket, "%s: duplicate %s in %s", name, p, bucket)
+				buckets[p] = append(buckets[p], bucket)
+			}
+		}
+		// Only nodes deleted and created again can be in more than one bucket
+		for p, b := range buckets {
+			if len(b) > 1 {
+				require.ElementsMatch(t, []pkg.DiffBucket{pkg.DiffBucketAdded, pkg.DiffBucketDeleted}, b, "%s: %s", name, p)
+				require.True(t, diff.FlatMap(nil)[p].DeletedInSnapshot, "%s: %s", name, p)
+			}
+		}
+	}
+
+	files, err := filepath.Glob(fmt.Sprintf("%s/*.snap", testDir))
+	require.NoError(t, err)
+	for _, file := range files {
+		diff, err := pkg.ProcessFile(file)
+		require.NoError(t, err)
+		check(file, diff)
+	}
+
+	diff, err := pkg.ProcessBTRFSStream(bytes.NewReader(buildStream(t, newTestStream().
+		Unlink("replaced").
+		MkFile("replaced", 1).
+		Rename("a", "b").
+		Rename("b", "c").
+		Unlink("c").
+		Rename("d", "a").
+		Rmdir("dir").
+		MkDir("dir", 2).
+		MkFile("dir/file", 3).
+		End())))
+	require.NoError(t, err)
+	check("builder", diff)
+}
+
+func TestDeletedAndCreatedAgain(t *testing.T) {
+	diff, err := pkg.ProcessBTRFSStream(bytes.NewReader(buildStream(t, newTestStream().
+		Unlink("replaced").
+		MkFile("replaced", 1).
+		Rmdir("dir").
+		MkFile("dir", 2).
+		Unlink("gone").
+		End())))
+	require.NoError(t, err)
+
+	s := diff.GetDiffStruct(nil)
+	require.ElementsMatch(t, []string{"/dir", "/replaced"}, getPaths(s.Added))
+	require.Empty(t, s.Changed)
+	require.ElementsMatch(t, []string{"/dir", "/gone", "/replaced"}, getPaths(s.Deleted))
+
+	// The same new node is in both buckets, carrying why the old one has been deleted
+	for _, n := range s.Deleted {
+		if n.GetChainPath() == "/gone" {
+			continue
+		}
+		require.Contains(t, s.Added, n)
+		require.True(t, n.DeletedInSnapshot)
+	}
+	m := diff.FlatMap(nil)
+	require.Equal(t, pkg.DiffDeleteCauseUnlink, m["/replaced"].DeleteCause)
+	require.Nil(t, m["/replaced"].TypeChange())
+	require.Equal(t, pkg.DiffDeleteCauseRmdir, m["/dir"].DeleteCause)
+	require.Equal(t, &pkg.DiffTypeChange{From: pkg.DiffNodeTypeDir, To: pkg.DiffNodeTypeFile}, m["/dir"].TypeChange())
+}
+
+func TestStrict(t *testing.T) {
+	p := &pkg.Processor{Strict: true, CaptureTimestamps: true}
+	files, err := filepath.Glob(fmt.Sprintf("%s/*.snap", testDir))
+	require.NoError(t, err)
+	for _, file := range files {
+		_, err := p.ProcessFile(file)
+		require.NoError(t, err, file)
+	}
+
+	stream := buildStream(t, newTestStream().
+		Command(pkg.BTRFS_SEND_C_CHMOD,
+			streamtest.AttrString(pkg.BTRFS_SEND_A_PATH, "file"),
+			streamtest.AttrUint64(pkg.BTRFS_SEND_A_MODE, 0644),
+			streamtest.AttrUint64(pkg.BTRFS_SEND_A_UID, 1000),
+		).
+		End())
+	_, err = pkg.ProcessBTRFSStream(bytes.NewReader(stream))
+	require.NoError(t, err)
+	_, err = p.Process(bytes.NewReader(stream))
+	require.ErrorContains(t, err, "12 leftover bytes after the params of command BTRFS_SEND_C_CHMOD at offset ")
+}
+
+func TestStreamErrorOffset(t *testing.T) {
+	stream := buildStream(t, newTestStream().
+		MkFile("file", 257).
+		End())
+	// Truncated in the middle of the END command, after the header, the snapshot and the mkfile
+	end := len(stream) - 10
+	_, err := pkg.ProcessBTRFSStream(bytes.NewReader(stream[:end+4]))
+	require.Error(t, err)
+	var streamErr *pkg.StreamError
+	require.ErrorAs(t, err, &streamErr)
+	require.Equal(t, int64(end), streamErr.Offset)
+
+	var buf bytes.Buffer
+	require.NoError(t, pkg.WriteErrorJSON(&buf, err))
+	require.JSONEq(t, fmt.Sprintf(`{"error":%q,"offset":%d}`, err.Error(), end), buf.String())
+
+	// Offsets count from the start of the input, across concatenated streams
+	_, err = pkg.ProcessConcatenated(bytes.NewReader(append(append([]byte{}, stream...), stream[:end+4]...)))
+	require.ErrorAs(t, err, &streamErr)
+	require.Equal(t, int64(len(stream)+end), streamErr.Offset)
+
+	// Errors of the stream header are at its start, and other errors have no offset
+	_, err = pkg.ProcessBTRFSStream(strings.NewReader("not a stream"))
+	require.ErrorAs(t, err, &streamErr)
+	require.Zero(t, streamErr.Offset)
+	require.ErrorIs(t, err, pkg.ErrNotBTRFSStream)
+
+	// Failures which are not about reading or decoding the stream have no offset
+	_, err = pkg.ProcessBTRFSStream(bytes.NewReader(buildStream(t, newTestStream().
+		MkFile("file", 257).
+		MkFile("file", 258).
+		End())))
+	require.ErrorContains(t, err, "found existing node")
+	require.False(t, errors.As(err, &streamErr))
+	require.NotContains(t, err.Error(), "at offset")
+
+	buf.Reset()
+	require.NoError(t, pkg.WriteErrorJSON(&buf, fmt.Errorf("failed")))
+	require.JSONEq(t, `{"error":"failed"}`, buf.String())
+}
+
+func TestMergeConflictingNodes(t *testing.T) {
+	stream := buildStream(t, newTestStream().
+		MkFile("a", 1).
+		Write("a", 0, make([]byte, 10)).
+		MkFile("a", 2).
+		MkFile("b", 3).
+		Chmod("c", 0644).
+		Rename("b", "c").
+		End())
+
+	_, err := (&pkg.Processor{}).Process(bytes.NewReader(stream))
+	require.ErrorContains(t, err, "found existing node")
+
+	diff, err := (&pkg.Processor{MergeConflictingNodes: true}).Process(bytes.NewReader(stream))
+	require.NoError(t, err)
+	require.Len(t, diff.Warnings, 2)
+	for _, w := range diff.Warnings {
+		require.Equal(t, pkg.DiffWarningCodeNodeConflict, w.Code)
+	}
+	require.Equal(t, []string{"a", "c"}, []string{diff.Warnings[0].Path, diff.Warnings[1].Path})
+
+	m := diff.FlatMap(nil)
+	require.Equal(t, 10, int(m["/a"].TotalBytesWritten()))
+	require.Equal(t, []string{"chmod:mode=644"}, m["/c"].Changes)
+	require.ElementsMatch(t, []string{"/a", "/c"}, getPaths(diff.GetDiffStruct(nil).Added))
+}
+
+func TestMergeConflictingNodesKeepsChanges(t *testing.T) {
+	diff, err := (&pkg.Processor{MergeConflictingNodes: true}).Process(bytes.NewReader(buildStream(t, newTestStream().
+		MkFile("a", 1).
+		Write("a", 0, []byte("#!/bin/sh\n")).
+		Chmod("a", 04755).
+		SetXattr("a", "user.tag", []byte("x")).
+		Truncate("a", 10).
+		MkFile("a", 2).
+		Write("a", 10, make([]byte, 5)).
+		End())))
+	require.NoError(t, err)
+
+	a := diff.FlatMap(nil)["/a"]
+	require.Equal(t, 15, int(a.TotalBytesWritten()))
+	require.Equal(t, []string{"write:offset=0:data_len=15", "chmod:mode=4755", "exec:gained", "setuid:gained", "set_xattr:name=user.tag,data=x", "truncate:size=10"}, a.Changes)
+	require.Len(t, a.Extents, 2)
+	require.Len(t, a.Xattrs, 1)
+	require.True(t, a.GainedExecutable)
+	require.True(t, a.GainedSetuid)
+	require.Equal(t, uint64(15), *a.FinalSize())
+
+	// The data written before the conflict is still known to be a script
+	var ids []string
+	for _, f := range diff.Audit(nil, pkg.DefaultAuditRules) {
+		ids = append(ids, f.Rule)
+	}
+	require.Contains(t, ids, "setuid-script")
+}
+
+func TestChurn(t *testing.T) {
+	inc1, err := newTestStream().
+		MkFile("tmp", 1).
+		MkFile("moved", 2).
+		MkDir("o259-12-0", 3).
+		Rename("o259-12-0", "tmpdir").
+		MkFile("kept", 4).
+		End().
+		Bytes()
+	require.NoError(t, err)
+	inc2, err := streamtest.NewBuilder().
+		Snapshot("003", "1caf3bd7c6a7e54e9ddae7a2c3e49e88", 14, "8ceaf94ac851d346841abc2b82323625", 12).
+		Unlink("tmp").
+		Rename("moved", "moved2").
+		Unlink("moved2").
+		Rename("tmpdir", "o259-12-0").
+		Rmdir("o259-12-0").
+		Unlink("preexisting").
+		End().
+		Bytes()
+	require.NoError(t, err)
+
+	diff, err := pkg.ProcessStreams(bytes.NewReader(inc1), bytes.NewReader(inc2))
+	require.NoError(t, err)
+
+	args := &pkg.ProcessFileWithOutputArgs{ChurnOnly: true}
+	var paths []string
+	for p := range diff.FlatMap(args.IgnoreMatcher()) {
+		paths = append(paths, p)
+	}
+	require.ElementsMatch(t, []string{"/tmp", "/moved2", "/tmpdir"}, paths)
+}
+
+func TestLeavesOnly(t *testing.T) {
+	stream, err := newTestStream().
+		MkDir("o257-12-0", 2).
+		Rename("o257-12-0", "dir").
+		MkDir("o258-12-0", 3).
+		Rename("o258-12-0", "dir/subdir").
+		MkDir("o259-12-0", 4).
+		Rename("o259-12-0", "dir/subdir/leafdir").
+		MkDir("o260-12-0", 5).
+		Rename("o260-12-0", "empty").
+		MkDir("o261-12-0", 6).
+		Rename("o261-12-0", "withfile").
+		MkFile("o262-12-0", 7).
+		Rename("o262-12-0", "withfile/file").
+		Chmod("existing", 0755).
+		End().
+		Bytes()
+	require.NoError(t, err)
+
+	diff, err := pkg.ProcessBTRFSStream(bytes.NewReader(stream))
+	require.NoError(t, err)
+
+	args := &pkg.ProcessFileWithOutputArgs{LeavesOnly: true}
+	var paths []string
+	for p := range diff.FlatMap(args.IgnoreMatcher()) {
+		paths = append(paths, p)
+	}
+	require.ElementsMatch(t, []string{"/dir/subdir/leafdir", "/empty", "/withfile/file", "/existing"}, paths)
+}
+
+func TestRootLabel(t *testing.T) {
+	stream, err := newTestStream().
+		MkFile("file", 1).
+		End().
+		Bytes()
+	require.NoError(t, err)
+
+	diff, err := pkg.ProcessBTRFSStream(bytes.NewReader(stream))
+	require.NoError(t, err)
+	root := diff.FlatMap(nil)["/file"].Parent
+
+	for label, expected := range map[string]string{
+		pkg.DiffRootLabelSlash:     "/",
+		pkg.DiffRootLabelDot:       ".",
+		pkg.DiffRootLabelSubvolume: "002",
+	} {
+		diff.RootLabel = label
+		require.Equal(t, expected, root.DisplayPath())
+		require.Contains(t, root.String(), " "+expected)
+	}
+
+	// The outputs set the label of the diff they write
+	diff.RootLabel = ""
+	require.NoError(t, pkg.WriteDiff(io.Discard, diff, &pkg.ProcessFileWithOutputArgs{Format: pkg.OutputFormatJSON, RootLabel: pkg.DiffRootLabelDot}))
+	require.Equal(t, ".", root.DisplayPath())
+	require.ErrorContains(t, pkg.WriteDiff(io.Discard, diff, &pkg.ProcessFileWithOutputArgs{RootLabel: "root"}), `unsupported root label "root"`)
+}
+
+func TestRenamesOnly(t *testing.T) {
+	diff, err := pkg.ProcessBTRFSStream(bytes.NewReader(buildStream(t, newTestStream().
+		Rename("docs/a.txt", "archive/a.txt").
+		Rename("old_dir", "new_dir").
+		Rename("edited", "moved_edited").
+		Write("moved_edited", 0, make([]byte, 10)).
+		Rename("chmoded", "moved_chmoded").
+		Chmod("moved_chmoded", 0600).
+		// Chain of renames, through a btrfs temporary name
+		Rename("chain", "o300-7-0").
+		Rename("o300-7-0", "chained").
+		// Created, then renamed
+		MkFile("o301-7-0", 301).
+		Rename("o301-7-0", "created").
+		End())))
+	require.NoError(t, err)
+
+	require.Equal(t, []*pkg.DiffMoveEntry{
+		{From: "/docs/a.txt", To: "/archive/a.txt"},
+		{From: "/chain", To: "/chained"},
+		{From: "/old_dir", To: "/new_dir"},
+	}, diff.PureRenames(nil))
+
+	var buf bytes.Buffer
+	require.NoError(t, pkg.WriteDiff(&buf, diff, &pkg.ProcessFileWithOutputArgs{RenamesOnly: true}))
+	require.Equal(t, "/docs/a.txt -> /archive/a.txt\n/chain -> /chained\n/old_dir -> /new_dir\n", buf.String())
+
+	buf.Reset()
+	args := &pkg.ProcessFileWithOutputArgs{RenamesOnly: true, Format: pkg.OutputFormatJSON, IgnorePaths: pkg.DiffIgnorePaths{regexp.MustCompile("^/archive")}}
+	require.NoError(t, pkg.WriteDiff(&buf, diff, args))
+	require.JSONEq(t, `[{"from":"/chain","to":"/chained"},{"from":"/old_dir","to":"/new_dir"}]`, buf.String())
+
+	require.Error(t, pkg.WriteDiff(&buf, diff, &pkg.ProcessFileWithOutputArgs{RenamesOnly: true, Format: pkg.OutputFormatNames}))
+	require.Error(t, pkg.WriteDiff(&buf, diff, &pkg.ProcessFileWithOutputArgs{RenamesOnly: true, CountOnly: true}))
+}
+
+func TestOTime(t *testing.T) {
+	otime := time.Date(2023, 8, 30, 4, 2, 25, 500, time.UTC)
+	b := streamtest.NewBuilder()
+	diff, err := pkg.ProcessBTRFSStream(bytes.NewReader(buildStream(t, b.
+		Command(pkg.BTRFS_SEND_C_SUBVOL,
+			streamtest.AttrString(pkg.BTRFS_SEND_A_PATH, "000"),
+			streamtest.AttrString(pkg.BTRFS_SEND_A_UUID, "0123456789abcdef"),
+			streamtest.AttrUint64(pkg.BTRFS_SEND_A_CTRANSID, 6),
+			streamtest.AttrTime(pkg.BTRFS_SEND_A_OTIME, otime),
+		).
+		End())))
+	require.NoError(t, err)
+	require.NotNil(t, diff.Meta.OTime)
+	require.True(t, otime.Equal(*diff.Meta.OTime))
+
+	jsonBytes, err := json.Marshal(diff.GetDiffStruct(nil).Meta)
+	require.NoError(t, err)
+	require.Contains(t, string(jsonBytes), `"otime":"2023-08-30T`)
+
+	// Streams sent by the kernel have no otime
+	diff, err = pkg.ProcessFile(fmt.Sprintf("%s/inc-001.snap", testDir))
+	require.NoError(t, err)
+	require.Nil(t, diff.Meta.OTime)
+}
+
+func TestCreateAndUtimes(t *testing.T) {
+	now := time.Date(2023, 8, 30, 4, 2, 25, 0, time.UTC)
+	stream := buildStream(t, newTestStream().
+		MkFile("created-first", 1).
+		Utimes("created-first", now, now, now).
+		Utimes("touched-first", now, now, now).
+		MkFile("touched-first", 2).
+		// The parent is implicitly added before being created
+		Utimes("dir/file", now, now, now).
+		MkDir("dir", 3).
+		Utimes("existing", now, now, now).
+		End())
+
+	p := &pkg.Processor{CaptureTimestamps: true}
+	diff, err := p.Process(bytes.NewReader(stream))
+	require.NoError(t, err)
+
+	s := diff.GetDiffStruct(nil)
+	require.ElementsMatch(t, []string{"/created-first", "/touched-first", "/dir"}, getPaths(s.Added))
+	require.ElementsMatch(t, []string{"/dir/file", "/existing"}, getPaths(s.Changed))
+	for _, n := range s.Added {
+		require.True(t, n.CreatedInSnapshot)
+	}
+	require.Equal(t, pkg.DiffNodeTypeFile, diff.FlatMap(nil)["/touched-first"].NodeType)
+
+	// Creating a node twice is still an error
+	stream = buildStream(t, newTestStream().
+		MkFile("file", 1).
+		MkFile("file", 2).
+		End())
+	_, err = p.Process(bytes.NewReader(stream))
+	require.ErrorContains(t, err, "found existing node")
+}
+
+func TestIgnoreTimestamps(t *testing.T) {
+	now := time.Date(2023, 8, 30, 4, 2, 25, 0, time.UTC)
+	stream := buildStream(t, newTestStream().
+		MkFile("created", 1).
+		Utimes("created", now, now, now).
+		Utimes("touched", now, now, now).
+		Chmod("chmoded", 0644).
+		Utimes("chmoded", now, now, now).
+		End())
+
+	p := &pkg.Processor{CaptureTimestamps: true, IgnoreTimestamps: true}
+	diff, err := p.Process(bytes.NewReader(stream))
+	require.NoError(t, err)
+
+	s := diff.GetDiffStruct(nil)
+	require.Equal(t, []string{"/created"}, getPaths(s.Added))
+	require.Equal(t, []string{"/chmoded"}, getPaths(s.Changed))
+	require.Equal(t, []string{"chmod:mode=644"}, s.Changed[0].Changes)
+	require.Nil(t, s.Changed[0].Times)
+}
+
+func TestWarnings(t *testing.T) {
+	diff, err := pkg.ProcessFile(fmt.Sprintf("%s/inc-015.snap", testDir))
+	require.NoError(t, err)
+	require.Len(t, diff.Warnings, 1)
+	require.Equal(t, pkg.DiffWarningCodeLinkDestNotFound, diff.Warnings[0].Code)
+
+	diffStr := diff.GetDiffStruct(nil)
+	require.Equal(t, diff.Warnings, diffStr.Warnings)
+
+	jsonBytes, err := json.Marshal(diffStr)
+	require.NoError(t, err)
+	require.Contains(t, string(jsonBytes), `"warnings":[`)
+
+	// Diffs without warnings leave the key out
+	diff, err = pkg.ProcessFile(fmt.Sprintf("%s/inc-001.snap", testDir))
+	require.NoError(t, err)
+	jsonBytes, err = json.Marshal(diff.GetDiffStruct(nil))
+	require.NoError(t, err)
+	require.NotContains(t, string(jsonBytes), `"warnings"`)
+}
+
+func TestDeleteCause(t *testing.T) {
+	for idx, expected := range map[int]map[string]pkg.DiffDeleteCause{
+		9:  {"/bar/foo_file": pkg.DiffDeleteCauseUnlink},
+		10: {"/bar": pkg.DiffDeleteCauseRmdir, "/bar/baaz_file": pkg.DiffDeleteCauseUnlink},
+		23: {"/dir": pkg.DiffDeleteCauseRename},
+	} {
+		diff, err := pkg.ProcessFile(fmt.Sprintf("%s/inc-%03d.snap", testDir, idx))
+		require.NoError(t, err)
+
+		causes := make(map[string]pkg.DiffDeleteCause)
+		for _, node := range diff.GetDiffStruct(nil).Deleted {
+			causes[node.GetChainPath()] = node.DeleteCause
+		}
+		require.Equal(t, expected, causes)
+	}
+}
+
+func TestDeepRemoval(t *testing.T) {
+	// Like `rm -rf a` on a deep tree: every directory is orphanized (renamed to a btrfs temporary name)
+	// before its children, which are orphanized in turn before being deleted
+	diff, err := pkg.ProcessBTRFSStream(bytes.NewReader(buildStream(t, newTestStream().
+		Rename("a", "o257-5-0").
+		Unlink("o257-5-0/file").
+		Rename("o257-5-0/b", "o258-5-0").
+		Rmdir("o257-5-0").
+		Rename("o258-5-0/c", "o259-5-0").
+		Rmdir("o258-5-0").
+		Unlink("o259-5-0/leaf").
+		Rmdir("o259-5-0").
+		// Children orphanized before their parent
+		Rename("x/y", "o260-5-0").
+		Rename("x", "o261-5-0").
+		Rmdir("o261-5-0").
+		Unlink("o260-5-0/leaf").
+		Rmdir("o260-5-0").
+		// No node can be deleted without a parent
+		Rmdir("").
+		End())))
+	require.NoError(t, err)
+
+	s := diff.GetDiffStruct(nil)
+	var deleted []string
+	for _, n := range s.Deleted {
+		deleted = append(deleted, n.GetChainPath())
+	}
+	require.ElementsMatch(t, []string{"/a", "/a/file", "/a/b", "/a/b/c", "/a/b/c/leaf", "/x", "/x/y", "/x/y/leaf"}, deleted)
+	require.Empty(t, s.Added)
+
+	require.Len(t, s.Warnings, 1)
+	require.Equal(t, pkg.DiffWarningCodeDeleteWithoutParent, s.Warnings[0].Code)
+}
+
+func TestCommandCount(t *testing.T) {
+	stream := buildStream(t, newTestStream().
+		MkFile("file", 257).
+		Write("file", 0, []byte("data")).
+		Utimes("file", time.Unix(1, 0), time.Unix(2, 0), time.Unix(3, 0)).
+		End())
+
+	// Ignored commands count as well
+	diff, err := pkg.ProcessBTRFSStream(bytes.NewReader(stream))
+	require.NoError(t, err)
+	require.Equal(t, 5, diff.CommandCount)
+
+	jsonBytes, err := json.Marshal(diff.GetDiffStruct(nil))
+	require.NoError(t, err)
+	require.Contains(t, string(jsonBytes), `"command_count":5`)
+
+	// Commands are counted across all the streams
+	next := buildStream(t, streamtest.NewBuilder().
+		Snapshot("003", "4379e89e4c343e468229796bca6cbb49", 14, "8ceaf94ac851d346841abc2b82323625", 12).
+		Unlink("file").
+		End())
+	diff, err = pkg.ProcessStreams(bytes.NewReader(stream), bytes.NewReader(next))
+	require.NoError(t, err)
+	require.Equal(t, 8, diff.CommandCount)
+}
+
+func TestFlatMap(t *testing.T) {
+	diff, err := pkg.ProcessBTRFSStream(bytes.NewReader(buildStream(t, newTestStream().
+		MkFile("o257-12-0", 257).
+		Rename("o257-12-0", "dir/file").
+		Write("dir/file", 0, []byte("data")).
+		Unlink("replaced").
+		MkFile("replaced", 258).
+		Unlink("gone").
+		End())))
+	require.NoError(t, err)
+
+	m := diff.FlatMap(nil)
+	var keys []string
+	for p, n := range m {
+		require.Equal(t, p, n.GetChainPath())
+		keys = append(keys, p)
+	}
+	// Implied parents and btrfs temporary nodes are not reportable, and re-created nodes are only
+	// returned once, even if they are in both the added and deleted buckets
+	require.ElementsMatch(t, []string{"/dir/file", "/replaced", "/gone"}, keys)
+	require.True(t, m["/replaced"].DeletedInSnapshot)
+
+	m = diff.FlatMap(pkg.DiffIgnorePaths{regexp.MustCompile(`^/dir/`)})
+	require.Nil(t, m["/dir/file"])
+	require.Len(t, m, 2)
+}
+
+func TestWritePreview(t *testing.T) {
+	var buf bytes.Buffer
+	p := &pkg.Processor{InfoLogger: log.New(&buf, "", 0)}
+	diff, err := p.Process(bytes.NewReader(buildStream(t, newTestStream().
+		MkFile("file", 257).
+		Write("file", 0, []byte("valid utf-8, still file data")).
+		SetXattr("file", "user.label", []byte("text")).
+		End())))
+	require.NoError(t, err)
+
+	// File contents are never previewed as text, while xattr values are when they are valid UTF-8
+	require.Contains(t, buf.String(), "modified: write at file at 0: bytes:len=28\n")
+	require.NotContains(t, buf.String(), "still file data")
+	require.Contains(t, diff.FlatMap(nil)["/file"].Changes, "set_xattr:name=user.label,data=text")
+}
@@ -0,0 +1,39 @@
+package pkg
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+)
+
+// ErrTripwireTriggered is matched (see errors.Is) by the TripwireError returned when a path watched by
+// Processor.Tripwire is deleted
+var ErrTripwireTriggered = errors.New("tripwire triggered")
+
+// TripwireError stops the processing as soon as a watched path is deleted
+type TripwireError struct {
+	// The deleted path which matched the tripwire
+	Path string
+}
+
+func (e *TripwireError) Error() string {
+	return fmt.Sprintf("%s: %s has been deleted", ErrTripwireTriggered, e.Path)
+}
+
+func (e *TripwireError) Is(target error) bool {
+	return target == ErrTripwireTriggered
+}
+
+// checkTripwire returns a TripwireError if any of the deleted nodes is watched by the tripwire. Renames
+// delete their source as well, but only unlink and rmdir count as deletions.
+func (d *Diff) checkTripwire(command *commandInst, nodes ...*DiffNode) error {
+	tripwire := d.proc().Tripwire
+	if tripwire == nil || command.OriginalType == BTRFS_SEND_C_RENAME {
+		return nil
+	}
+	for _, n := range nodes {
+		if p := n.GetChainPath(); tripwire.MatchString(p) {
+			return &TripwireError{p}
+		}
+	}
+	return nil
+}
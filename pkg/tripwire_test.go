@@ -0,0 +1,34 @@
+package pkg_test
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/cmaster11/btrfs-diff/pkg"
+	"github.com/stretchr/testify/require"
+	"regexp"
+	"testing"
+)
+
+func TestTripwire(t *testing.T) {
+	p := &pkg.Processor{Tripwire: regexp.MustCompile(`^/(etc|bar)(/|$)`)}
+
+	// The rest of the stream is not processed, or the unknown command would fail it
+	_, err := p.Process(bytes.NewReader(buildStream(t, newTestStream().
+		Rename("etc/hosts", "etc/hosts.old").
+		Unlink("var/file").
+		Unlink("etc/passwd").
+		Command(pkg.BTRFS_SEND_C_MAX+10).
+		End())))
+	require.ErrorIs(t, err, pkg.ErrTripwireTriggered)
+	var tripwireErr *pkg.TripwireError
+	require.ErrorAs(t, err, &tripwireErr)
+	require.Equal(t, "/etc/passwd", tripwireErr.Path)
+
+	// Directories are orphanized (renamed to a temporary name) before their contents are deleted
+	_, err = p.ProcessFile(fmt.Sprintf("%s/inc-010.snap", testDir))
+	require.ErrorAs(t, err, &tripwireErr)
+	require.Equal(t, "/bar/baaz_file", tripwireErr.Path)
+
+	_, err = pkg.ProcessFile(fmt.Sprintf("%s/inc-010.snap", testDir))
+	require.NoError(t, err)
+}
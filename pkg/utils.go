@@ -2,9 +2,58 @@ package pkg
 
 import (
 	"bufio"
+	"context"
+	"fmt"
 	"github.com/pkg/errors"
+	"io"
+	"strconv"
+	"time"
 )
 
+// countingReader counts the bytes read from the underlying reader
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// contextReader fails all reads once the context is done, so that slow readers are not read any further.
+// A read already blocked on the underlying reader (e.g. a pipe) is only interrupted if the reader is an
+// io.Closer, which newContextReader closes as soon as the context is done.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+// newContextReader returns a contextReader on r, and a function to call once done reading it, after
+// which r is no longer closed when the context is done
+func newContextReader(ctx context.Context, r io.Reader) (*contextReader, func() bool) {
+	stop := func() bool { return false }
+	if closer, ok := r.(io.Closer); ok {
+		stop = context.AfterFunc(ctx, func() { _ = closer.Close() })
+	}
+	return &contextReader{ctx, r}, stop
+}
+
+func (c *contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := c.r.Read(p)
+	if err != nil {
+		// Most likely failed because the reader has been closed by newContextReader
+		if ctxErr := c.ctx.Err(); ctxErr != nil {
+			return n, ctxErr
+		}
+	}
+	return n, err
+}
+
 // peekAndDiscard return n bytes from the stream buffer, if required increase its size
 func peekAndDiscard(input *bufio.Reader, n int) ([]byte, error) {
 	buffered := input.Buffered()
@@ -31,3 +80,61 @@ func ellipsis(s string, maxLen int) string {
 	}
 	return string(runes[0:maxLen-3]) + "..."
 }
+
+// humanizeTimeSince renders the distance between t and ref as e.g. "3d ago" or "in 2h"
+func humanizeTimeSince(t time.Time, ref time.Time) string {
+	d := ref.Sub(t)
+	future := d < 0
+	if future {
+		d = -d
+	}
+
+	var s string
+	switch {
+	case d < time.Second:
+		return "now"
+	case d < time.Minute:
+		s = fmt.Sprintf("%ds", int(d/time.Second))
+	case d < time.Hour:
+		s = fmt.Sprintf("%dm", int(d/time.Minute))
+	case d < 24*time.Hour:
+		s = fmt.Sprintf("%dh", int(d/time.Hour))
+	default:
+		s = fmt.Sprintf("%dd", int(d/(24*time.Hour)))
+	}
+
+	if future {
+		return "in " + s
+	}
+	return s + " ago"
+}
+
+type BytesFormat = string
+
+const (
+	BytesFormatHuman BytesFormat = "human"
+	BytesFormatRaw   BytesFormat = "raw"
+)
+
+// formatBytes renders an amount of bytes for the text outputs, with binary units unless the raw format
+// is requested. JSON outputs always have raw integers.
+func formatBytes(b uint64, format BytesFormat) string {
+	if format == BytesFormatRaw {
+		return strconv.FormatUint(b, 10)
+	}
+	return HumanizeBytes(b)
+}
+
+// HumanizeBytes renders an amount of bytes with binary units, e.g. "1.5 MiB"
+func HumanizeBytes(b uint64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%d B", b)
+	}
+	div, exp := uint64(unit), 0
+	for n := b / unit; n >= unit && exp < 5; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(b)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,56 @@
+package pkg
+
+import (
+	"github.com/pkg/errors"
+)
+
+// Validate checks the structure of the diff tree: every node reachable from the root has to be the
+// child of its Parent under its own Path, and reachable only once. Nodes which are only related to the
+// tree (e.g. the sources of renames replaced by new nodes) can be detached from it, but cannot claim a
+// parent which does not have them as a child.
+func (d *Diff) Validate() error {
+	if d.root.Parent != nil {
+		return errors.New("the root node has a parent")
+	}
+
+	seen := map[*DiffNode]bool{d.root: true}
+	var related []*DiffNode
+	var walk func(n *DiffNode) error
+	walk = func(n *DiffNode) error {
+		for key, child := range n.Children {
+			if child == nil {
+				return errors.Errorf("node %s has a nil child %s", n.DisplayPath(), key)
+			}
+			if seen[child] {
+				return errors.Errorf("node %s is linked more than once in the tree, last under %s", child.DisplayPath(), n.DisplayPath())
+			}
+			seen[child] = true
+			if child.Parent != n {
+				return errors.Errorf("node %s is a child of %s, but has another parent", child.DisplayPath(), n.DisplayPath())
+			}
+			if child.Path != key {
+				return errors.Errorf("node %s is a child of %s under the name %s", child.DisplayPath(), n.DisplayPath(), key)
+			}
+			for _, rel := range child.Relations {
+				related = append(related, rel.Node)
+			}
+			if err := walk(child); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := walk(d.root); err != nil {
+		return err
+	}
+
+	for _, n := range related {
+		if seen[n] || n.Parent == nil {
+			continue
+		}
+		if n.Parent.Children[n.Path] != n {
+			return errors.Errorf("node %s is orphaned: it is not a child of its parent", n.DisplayPath())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package pkg_test
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/cmaster11/btrfs-diff/internal/streamtest"
+	"github.com/cmaster11/btrfs-diff/pkg"
+	"github.com/stretchr/testify/require"
+	"path/filepath"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	files, err := filepath.Glob(fmt.Sprintf("%s/*.snap", testDir))
+	require.NoError(t, err)
+	for _, file := range files {
+		diff, err := pkg.ProcessFile(file)
+		require.NoError(t, err)
+		require.NoError(t, diff.Validate(), file)
+	}
+
+	// A directory created under a btrfs temporary name with its children, then renamed and removed
+	diff, err := pkg.ProcessStreams(
+		bytes.NewReader(buildStream(t, newTestStream().
+			MkDir("o257-7-0", 257).
+			MkFile("o257-7-0/file", 258).
+			Rename("o257-7-0", "dir").
+			End())),
+		bytes.NewReader(buildStream(t, streamtest.NewBuilder().
+			Snapshot("003", "4379e89e4c343e468229796bca6cbb49", 14, "8ceaf94ac851d346841abc2b82323625", 12).
+			Rename("dir", "moved").
+			Write("moved/file", 0, []byte("data")).
+			End())),
+	)
+	require.NoError(t, err)
+	require.NoError(t, diff.Validate())
+	flat := diff.FlatMap(nil)
+	require.Contains(t, flat, "/moved/file")
+	require.NotContains(t, flat, "/o257-7-0/file")
+	require.NotContains(t, flat, "/dir/file")
+
+	// Corrupted trees
+	flat["/moved/file"].Parent = nil
+	require.ErrorContains(t, diff.Validate(), "has another parent")
+	flat["/moved/file"].Parent = flat["/moved"]
+	require.NoError(t, diff.Validate())
+	flat["/moved/file"].Children["loop"] = flat["/moved"]
+	require.ErrorContains(t, diff.Validate(), "linked more than once")
+}
@@ -0,0 +1,175 @@
+package pkg
+
+import (
+	"bufio"
+	"context"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// ReadMeta reads only the header and the first command of a stream, returning the info of the
+// subvolume/snapshot it has been sent from
+func (p *Processor) ReadMeta(r io.Reader) (*DiffMeta, error) {
+	input := bufio.NewReader(r)
+	ver, err := validateBTRFSStream(input)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to validate btrfs stream")
+	}
+	command, err := p.readCommand(input, ver)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read command")
+	}
+	defer command.release()
+	if command.OriginalType != BTRFS_SEND_C_SUBVOL && command.OriginalType != BTRFS_SEND_C_SNAPSHOT {
+		return nil, errors.Errorf("stream starts with %s instead of a subvol or snapshot", command.Type.Name)
+	}
+	return readMeta(command)
+}
+
+// Watcher polls a directory for new stream files, and applies them to a running tree, the same
+// way as ProcessFiles. Files can arrive out of order: they are sorted by their ctransid, and each
+// one waits until the snapshot it is an incremental of has been applied.
+type Watcher struct {
+	// Processor parses the streams, one with the default settings (see NewProcessor) if nil
+	Processor *Processor
+	Dir       string
+	// Pattern matches the names of the stream files, "*.snap" if empty
+	Pattern string
+	// Interval between two polls of the directory, one second if zero
+	Interval time.Duration
+	// OnApply is called with the cumulative diff after applying each file
+	OnApply func(fileName string, d *Diff) error
+}
+
+type watchState struct {
+	diff *Diff
+	// sizes of the files seen in the last poll, a file is only read once it stops growing
+	sizes   map[string]int64
+	pending map[string]*DiffMeta
+	done    map[string]bool
+}
+
+// Run polls the directory until the context is done, or applying a file fails
+func (w *Watcher) Run(ctx context.Context) error {
+	p := w.Processor
+	if p == nil {
+		p = NewProcessor()
+	}
+	interval := w.Interval
+	if interval == 0 {
+		interval = time.Second
+	}
+
+	state := &watchState{
+		diff:    newDiff(p),
+		sizes:   make(map[string]int64),
+		pending: make(map[string]*DiffMeta),
+		done:    make(map[string]bool),
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := w.poll(ctx, state); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (w *Watcher) poll(ctx context.Context, state *watchState) error {
+	p := state.diff.proc()
+	pattern := w.Pattern
+	if pattern == "" {
+		pattern = "*.snap"
+	}
+
+	fileNames, err := filepath.Glob(filepath.Join(w.Dir, pattern))
+	if err != nil {
+		return errors.Wrap(err, "bad pattern")
+	}
+	for _, fileName := range fileNames {
+		if state.done[fileName] || state.pending[fileName] != nil {
+			continue
+		}
+		stat, err := os.Stat(fileName)
+		if err != nil {
+			return errors.Wrapf(err, "failed to stat file %s", fileName)
+		}
+		if size, ok := state.sizes[fileName]; !ok || size != stat.Size() || size == 0 {
+			state.sizes[fileName] = stat.Size()
+			continue
+		}
+		delete(state.sizes, fileName)
+
+		meta, err := readFileMeta(p, fileName)
+		if err != nil {
+			return errors.Wrapf(err, "failed to read meta of file %s", fileName)
+		}
+		state.pending[fileName] = meta
+	}
+
+	for {
+		fileName := state.next()
+		if fileName == "" {
+			return nil
+		}
+		delete(state.pending, fileName)
+		state.done[fileName] = true
+
+		if err := state.diff.processFile(ctx, fileName); err != nil {
+			return errors.Wrapf(err, "failed to process file %s", fileName)
+		}
+		if w.OnApply != nil {
+			if err := w.OnApply(fileName, state.diff); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// next returns the pending file to apply next, if any. Files older than the last applied one can
+// never be applied anymore, so they are dropped.
+func (s *watchState) next() string {
+	var names []string
+	for name := range s.pending {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		a, b := s.pending[names[i]], s.pending[names[j]]
+		if a.CTransID != b.CTransID {
+			return a.CTransID < b.CTransID
+		}
+		return names[i] < names[j]
+	})
+
+	for _, name := range names {
+		meta := s.pending[name]
+		if s.diff.Meta == nil || meta.CloneUUID == s.diff.Meta.UUID {
+			return name
+		}
+		if meta.CTransID <= s.diff.Meta.CTransID {
+			s.diff.proc().info("skipping %s: not newer than the already applied %s", name, s.diff.Meta.Path)
+			delete(s.pending, name)
+			s.done[name] = true
+		}
+	}
+	return ""
+}
+
+func readFileMeta(p *Processor, fileName string) (*DiffMeta, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open file")
+	}
+	defer f.Close()
+	return p.ReadMeta(f)
+}
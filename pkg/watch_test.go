@@ -0,0 +1,56 @@
+package pkg_test
+
+import (
+	"context"
+	"fmt"
+	"github.com/cmaster11/btrfs-diff/pkg"
+	"github.com/stretchr/testify/require"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestWatcher(t *testing.T) {
+	dir := t.TempDir()
+	copyFile := func(idx int) {
+		data, err := os.ReadFile(fmt.Sprintf("%s/inc-%03d.snap", testDir, idx))
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(fmt.Sprintf("%s/%d.snap", dir, 10-idx), data, 0o644))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var applied []string
+	var last *pkg.Diff
+	w := &pkg.Watcher{
+		Dir:      dir,
+		Interval: 10 * time.Millisecond,
+		OnApply: func(fileName string, d *pkg.Diff) error {
+			applied = append(applied, d.Meta.Path)
+			last = d
+			switch len(applied) {
+			case 1:
+				copyFile(2)
+			case 3:
+				cancel()
+			}
+			return nil
+		},
+	}
+	// Out of order: 3 has to wait for 2
+	copyFile(1)
+	copyFile(3)
+
+	err := w.Run(ctx)
+	require.ErrorIs(t, err, context.Canceled)
+	require.Equal(t, []string{"001", "002", "003"}, applied)
+
+	expected, err := pkg.ProcessFiles(
+		fmt.Sprintf("%s/inc-001.snap", testDir),
+		fmt.Sprintf("%s/inc-002.snap", testDir),
+		fmt.Sprintf("%s/inc-003.snap", testDir),
+	)
+	require.NoError(t, err)
+	require.ElementsMatch(t, getPaths(expected.GetDiffStruct(nil).Added), getPaths(last.GetDiffStruct(nil).Added))
+}
@@ -0,0 +1,150 @@
+package pkg
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"github.com/pkg/errors"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// DiffXattrEncoding tells how the value of a set xattr is stored in DiffXattrChange.Value
+type DiffXattrEncoding = string
+
+const (
+	// The value is kept as is, being text (e.g. a SELinux label, with its trailing null byte)
+	DiffXattrEncodingUTF8 DiffXattrEncoding = "utf8"
+	// The value is binary (e.g. security.capability, or POSIX ACLs) and stored as standard base64
+	DiffXattrEncodingBase64 DiffXattrEncoding = "base64"
+)
+
+// POSIX ACLs are stored in these xattrs, see decodePosixACL
+const (
+	xattrPosixACLAccess  = "system.posix_acl_access"
+	xattrPosixACLDefault = "system.posix_acl_default"
+)
+
+// newDiffXattrSet records an xattr set to data, copying it out of the command
+func newDiffXattrSet(name string, data []byte) *DiffXattrChange {
+	if isXattrText(data) {
+		return &DiffXattrChange{Op: DiffXattrOpSet, Name: name, Value: string(data), Encoding: DiffXattrEncodingUTF8}
+	}
+	return &DiffXattrChange{Op: DiffXattrOpSet, Name: name, Value: base64.StdEncoding.EncodeToString(data), Encoding: DiffXattrEncodingBase64}
+}
+
+// isXattrText returns whether an xattr value is text: valid UTF-8 without control characters, except
+// for a trailing null byte (as many tools store strings with it)
+func isXattrText(data []byte) bool {
+	s := strings.TrimSuffix(string(data), "\x00")
+	if !utf8.ValidString(s) {
+		return false
+	}
+	for _, r := range s {
+		if unicode.IsControl(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// Data returns the raw value of a set xattr
+func (x *DiffXattrChange) Data() ([]byte, error) {
+	if x.Encoding == DiffXattrEncodingBase64 {
+		data, err := base64.StdEncoding.DecodeString(x.Value)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid value of xattr %s", x.Name)
+		}
+		return data, nil
+	}
+	return []byte(x.Value), nil
+}
+
+// Tags and permissions of the entries of POSIX ACLs, from linux posix_acl_xattr.h
+const (
+	posixACLXattrVersion = 2
+
+	aclUserObj  = 0x01
+	aclUser     = 0x02
+	aclGroupObj = 0x04
+	aclGroup    = 0x08
+	aclMask     = 0x10
+	aclOther    = 0x20
+)
+
+// decodePosixACL renders the value of a POSIX ACL xattr the way getfacl does, in its short form
+// (e.g. "user::rw-,user:1000:r--,group::r--,mask::r--,other::r--")
+func decodePosixACL(data []byte) (string, error) {
+	if len(data) < 4 || (len(data)-4)%8 != 0 {
+		return "", errors.Errorf("invalid acl length %d", len(data))
+	}
+	if version := binary.LittleEndian.Uint32(data); version != posixACLXattrVersion {
+		return "", errors.Errorf("unsupported acl version %d", version)
+	}
+
+	var entries []string
+	for b := data[4:]; len(b) > 0; b = b[8:] {
+		tag := binary.LittleEndian.Uint16(b[0:2])
+		perm := binary.LittleEndian.Uint16(b[2:4])
+		id := binary.LittleEndian.Uint32(b[4:8])
+
+		var qualifier string
+		switch tag {
+		case aclUserObj:
+			qualifier = "user:"
+		case aclUser:
+			qualifier = fmt.Sprintf("user:%d", id)
+		case aclGroupObj:
+			qualifier = "group:"
+		case aclGroup:
+			qualifier = fmt.Sprintf("group:%d", id)
+		case aclMask:
+			qualifier = "mask:"
+		case aclOther:
+			qualifier = "other:"
+		default:
+			return "", errors.Errorf("unknown acl tag %#x", tag)
+		}
+		entries = append(entries, fmt.Sprintf("%s:%s", qualifier, permString(perm)))
+	}
+	return strings.Join(entries, ","), nil
+}
+
+func permString(perm uint16) string {
+	s := []byte("---")
+	for i, c := range []byte("rwx") {
+		if perm&(4>>i) != 0 {
+			s[i] = c
+		}
+	}
+	return string(s)
+}
+
+// aclStrings returns the decoded POSIX ACLs set on the node, as text output parts
+// (e.g. "[acl=user::rw-,group::r--,other::r--]", or "[default_acl=...]" for directory defaults)
+func (n *DiffNode) aclStrings() []string {
+	var parts []string
+	for _, x := range n.Xattrs {
+		if x.Op != DiffXattrOpSet {
+			continue
+		}
+		var label string
+		switch x.Name {
+		case xattrPosixACLAccess:
+			label = "acl"
+		case xattrPosixACLDefault:
+			label = "default_acl"
+		default:
+			continue
+		}
+		acl := "invalid"
+		if data, err := x.Data(); err == nil {
+			if decoded, err := decodePosixACL(data); err == nil {
+				acl = decoded
+			}
+		}
+		parts = append(parts, fmt.Sprintf("[%s=%s]", label, acl))
+	}
+	return parts
+}
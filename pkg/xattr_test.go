@@ -0,0 +1,78 @@
+package pkg_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"github.com/cmaster11/btrfs-diff/pkg"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestXattrPrefix(t *testing.T) {
+	stream := buildStream(t, newTestStream().
+		SetXattr("labeled", "security.selinux", []byte("system_u:object_r:bin_t:s0")).
+		RemoveXattr("unlabeled", "security.selinux").
+		SetXattr("user", "user.comment", []byte("hello")).
+		Write("written", 0, make([]byte, 10)).
+		End())
+
+	diff, err := pkg.ProcessBTRFSStream(bytes.NewReader(stream))
+	require.NoError(t, err)
+
+	args := &pkg.ProcessFileWithOutputArgs{XattrPrefix: "security."}
+	filtered := diff.FlatMap(args.IgnoreMatcher())
+	require.Len(t, filtered, 2)
+	require.Equal(t, []*pkg.DiffXattrChange{{Op: pkg.DiffXattrOpRemove, Name: "security.selinux"}}, filtered["/unlabeled"].Xattrs)
+
+	jsonBytes, err := json.Marshal(filtered["/labeled"])
+	require.NoError(t, err)
+	require.Contains(t, string(jsonBytes), `"xattrs":[{"op":"set","name":"security.selinux","value":"system_u:object_r:bin_t:s0","encoding":"utf8"}]`)
+}
+
+func TestXattrValues(t *testing.T) {
+	// user::rw-,user:1000:r--,group::r--,mask::r--,other::---
+	acl := []byte{2, 0, 0, 0}
+	for _, e := range []struct {
+		tag, perm uint16
+		id        uint32
+	}{{0x01, 6, 0xffffffff}, {0x02, 4, 1000}, {0x04, 4, 0xffffffff}, {0x10, 4, 0xffffffff}, {0x20, 0, 0xffffffff}} {
+		acl = binary.LittleEndian.AppendUint16(acl, e.tag)
+		acl = binary.LittleEndian.AppendUint16(acl, e.perm)
+		acl = binary.LittleEndian.AppendUint32(acl, e.id)
+	}
+	capability := []byte{0, 0, 0, 2, 0, 0x20, 0, 0, 0, 0, 0, 0}
+	stream := buildStream(t, newTestStream().
+		SetXattr("shared", "system.posix_acl_access", acl).
+		SetXattr("shared", "system.posix_acl_default", []byte{2, 0, 0, 0, 0x40, 0, 7, 0, 0, 0, 0, 0}).
+		SetXattr("ping", "security.capability", capability).
+		SetXattr("labeled", "security.selinux", []byte("system_u:object_r:bin_t:s0\x00")).
+		End())
+
+	diff, err := pkg.ProcessBTRFSStream(bytes.NewReader(stream))
+	require.NoError(t, err)
+	nodes := diff.FlatMap(nil)
+
+	// Binary values are in base64, text ones as they are
+	x := nodes["/ping"].Xattrs[0]
+	require.Equal(t, pkg.DiffXattrEncodingBase64, x.Encoding)
+	require.Equal(t, base64.StdEncoding.EncodeToString(capability), x.Value)
+	data, err := x.Data()
+	require.NoError(t, err)
+	require.Equal(t, capability, data)
+	x = nodes["/labeled"].Xattrs[0]
+	require.Equal(t, pkg.DiffXattrEncodingUTF8, x.Encoding)
+	require.Equal(t, "system_u:object_r:bin_t:s0\x00", x.Value)
+
+	jsonBytes, err := json.Marshal(nodes["/ping"])
+	require.NoError(t, err)
+	require.True(t, utf8.Valid(jsonBytes))
+	require.Contains(t, string(jsonBytes), `"value":"AAAAAgAgAAAAAAAA","encoding":"base64"`)
+
+	// ACLs are only decoded on request, invalid ones (with an unknown tag) are reported as such
+	require.NotContains(t, nodes["/shared"].String(), "[acl=")
+	require.Contains(t, nodes["/shared"].StringWithACLs(), " [acl=user::rw-,user:1000:r--,group::r--,mask::r--,other::---] [default_acl=invalid]")
+	require.NotContains(t, nodes["/ping"].StringWithACLs(), "acl=")
+}